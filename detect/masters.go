@@ -12,6 +12,8 @@ import (
 	"github.com/mesosphere/mesos-dns/logging"
 )
 
+var logger = logging.Log.With("component", "detect")
+
 var (
 	_ detector.MasterChanged = (*Masters)(nil)
 	_ detector.AllMasters    = (*Masters)(nil)
@@ -44,7 +46,7 @@ func NewMasters(masters []string, changed chan<- []string) *Masters {
 // leaving the remaining masters unchanged and emits the current masters state.
 // It implements the detector.MasterChanged interface.
 func (ms *Masters) OnMasterChanged(leader *mesos.MasterInfo) {
-	logging.VeryVerbose.Println("Updated leader: ", leader)
+	logger.Debug("leader updated", "leader", leader)
 	ms.masters = ordered(masterAddr(leader), ms.masters[1:])
 	emit(ms.changed, ms.masters)
 }
@@ -53,7 +55,7 @@ func (ms *Masters) OnMasterChanged(leader *mesos.MasterInfo) {
 // leaving the current leader unchanged and emits the current masters state.
 // It implements the detector.AllMasters interface.
 func (ms *Masters) UpdatedMasters(infos []*mesos.MasterInfo) {
-	logging.VeryVerbose.Println("Updated masters: ", infos)
+	logger.Debug("masters updated", "masters", infos)
 	masters := make([]string, 0, len(infos))
 	for _, info := range infos {
 		if addr := masterAddr(info); addr != "" {
@@ -0,0 +1,235 @@
+package exchanger
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/miekg/dns"
+)
+
+// Cache is a cache of DNS responses, bounded by their total size in bytes,
+// which evicts the least recently used responses first. Responses are cached
+// for as long as their TTL: the smallest TTL of their records, further bounded
+// by the negative caching TTL of their SOA record, as defined in RFC 2308, if
+// they're negative. It's safe for concurrent use.
+type Cache struct {
+	mu      sync.Mutex
+	maxSize int
+	size    int
+	lru     *list.List // of *cacheEntry, the most recently used first
+	entries map[cacheKey]*list.Element
+	now     func() time.Time
+}
+
+// cacheKey identifies the cacheable responses to a query.
+type cacheKey struct {
+	name          string
+	qtype, qclass uint16
+	do, cd        bool
+}
+
+// cacheEntry is a cached response.
+type cacheEntry struct {
+	key     cacheKey
+	msg     *dns.Msg
+	size    int
+	stored  time.Time
+	expires time.Time
+}
+
+// NewCache returns an empty Cache holding up to maxSize bytes of responses.
+func NewCache(maxSize int) *Cache {
+	return &Cache{
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: map[cacheKey]*list.Element{},
+		now:     time.Now,
+	}
+}
+
+// Caching returns a Decorator which answers the queries of an Exchanger with
+// the responses cached in c, if any, and caches its successful responses
+// otherwise, counting the cache hits and misses.
+func Caching(c *Cache, hits, misses logging.Counter) Decorator {
+	return func(ex Exchanger) Exchanger {
+		return Func(func(m *dns.Msg, a string) (*dns.Msg, time.Duration, error) {
+			key, ok := newCacheKey(m)
+			if !ok {
+				return ex.Exchange(m, a)
+			}
+			if r := c.get(key, m); r != nil {
+				hits.Inc()
+				return r, 0, nil
+			}
+			misses.Inc()
+			r, rtt, err := ex.Exchange(m, a)
+			if err == nil {
+				c.put(key, r)
+			}
+			return r, rtt, err
+		})
+	}
+}
+
+// newCacheKey returns the key of the responses to m, unless they can't be
+// cached.
+func newCacheKey(m *dns.Msg) (cacheKey, bool) {
+	if m.Opcode != dns.OpcodeQuery || len(m.Question) != 1 {
+		return cacheKey{}, false
+	}
+	q := m.Question[0]
+	key := cacheKey{
+		name:   strings.ToLower(q.Name),
+		qtype:  q.Qtype,
+		qclass: q.Qclass,
+		cd:     m.CheckingDisabled,
+	}
+	if opt := m.IsEdns0(); opt != nil {
+		key.do = opt.Do()
+	}
+	return key, true
+}
+
+// cacheTTL returns how long r can be cached, if at all.
+func cacheTTL(r *dns.Msg) (uint32, bool) {
+	if r.Truncated || (r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError) {
+		return 0, false
+	}
+	// negative responses are only cached with an SOA, for no longer than its
+	// minimum TTL: see https://tools.ietf.org/html/rfc2308#section-5
+	negative := r.Rcode == dns.RcodeNameError || len(r.Answer) == 0
+	ttl, ok, soa := uint32(0), false, false
+	for _, rrs := range [][]dns.RR{r.Answer, r.Ns, r.Extra} {
+		for _, rr := range rrs {
+			hdr := rr.Header()
+			if hdr.Rrtype == dns.TypeOPT {
+				continue
+			}
+			rrTTL := hdr.Ttl
+			if s, isSOA := rr.(*dns.SOA); isSOA && negative {
+				soa = true
+				if s.Minttl < rrTTL {
+					rrTTL = s.Minttl
+				}
+			}
+			if !ok || rrTTL < ttl {
+				ttl, ok = rrTTL, true
+			}
+		}
+	}
+	if negative && !soa {
+		return 0, false
+	}
+	return ttl, ttl > 0
+}
+
+// get returns a copy of the response cached under the given key, as a reply
+// to m with TTLs decremented by the time it spent in the cache, or nil if
+// there's none.
+func (c *Cache) get(key cacheKey, m *dns.Msg) *dns.Msg {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*cacheEntry)
+	now := c.now()
+	if !now.Before(e.expires) {
+		c.remove(el)
+		return nil
+	}
+	c.lru.MoveToFront(el)
+
+	r := e.msg.Copy()
+	r.Id = m.Id
+	r.Question = append([]dns.Question(nil), m.Question...)
+	elapsed := uint32(now.Sub(e.stored) / time.Second)
+	for _, rrs := range [][]dns.RR{r.Answer, r.Ns, r.Extra} {
+		for _, rr := range rrs {
+			if hdr := rr.Header(); hdr.Rrtype == dns.TypeOPT {
+				continue
+			} else if hdr.Ttl > elapsed {
+				hdr.Ttl -= elapsed
+			} else {
+				hdr.Ttl = 0
+			}
+		}
+	}
+	return r
+}
+
+// put caches r under the given key, evicting the least recently used
+// responses if the cache is full.
+func (c *Cache) put(key cacheKey, r *dns.Msg) {
+	ttl, ok := cacheTTL(r)
+	if !ok {
+		return
+	}
+	size := r.Len()
+	if size > c.maxSize {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	msg := r.Copy()
+	for _, rr := range msg.Ns {
+		// the SOA record of negative responses mustn't outlive them
+		if soa, ok := rr.(*dns.SOA); ok && soa.Hdr.Ttl > ttl {
+			soa.Hdr.Ttl = ttl
+		}
+	}
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	now := c.now()
+	c.entries[key] = c.lru.PushFront(&cacheEntry{
+		key:     key,
+		msg:     msg,
+		size:    size,
+		stored:  now,
+		expires: now.Add(time.Duration(ttl) * time.Second),
+	})
+	for c.size += size; c.size > c.maxSize; {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove removes the given element from the cache. c.mu must be held.
+func (c *Cache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, e.key)
+	c.size -= e.size
+}
+
+// Flush removes all the responses from the cache, returning how many there
+// were. A nil Cache has none.
+func (c *Cache) Flush() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.lru.Len()
+	c.lru.Init()
+	c.entries = map[cacheKey]*list.Element{}
+	c.size = 0
+	return n
+}
+
+// Size returns the total size in bytes of the cached responses. A nil Cache
+// is empty.
+func (c *Cache) Size() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
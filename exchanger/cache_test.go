@@ -0,0 +1,156 @@
+package exchanger
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/mesosphere/mesos-dns/dnstest"
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/miekg/dns"
+)
+
+// upstream is an Exchanger counting its exchanges, which replies with the
+// response of the queried name.
+type upstream struct {
+	responses map[string]*dns.Msg
+	exchanges int
+}
+
+func (u *upstream) Exchange(m *dns.Msg, a string) (*dns.Msg, time.Duration, error) {
+	u.exchanges++
+	r, ok := u.responses[m.Question[0].Name]
+	if !ok {
+		return nil, 0, errors.New("timeout")
+	}
+	r = r.Copy()
+	r.Id, r.Question = m.Id, m.Question
+	return r, time.Millisecond, nil
+}
+
+func TestCaching(t *testing.T) {
+	soa := SOA(RRHeader("example.com.", dns.TypeSOA, 300), "ns1.example.com.", "root.example.com.", 30)
+	u := &upstream{responses: map[string]*dns.Msg{
+		"a.example.com.": Message(
+			Header(false, dns.RcodeSuccess),
+			Answers(
+				A(RRHeader("a.example.com.", dns.TypeA, 60), net.ParseIP("1.2.3.4")),
+				A(RRHeader("a.example.com.", dns.TypeA, 120), net.ParseIP("1.2.3.5")))),
+		"missing.example.com.": Message(Header(false, dns.RcodeNameError), NSs(soa)),
+		"nodata.example.com.":  Message(Header(false, dns.RcodeSuccess), NSs(soa)),
+		"nosoa.example.com.":   Message(Header(false, dns.RcodeNameError)),
+		"cname.example.com.": Message(
+			Header(false, dns.RcodeNameError),
+			Answers(CNAME(RRHeader("cname.example.com.", dns.TypeCNAME, 10), "gone.example.com.")),
+			NSs(soa)),
+		"fail.example.com.": Message(Header(false, dns.RcodeServerFailure)),
+		"big.example.com.": Message(
+			Header(false, dns.RcodeSuccess),
+			func(m *dns.Msg) { m.Truncated = true },
+			Answers(A(RRHeader("big.example.com.", dns.TypeA, 60), net.ParseIP("1.2.3.4")))),
+	}}
+
+	now := time.Unix(0, 0)
+	c := NewCache(1 << 20)
+	c.now = func() time.Time { return now }
+	var hits, misses logging.LogCounter
+	ex := Caching(c, &hits, &misses)(u)
+
+	for i, tt := range []struct {
+		name      string
+		after     time.Duration
+		exchanges int
+		ttl       uint32
+	}{
+		{"a.example.com.", 0, 1, 60},
+		{"A.Example.com.", 10 * time.Second, 1, 50},
+		{"a.example.com.", 50 * time.Second, 2, 60}, // expired
+		{"missing.example.com.", 0, 3, 300},
+		{"missing.example.com.", 29 * time.Second, 3, 1}, // SOA TTL capped
+		{"missing.example.com.", time.Second, 4, 300},
+		{"nodata.example.com.", 0, 5, 300},
+		{"nodata.example.com.", 0, 5, 30},
+		{"nosoa.example.com.", 0, 6, 0},
+		{"nosoa.example.com.", 0, 7, 0},
+		{"fail.example.com.", 0, 8, 0},
+		{"fail.example.com.", 0, 9, 0},
+		{"big.example.com.", 0, 10, 60},
+		{"big.example.com.", 0, 11, 60},
+		{"error.example.com.", 0, 12, 0},
+		{"error.example.com.", 0, 13, 0},
+		{"cname.example.com.", 0, 14, 10},
+		{"cname.example.com.", 5 * time.Second, 14, 5}, // CNAME TTL capped
+		{"cname.example.com.", 5 * time.Second, 15, 10},
+	} {
+		now = now.Add(tt.after)
+		m := Message(Question(tt.name, dns.TypeA))
+		m.Id = uint16(i)
+		r, _, err := ex.Exchange(m, "1.2.3.4:53")
+		if u.exchanges != tt.exchanges {
+			t.Errorf("test #%d: got %d exchanges, want %d", i, u.exchanges, tt.exchanges)
+		}
+		if err != nil {
+			continue
+		}
+		if r.Id != m.Id || r.Question[0].Name != tt.name {
+			t.Errorf("test #%d: got a reply to %d %v, want one to %d %v", i, r.Id, r.Question, m.Id, m.Question)
+		}
+		rrs := append(r.Answer, r.Ns...)
+		if len(rrs) > 0 && tt.ttl != 0 && rrs[0].Header().Ttl != tt.ttl {
+			t.Errorf("test #%d: got TTL %d, want %d", i, rrs[0].Header().Ttl, tt.ttl)
+		}
+	}
+	if got, want := hits.String()+"/"+misses.String(), "4/15"; got != want {
+		t.Errorf("got %s cache hits/misses, want %s", got, want)
+	}
+
+	if n := c.Flush(); n != 4 || c.Size() != 0 {
+		t.Errorf("flushed %d responses leaving %d bytes, want 4 and none", n, c.Size())
+	}
+	if _, _, _ = ex.Exchange(Message(Question("a.example.com.", dns.TypeA)), "1.2.3.4:53"); u.exchanges != 16 {
+		t.Error("expected the flushed response to be exchanged again")
+	}
+}
+
+func TestCache_LRU(t *testing.T) {
+	response := func(name string) *dns.Msg {
+		return Message(
+			Question(name, dns.TypeA),
+			Answers(A(RRHeader(name, dns.TypeA, 60), net.ParseIP("1.2.3.4"))))
+	}
+	size := response("a.example.com.").Len()
+	c := NewCache(2 * size)
+	for _, name := range []string{"a.example.com.", "b.example.com."} {
+		key, _ := newCacheKey(response(name))
+		c.put(key, response(name))
+	}
+	a, _ := newCacheKey(response("a.example.com."))
+	if c.get(a, response("a.example.com.")) == nil {
+		t.Fatal("expected a.example.com. to be cached")
+	}
+	cKey, _ := newCacheKey(response("c.example.com."))
+	c.put(cKey, response("c.example.com."))
+
+	for _, tt := range []struct {
+		name   string
+		cached bool
+	}{
+		{"a.example.com.", true},
+		{"b.example.com.", false}, // least recently used
+		{"c.example.com.", true},
+	} {
+		key, _ := newCacheKey(response(tt.name))
+		if got := c.get(key, response(tt.name)) != nil; got != tt.cached {
+			t.Errorf("%s: got cached %t, want %t", tt.name, got, tt.cached)
+		}
+	}
+	if got := c.Size(); got != 2*size {
+		t.Errorf("got size %d, want %d", got, 2*size)
+	}
+
+	c.put(cKey, response("c.example.com."+string(make([]byte, 2*size))))
+	if got := c.Size(); got > 2*size {
+		t.Errorf("got size %d, want at most %d", got, 2*size)
+	}
+}
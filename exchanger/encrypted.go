@@ -0,0 +1,244 @@
+package exchanger
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dnsMessage is the media type of DNS-over-HTTPS messages.
+const dnsMessage = "application/dns-message"
+
+// TLS returns a DNS-over-TLS (RFC 7858) Exchanger with the given timeout,
+// exchanging messages with "tls://host[:port][#name]" addresses, on port 853
+// unless given. Server certificates are verified for the given name, or the
+// host otherwise, with the given roots, or the system ones if nil. A
+// connection to each server is kept open between exchanges.
+func TLS(timeout time.Duration, roots *x509.CertPool) Exchanger {
+	return &tlsExchanger{
+		timeout: timeout,
+		roots:   roots,
+		idle:    map[string]*tls.Conn{},
+	}
+}
+
+type tlsExchanger struct {
+	timeout time.Duration
+	roots   *x509.CertPool
+
+	mu   sync.Mutex
+	idle map[string]*tls.Conn // by address
+}
+
+// Exchange implements the Exchanger interface.
+func (e *tlsExchanger) Exchange(m *dns.Msg, a string) (*dns.Msg, time.Duration, error) {
+	u, name, err := upstreamURL(a, "tls")
+	if err != nil {
+		return nil, 0, err
+	}
+	hostport := u.Host
+	if _, _, err = net.SplitHostPort(hostport); err != nil {
+		hostport = net.JoinHostPort(strings.Trim(hostport, "[]"), "853")
+	}
+	msg, err := m.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	start := time.Now()
+	conn, reused := e.take(a)
+	if conn == nil {
+		if conn, err = e.dial(hostport, name); err != nil {
+			return nil, 0, err
+		}
+	}
+	r, err := exchangeStream(conn, msg, start.Add(e.timeout))
+	if err != nil && reused {
+		// the server may have closed the idle connection
+		conn.Close()
+		if conn, err = e.dial(hostport, name); err != nil {
+			return nil, 0, err
+		}
+		r, err = exchangeStream(conn, msg, start.Add(e.timeout))
+	}
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	e.release(a, conn)
+	if r.Id != m.Id {
+		return r, 0, dns.ErrId
+	}
+	return r, time.Since(start), nil
+}
+
+// dial returns a new connection to the given server, verifying its
+// certificate for the given name.
+func (e *tlsExchanger) dial(hostport, name string) (*tls.Conn, error) {
+	return tls.DialWithDialer(&net.Dialer{Timeout: e.timeout}, "tcp", hostport, &tls.Config{
+		ServerName: name,
+		RootCAs:    e.roots,
+	})
+}
+
+// take returns the idle connection to the given address, if any.
+func (e *tlsExchanger) take(a string) (*tls.Conn, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	conn, ok := e.idle[a]
+	delete(e.idle, a)
+	return conn, ok
+}
+
+// release keeps the given connection open for the next exchange with the
+// given address, unless there's another one already.
+func (e *tlsExchanger) release(a string, conn *tls.Conn) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.idle[a]; ok {
+		conn.Close()
+		return
+	}
+	e.idle[a] = conn
+}
+
+// exchangeStream writes the given packed message to conn, prefixed with its
+// length as over TCP, and reads the response, before the given deadline.
+func exchangeStream(conn net.Conn, msg []byte, deadline time.Time) (*dns.Msg, error) {
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(append([]byte{byte(len(msg) >> 8), byte(len(msg))}, msg...)); err != nil {
+		return nil, err
+	}
+	var l [2]byte
+	if _, err := io.ReadFull(conn, l[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, int(l[0])<<8|int(l[1]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	r := new(dns.Msg)
+	return r, r.Unpack(buf)
+}
+
+// HTTPS returns a DNS-over-HTTPS (RFC 8484) Exchanger with the given timeout,
+// POSTing messages to "https://host[:port]/path[#name]" addresses. Server
+// certificates are verified for the given name, or the host otherwise, with
+// the given roots, or the system ones if nil.
+func HTTPS(timeout time.Duration, roots *x509.CertPool) Exchanger {
+	return &httpsExchanger{
+		timeout: timeout,
+		roots:   roots,
+		clients: map[string]*http.Client{},
+	}
+}
+
+type httpsExchanger struct {
+	timeout time.Duration
+	roots   *x509.CertPool
+
+	mu      sync.Mutex
+	clients map[string]*http.Client // by verified name, "" for the host
+}
+
+// Exchange implements the Exchanger interface.
+func (e *httpsExchanger) Exchange(m *dns.Msg, a string) (*dns.Msg, time.Duration, error) {
+	u, name, err := upstreamURL(a, "https")
+	if err != nil {
+		return nil, 0, err
+	}
+	if u.Fragment == "" {
+		name = ""
+	}
+	u.Fragment = ""
+
+	// a zero ID makes responses cacheable by HTTP caches
+	q := m.Copy()
+	q.Id = 0
+	msg, err := q.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(msg))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", dnsMessage)
+	req.Header.Set("Accept", dnsMessage)
+
+	start := time.Now()
+	resp, err := e.client(name).Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != dnsMessage {
+		return nil, 0, fmt.Errorf("%s: unexpected content type %q", u, ct)
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, 0, err
+	}
+	r := new(dns.Msg)
+	if err = r.Unpack(buf); err != nil {
+		return nil, 0, err
+	}
+	r.Id = m.Id
+	return r, time.Since(start), nil
+}
+
+// client returns the HTTP client verifying server certificates for the given
+// name, or the host of the request if empty.
+func (e *httpsExchanger) client(name string) *http.Client {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	c, ok := e.clients[name]
+	if !ok {
+		c = &http.Client{
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				TLSClientConfig:     &tls.Config{ServerName: name, RootCAs: e.roots},
+				TLSHandshakeTimeout: e.timeout,
+			},
+			Timeout: e.timeout,
+		}
+		e.clients[name] = c
+	}
+	return c
+}
+
+// upstreamURL parses the given address of an encrypted upstream server with
+// the given scheme, returning it along with the name its certificate is
+// verified for: its fragment, if any, or its host.
+func upstreamURL(a, scheme string) (*url.URL, string, error) {
+	u, err := url.Parse(a)
+	if err != nil {
+		return nil, "", err
+	}
+	if u.Scheme != scheme || u.Host == "" {
+		return nil, "", fmt.Errorf("invalid %s upstream address %q", scheme, a)
+	}
+	name := u.Fragment
+	if name == "" {
+		if name, _, err = net.SplitHostPort(u.Host); err != nil {
+			name = strings.Trim(u.Host, "[]")
+		}
+	}
+	return u, name, nil
+}
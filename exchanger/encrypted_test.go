@@ -0,0 +1,164 @@
+package exchanger
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/mesosphere/mesos-dns/dnstest"
+	"github.com/miekg/dns"
+)
+
+// reply returns the response of the test servers to m.
+func reply(m *dns.Msg) *dns.Msg {
+	r := new(dns.Msg)
+	r.SetReply(m)
+	r.Answer = append(r.Answer, A(RRHeader(m.Question[0].Name, dns.TypeA, 60), net.ParseIP("1.2.3.4")))
+	return r
+}
+
+// serverRoots returns the roots verifying the certificate of the given test
+// server, valid for 127.0.0.1 and example.com.
+func serverRoots(t *testing.T, ts *httptest.Server) *x509.CertPool {
+	cert, err := x509.ParseCertificate(ts.TLS.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return roots
+}
+
+func TestTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	ts.Close()
+	roots := serverRoots(t, ts)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: ts.TLS.Certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	var conns int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&conns, 1)
+			go func(conn net.Conn) {
+				defer conn.Close()
+				for {
+					var n [2]byte
+					if _, err := io.ReadFull(conn, n[:]); err != nil {
+						return
+					}
+					buf := make([]byte, int(n[0])<<8|int(n[1]))
+					if _, err := io.ReadFull(conn, buf); err != nil {
+						return
+					}
+					m := new(dns.Msg)
+					if err := m.Unpack(buf); err != nil {
+						return
+					}
+					out, _ := reply(m).Pack()
+					conn.Write(append([]byte{byte(len(out) >> 8), byte(len(out))}, out...))
+				}
+			}(conn)
+		}
+	}()
+
+	addr := "tls://" + l.Addr().String()
+	for i, tt := range []struct {
+		addr  string
+		roots *x509.CertPool
+		ok    bool
+	}{
+		{addr, roots, true},
+		{addr, roots, true},
+		{addr + "#example.com", roots, true},
+		{addr + "#example.org", roots, false},
+		{addr, nil, false},
+		{"tcp://" + l.Addr().String(), roots, false},
+	} {
+		m := Message(Question("foo.bar.", dns.TypeA))
+		m.Id = uint16(i + 1)
+		r, _, err := TLS(time.Second, tt.roots).Exchange(m, tt.addr)
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("test #%d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test #%d: %v", i, err)
+		} else if r.Id != m.Id || len(r.Answer) != 1 {
+			t.Errorf("test #%d: got %v, want a reply to %v", i, r, m)
+		}
+	}
+
+	ex := TLS(time.Second, roots)
+	before := atomic.LoadInt32(&conns)
+	for i := 0; i < 3; i++ {
+		if _, _, err := ex.Exchange(Message(Question("foo.bar.", dns.TypeA)), addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := atomic.LoadInt32(&conns) - before; got != 1 {
+		t.Errorf("got %d connections for 3 exchanges, want 1", got)
+	}
+}
+
+func TestHTTPS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" || req.Header.Get("Content-Type") != dnsMessage {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		buf, _ := ioutil.ReadAll(req.Body)
+		m := new(dns.Msg)
+		if err := m.Unpack(buf); err != nil || m.Id != 0 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		out, _ := reply(m).Pack()
+		w.Header().Set("Content-Type", dnsMessage)
+		w.Write(out)
+	}))
+	defer ts.Close()
+	roots := serverRoots(t, ts)
+
+	for i, tt := range []struct {
+		addr  string
+		roots *x509.CertPool
+		ok    bool
+	}{
+		{ts.URL + "/dns-query", roots, true},
+		{ts.URL + "/dns-query#example.com", roots, true},
+		{ts.URL + "/dns-query#example.org", roots, false},
+		{ts.URL + "/dns-query", nil, false},
+		{"http://" + ts.Listener.Addr().String() + "/dns-query", roots, false},
+	} {
+		m := Message(Question("foo.bar.", dns.TypeA))
+		m.Id = uint16(i + 1)
+		r, _, err := HTTPS(time.Second, tt.roots).Exchange(m, tt.addr)
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("test #%d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test #%d: %v", i, err)
+		} else if r.Id != m.Id || len(r.Answer) != 1 {
+			t.Errorf("test #%d: got %v, want a reply to %v", i, r, m)
+		}
+	}
+}
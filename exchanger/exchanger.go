@@ -1,7 +1,6 @@
 package exchanger
 
 import (
-	"log"
 	"time"
 
 	"github.com/mesosphere/mesos-dns/logging"
@@ -36,13 +35,17 @@ func Decorate(ex Exchanger, ds ...Decorator) Exchanger {
 }
 
 // ErrorLogging returns a Decorator which logs an Exchanger's errors to the given
-// logger.
-func ErrorLogging(l *log.Logger) Decorator {
+// logger, along with the address and the question of the exchange.
+func ErrorLogging(l *logging.Logger) Decorator {
 	return func(ex Exchanger) Exchanger {
 		return Func(func(m *dns.Msg, a string) (r *dns.Msg, rtt time.Duration, err error) {
 			defer func() {
 				if err != nil {
-					l.Printf("%v: exchanging %#v with %q", err, m, a)
+					var question string
+					if m != nil && len(m.Question) > 0 {
+						question = m.Question[0].String()
+					}
+					l.Error("exchange failed", "addr", a, "question", question, "error", err)
 				}
 			}()
 			return ex.Exchange(m, a)
@@ -51,11 +54,16 @@ func ErrorLogging(l *log.Logger) Decorator {
 }
 
 // Instrumentation returns a Decorator which instruments an Exchanger with the given
-// counters.
-func Instrumentation(total, success, failure logging.Counter) Decorator {
+// counters. The latency of each exchange, in seconds, is observed by the given
+// Observer, labeled with the exchange address, unless it's nil.
+func Instrumentation(total, success, failure logging.Counter, latency logging.Observer) Decorator {
 	return func(ex Exchanger) Exchanger {
 		return Func(func(m *dns.Msg, a string) (r *dns.Msg, rtt time.Duration, err error) {
+			start := time.Now()
 			defer func() {
+				if latency != nil {
+					latency.Observe(a, time.Since(start).Seconds())
+				}
 				if total.Inc(); err != nil {
 					failure.Inc()
 				} else {
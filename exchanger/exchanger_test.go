@@ -3,7 +3,8 @@ package exchanger
 import (
 	"bytes"
 	"errors"
-	"log"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,19 +13,23 @@ import (
 )
 
 func TestErrorLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logging.SetOutput(&buf)
+	defer logging.SetOutput(os.Stderr)
+	l := logging.Log.With("component", "test")
+
 	{ // with error
-		var buf bytes.Buffer
-		_, _, _ = ErrorLogging(log.New(&buf, "", 0))(
+		_, _, _ = ErrorLogging(l)(
 			stub(exchanged{err: errors.New("timeout")})).Exchange(nil, "1.2.3.4")
 
-		want := "timeout: exchanging (*dns.Msg)(nil) with \"1.2.3.4\"\n"
-		if got := buf.String(); got != want {
-			t.Errorf("got %q, want %q", got, want)
+		want := " level=error msg=\"exchange failed\" component=test addr=1.2.3.4 question=\"\" error=timeout\n"
+		if got := buf.String(); !strings.HasSuffix(got, want) {
+			t.Errorf("got %q, want suffix %q", got, want)
 		}
 	}
 	{ // no error
-		var buf bytes.Buffer
-		_, _, _ = ErrorLogging(log.New(&buf, "", 0))(
+		buf.Reset()
+		_, _, _ = ErrorLogging(l)(
 			stub(exchanged{})).Exchange(nil, "1.2.3.4")
 
 		if got, want := buf.String(), ""; got != want {
@@ -36,7 +41,7 @@ func TestErrorLogging(t *testing.T) {
 func TestInstrumentation(t *testing.T) {
 	{ // with error
 		var total, success, failure logging.LogCounter
-		_, _, _ = Instrumentation(&total, &success, &failure)(
+		_, _, _ = Instrumentation(&total, &success, &failure, nil)(
 			stub(exchanged{err: errors.New("timeout")})).Exchange(nil, "1.2.3.4")
 
 		want := []string{"1", "0", "1"}
@@ -48,7 +53,7 @@ func TestInstrumentation(t *testing.T) {
 	}
 	{ // no error
 		var total, success, failure logging.LogCounter
-		_, _, _ = Instrumentation(&total, &success, &failure)(
+		_, _, _ = Instrumentation(&total, &success, &failure, nil)(
 			stub(exchanged{})).Exchange(nil, "1.2.3.4")
 
 		want := []string{"1", "1", "0"}
@@ -58,8 +63,22 @@ func TestInstrumentation(t *testing.T) {
 			}
 		}
 	}
+	{ // with latency
+		var total, success, failure logging.LogCounter
+		latency := observed{}
+		_, _, _ = Instrumentation(&total, &success, &failure, latency)(
+			stub(exchanged{})).Exchange(nil, "1.2.3.4")
+
+		if got := len(latency["1.2.3.4"]); got != 1 {
+			t.Errorf("got %d latency observations, want 1", got)
+		}
+	}
 }
 
+type observed map[string][]float64
+
+func (o observed) Observe(label string, v float64) { o[label] = append(o[label], v) }
+
 func stubs(ed ...exchanged) []Exchanger {
 	exs := make([]Exchanger, len(ed))
 	for i := range ed {
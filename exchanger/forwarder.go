@@ -2,41 +2,305 @@ package exchanger
 
 import (
 	"fmt"
+	"math/rand"
 	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/mesosphere/mesos-dns/logging"
 	"github.com/miekg/dns"
 )
 
 // A Forwarder is a DNS message forwarder that transparently proxies messages
-// to DNS servers.
-type Forwarder func(*dns.Msg, string) (*dns.Msg, error)
+// to DNS servers, returning the address of the one which answered, if any.
+type Forwarder func(*dns.Msg, string) (*dns.Msg, string, error)
 
-// Forward is an utility method that calls f itself.
+// Forward is an utility method that calls f itself, leaving out the address
+// of the server which answered.
 func (f Forwarder) Forward(m *dns.Msg, proto string) (*dns.Msg, error) {
-	return f(m, proto)
+	r, _, err := f(m, proto)
+	return r, err
+}
+
+// A Strategy selects the upstream DNS servers a Forwarder exchanges messages
+// with.
+type Strategy string
+
+const (
+	// Sequential tries every server in the given order.
+	Sequential Strategy = "sequential"
+	// Random tries every server in a random order.
+	Random Strategy = "random"
+	// RoundRobin tries every server starting with the one after the server
+	// the previous message started with.
+	RoundRobin Strategy = "round_robin"
+	// Fastest tries every server in increasing order of average RTT, the
+	// failing ones last.
+	Fastest Strategy = "fastest"
+	// Parallel exchanges messages with every server at once, taking the
+	// first NOERROR or NXDOMAIN answer.
+	Parallel Strategy = "parallel"
+)
+
+// ParseStrategy returns the Strategy of the given name.
+func ParseStrategy(name string) (Strategy, error) {
+	switch s := Strategy(name); s {
+	case Sequential, Random, RoundRobin, Fastest, Parallel:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unknown forwarding strategy %q", name)
+	}
+}
+
+// A ForwarderOption configures a Forwarder.
+type ForwarderOption func(*forwarder)
+
+// WithStrategy returns a ForwarderOption selecting the upstream servers with
+// the given Strategy rather than Sequential.
+func WithStrategy(s Strategy) ForwarderOption {
+	return func(f *forwarder) { f.strategy = s }
+}
+
+// WithBreaker returns a ForwarderOption skipping the upstream servers failing
+// the given number of exchanges in a row for the given cooldown, counting
+// these trips. A server is tried again after its cooldown, and skipped for
+// another one as soon as it fails again. Servers are never skipped if they
+// all are failing.
+func WithBreaker(failures int, cooldown time.Duration, trips logging.Counter) ForwarderOption {
+	return func(f *forwarder) {
+		f.failures, f.cooldown, f.trips = failures, cooldown, trips
+	}
 }
 
 // NewForwarder returns a new Forwarder for the given addrs with the given
-// Exchangers map which maps network protocols to Exchangers.
+// Exchangers map which maps network protocols to Exchangers. Addresses without
+// a port are exchanged with on port 53. Addresses which are URLs, e.g.
+// "tls://1.1.1.1", are exchanged with by the Exchanger of their scheme instead
+// of the one of the message protocol.
 //
-// Every message will be exchanged with each address until no error is returned.
-// If no addresses or no matching protocol exchanger exist, a *ForwardError will
-// be returned.
-func NewForwarder(addrs []string, exs map[string]Exchanger) Forwarder {
-	return func(m *dns.Msg, proto string) (r *dns.Msg, err error) {
-		ex, ok := exs[proto]
-		if !ok || len(addrs) == 0 {
-			return nil, &ForwardError{Addrs: addrs, Proto: proto}
-		}
-		for _, a := range addrs {
-			if r, _, err = ex.Exchange(m, net.JoinHostPort(a, "53")); err == nil {
-				break
+// Every message will be exchanged with each address, in the order of the
+// Strategy, until no error is returned. If no addresses or no matching
+// protocol exchanger exist, a *ForwardError will be returned.
+func NewForwarder(addrs []string, exs map[string]Exchanger, opts ...ForwarderOption) Forwarder {
+	return newForwarder(addrs, exs, opts...).forward
+}
+
+func newForwarder(addrs []string, exs map[string]Exchanger, opts ...ForwarderOption) *forwarder {
+	f := &forwarder{
+		addrs:     addrs,
+		exs:       exs,
+		strategy:  Sequential,
+		upstreams: make([]*upstreamHealth, len(addrs)),
+		now:       time.Now,
+	}
+	for i, a := range addrs {
+		if _, _, err := net.SplitHostPort(a); err != nil && !strings.Contains(a, "://") {
+			a = net.JoinHostPort(a, "53")
+		}
+		f.upstreams[i] = &upstreamHealth{addr: a}
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// forwarder holds the configuration of a Forwarder and the health of its
+// upstream servers.
+type forwarder struct {
+	addrs    []string
+	exs      map[string]Exchanger
+	strategy Strategy
+	failures int
+	cooldown time.Duration
+	trips    logging.Counter
+	// next is the index of the server the next RoundRobin exchange starts with
+	next uint32
+	now  func() time.Time
+
+	mu        sync.Mutex
+	upstreams []*upstreamHealth
+}
+
+// upstreamHealth is the health of an upstream server, as observed from the
+// outcome of the exchanges with it.
+type upstreamHealth struct {
+	addr string
+	// srtt is the smoothed RTT of the successful exchanges with the server
+	srtt time.Duration
+	// fails is the number of exchanges in a row which failed
+	fails int
+	// skipUntil is the end of the cooldown of a tripped server
+	skipUntil time.Time
+}
+
+// rttWeight is the weight of the last RTT in the smoothed RTT of a server.
+const rttWeight = 0.3
+
+// target is an address along with the Exchanger exchanging messages with it.
+type target struct {
+	addr string
+	ex   Exchanger
+}
+
+func (f *forwarder) forward(m *dns.Msg, proto string) (*dns.Msg, string, error) {
+	var ts []target
+	for _, a := range f.order() {
+		network := proto
+		if i := strings.Index(a, "://"); i > 0 {
+			network = a[:i]
+		}
+		if ex, ok := f.exs[network]; ok {
+			ts = append(ts, target{a, ex})
+		}
+	}
+	if len(ts) == 0 {
+		return nil, "", &ForwardError{Addrs: f.addrs, Proto: proto}
+	}
+	if f.strategy == Parallel {
+		return f.race(m, ts)
+	}
+	var (
+		r   *dns.Msg
+		err error
+	)
+	for _, t := range ts {
+		var rtt time.Duration
+		r, rtt, err = t.ex.Exchange(m, t.addr)
+		if f.observe(t.addr, rtt, r, err); err == nil {
+			return r, t.addr, nil
+		}
+	}
+	return r, "", err
+}
+
+// race exchanges m with all the given targets at once, returning the first
+// NOERROR or NXDOMAIN answer, or else the last answer, or else the last error.
+func (f *forwarder) race(m *dns.Msg, ts []target) (*dns.Msg, string, error) {
+	type outcome struct {
+		r    *dns.Msg
+		addr string
+		err  error
+	}
+	ch := make(chan outcome, len(ts))
+	for _, t := range ts {
+		go func(m *dns.Msg, t target) {
+			r, rtt, err := t.ex.Exchange(m, t.addr)
+			f.observe(t.addr, rtt, r, err)
+			ch <- outcome{r, t.addr, err}
+		}(m.Copy(), t)
+	}
+	var last, answer outcome
+	for range ts {
+		o := <-ch
+		switch {
+		case o.err != nil:
+			last = o
+		case !failed(o.r):
+			return o.r, o.addr, nil
+		default:
+			answer = o
+		}
+	}
+	if answer.r != nil {
+		return answer.r, answer.addr, nil
+	}
+	return last.r, "", last.err
+}
+
+// failed returns whether r is an answer of a server failing to resolve the
+// question, rather than a NOERROR or NXDOMAIN one.
+func failed(r *dns.Msg) bool {
+	return r != nil && (r.Rcode == dns.RcodeServerFailure || r.Rcode == dns.RcodeRefused)
+}
+
+// order returns the addresses of the servers to exchange a message with, in
+// order, leaving out the tripped ones unless they all are.
+func (f *forwarder) order() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := f.now()
+	ups := make([]*upstreamHealth, 0, len(f.upstreams))
+	for _, u := range f.upstreams {
+		if !now.Before(u.skipUntil) {
+			ups = append(ups, u)
+		}
+	}
+	if len(ups) == 0 {
+		ups = append(ups, f.upstreams...)
+	}
+
+	switch f.strategy {
+	case Random:
+		for i := range ups {
+			j := i + rand.Intn(len(ups)-i)
+			ups[i], ups[j] = ups[j], ups[i]
+		}
+	case RoundRobin:
+		n := int(atomic.AddUint32(&f.next, 1)-1) % len(ups)
+		ups = append(append(make([]*upstreamHealth, 0, len(ups)), ups[n:]...), ups[:n]...)
+	case Fastest:
+		sort.Stable(bySRTT(ups))
+	}
+
+	addrs := make([]string, len(ups))
+	for i, u := range ups {
+		addrs[i] = u.addr
+	}
+	return addrs
+}
+
+// observe updates the health of the server of the given address with the
+// outcome of an exchange with it, SERVFAIL and REFUSED answers counting as
+// failures.
+func (f *forwarder) observe(addr string, rtt time.Duration, r *dns.Msg, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, u := range f.upstreams {
+		if u.addr != addr {
+			continue
+		}
+		if err == nil && !failed(r) {
+			switch {
+			case rtt == 0: // e.g. answered from a cache
+			case u.srtt == 0:
+				u.srtt = rtt
+			default:
+				u.srtt += time.Duration(rttWeight * float64(rtt-u.srtt))
+			}
+			u.fails, u.skipUntil = 0, time.Time{}
+			return
+		}
+		if u.fails++; f.failures > 0 && u.fails >= f.failures {
+			now := f.now()
+			if f.trips != nil && !now.Before(u.skipUntil) {
+				f.trips.Inc()
 			}
+			u.skipUntil = now.Add(f.cooldown)
 		}
 		return
 	}
 }
 
+// bySRTT sorts servers by increasing number of failures in a row, then by
+// increasing smoothed RTT, those without any RTT first so that it gets
+// measured.
+type bySRTT []*upstreamHealth
+
+func (s bySRTT) Len() int      { return len(s) }
+func (s bySRTT) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s bySRTT) Less(i, j int) bool {
+	if s[i].fails != s[j].fails {
+		return s[i].fails < s[j].fails
+	}
+	return s[i].srtt < s[j].srtt
+}
+
 // A ForwardError is returned by Forwarders when they can't forward.
 type ForwardError struct {
 	Addrs []string
@@ -3,11 +3,14 @@ package exchanger
 import (
 	"errors"
 	"reflect"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/kylelemons/godebug/pretty"
 	. "github.com/mesosphere/mesos-dns/dnstest"
+	"github.com/mesosphere/mesos-dns/logging"
 	"github.com/miekg/dns"
 )
 
@@ -89,7 +92,236 @@ func TestForwarder(t *testing.T) {
 	}
 }
 
+func TestForwarder_ports(t *testing.T) {
+	var got []string
+	exs := map[string]Exchanger{
+		"udp": Func(func(_ *dns.Msg, a string) (*dns.Msg, time.Duration, error) {
+			got = append(got, a)
+			return nil, 0, errors.New("timeout")
+		}),
+	}
+	addrs := []string{"1.2.3.4", "2.3.4.5:8600", "fd00::1", "[fd00::2]:8600"}
+	if _, err := NewForwarder(addrs, exs).Forward(nil, "udp"); err == nil {
+		t.Error("expected an error")
+	}
+	want := []string{"1.2.3.4:53", "2.3.4.5:8600", "[fd00::1]:53", "[fd00::2]:8600"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got addresses %v, want %v", got, want)
+	}
+}
+
+func TestForwarder_schemes(t *testing.T) {
+	var got []string
+	record := func(network string) Exchanger {
+		return Func(func(m *dns.Msg, a string) (*dns.Msg, time.Duration, error) {
+			got = append(got, network+" "+a)
+			return nil, 0, errors.New("timeout")
+		})
+	}
+	exs := map[string]Exchanger{"udp": record("udp"), "tls": record("tls")}
+	addrs := []string{"1.2.3.4", "tls://1.1.1.1#cloudflare-dns.com", "https://dns.google/dns-query"}
+	if _, err := NewForwarder(addrs, exs).Forward(nil, "udp"); err == nil {
+		t.Error("expected an error")
+	}
+	want := []string{"udp 1.2.3.4:53", "tls tls://1.1.1.1#cloudflare-dns.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got exchanges %v, want %v", got, want)
+	}
+	if _, err := NewForwarder(addrs[2:], exs).Forward(nil, "udp"); err == nil {
+		t.Error("expected a ForwardError without any https Exchanger")
+	}
+}
+
 type forwarded struct {
 	r   *dns.Msg
 	err error
 }
+
+// recorder returns an Exchanger recording the addresses it exchanges with,
+// which replies with the given RTTs and fails with the addresses not given.
+func recorder(got *[]string, rtts map[string]time.Duration) map[string]Exchanger {
+	var mu sync.Mutex
+	return map[string]Exchanger{
+		"udp": Func(func(m *dns.Msg, a string) (*dns.Msg, time.Duration, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			*got = append(*got, a)
+			rtt, ok := rtts[a]
+			if !ok {
+				return nil, 0, errors.New("timeout")
+			}
+			return m, rtt, nil
+		}),
+	}
+}
+
+func TestForwarder_strategies(t *testing.T) {
+	addrs := []string{"1.1.1.1:53", "2.2.2.2:53", "3.3.3.3:53"}
+	for _, tt := range []struct {
+		strategy Strategy
+		rtts     map[string]time.Duration
+		want     [][]string
+	}{
+		{
+			Sequential,
+			map[string]time.Duration{"2.2.2.2:53": time.Millisecond},
+			[][]string{
+				{"1.1.1.1:53", "2.2.2.2:53"},
+				{"1.1.1.1:53", "2.2.2.2:53"},
+			},
+		},
+		{
+			RoundRobin,
+			map[string]time.Duration{"1.1.1.1:53": time.Millisecond, "2.2.2.2:53": time.Millisecond, "3.3.3.3:53": time.Millisecond},
+			[][]string{{"1.1.1.1:53"}, {"2.2.2.2:53"}, {"3.3.3.3:53"}, {"1.1.1.1:53"}},
+		},
+		{
+			Fastest,
+			map[string]time.Duration{"1.1.1.1:53": 30 * time.Millisecond, "2.2.2.2:53": 20 * time.Millisecond, "3.3.3.3:53": 10 * time.Millisecond},
+			[][]string{{"1.1.1.1:53"}, {"2.2.2.2:53"}, {"3.3.3.3:53"}, {"3.3.3.3:53"}, {"3.3.3.3:53"}},
+		},
+		{
+			Fastest,
+			map[string]time.Duration{"3.3.3.3:53": 10 * time.Millisecond},
+			[][]string{
+				{"1.1.1.1:53", "2.2.2.2:53", "3.3.3.3:53"},
+				{"3.3.3.3:53"},
+			},
+		},
+	} {
+		var got []string
+		f := NewForwarder(addrs, recorder(&got, tt.rtts), WithStrategy(tt.strategy))
+		for i, want := range tt.want {
+			got = got[:0]
+			if _, err := f.Forward(Message(Question("foo.bar.", dns.TypeA)), "udp"); err != nil {
+				t.Errorf("%s #%d: %v", tt.strategy, i, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s #%d: got exchanges with %v, want %v", tt.strategy, i, got, want)
+			}
+		}
+	}
+
+	var got []string
+	f := NewForwarder(addrs, recorder(&got, nil), WithStrategy(Random))
+	if _, err := f.Forward(Message(Question("foo.bar.", dns.TypeA)), "udp"); err == nil {
+		t.Error("random: expected an error")
+	}
+	if sort.Strings(got); !reflect.DeepEqual(got, addrs) {
+		t.Errorf("random: got exchanges with %v, want all of %v", got, addrs)
+	}
+}
+
+func TestForwarder_parallel(t *testing.T) {
+	addrs := []string{"1.1.1.1:53", "2.2.2.2:53", "3.3.3.3:53"}
+	msg := Message(Question("foo.bar.", dns.TypeA))
+	servfail := Message(Header(false, dns.RcodeServerFailure), Question("foo.bar.", dns.TypeA))
+	exs := map[string]Exchanger{
+		"udp": Func(func(m *dns.Msg, a string) (*dns.Msg, time.Duration, error) {
+			switch a {
+			case "1.1.1.1:53":
+				return servfail, time.Millisecond, nil
+			case "2.2.2.2:53":
+				time.Sleep(10 * time.Millisecond)
+				return m, 10 * time.Millisecond, nil
+			default:
+				return nil, 0, errors.New("timeout " + a)
+			}
+		}),
+	}
+	r, addr, err := NewForwarder(addrs, exs, WithStrategy(Parallel))(msg, "udp")
+	if err != nil || !reflect.DeepEqual(r, msg) || addr != "2.2.2.2:53" {
+		t.Errorf("got %v from %q, %v; want the answer of 2.2.2.2:53", r, addr, err)
+	}
+
+	// the SERVFAIL answer is taken when there's no better one
+	r, addr, err = NewForwarder([]string{"1.1.1.1:53", "3.3.3.3:53"}, exs, WithStrategy(Parallel))(msg, "udp")
+	if err != nil || r != servfail || addr != "1.1.1.1:53" {
+		t.Errorf("got %v from %q, %v; want the SERVFAIL answer of 1.1.1.1:53", r, addr, err)
+	}
+
+	var got []string
+	if _, err = NewForwarder(addrs, recorder(&got, nil), WithStrategy(Parallel)).Forward(msg, "udp"); err == nil {
+		t.Error("expected an error from failing servers")
+	}
+	if sort.Strings(got); !reflect.DeepEqual(got, addrs) {
+		t.Errorf("got exchanges with %v, want all of %v", got, addrs)
+	}
+}
+
+func TestForwarder_breaker(t *testing.T) {
+	addrs := []string{"1.1.1.1:53", "2.2.2.2:53"}
+	rtts := map[string]time.Duration{"2.2.2.2:53": time.Millisecond}
+	var (
+		got   []string
+		trips logging.LogCounter
+	)
+	f := newForwarder(addrs, recorder(&got, rtts), WithBreaker(2, 30*time.Second, &trips))
+	now := time.Unix(0, 0)
+	f.now = func() time.Time { return now }
+
+	for i, tt := range []struct {
+		after time.Duration
+		down  bool // whether 2.2.2.2:53 is failing too
+		want  []string
+		trips string
+	}{
+		{0, false, []string{"1.1.1.1:53", "2.2.2.2:53"}, "0"},
+		{0, false, []string{"1.1.1.1:53", "2.2.2.2:53"}, "1"}, // tripped
+		{0, false, []string{"2.2.2.2:53"}, "1"},
+		{29 * time.Second, false, []string{"2.2.2.2:53"}, "1"},
+		{time.Second, false, []string{"1.1.1.1:53", "2.2.2.2:53"}, "2"}, // tripped again
+		{0, true, []string{"2.2.2.2:53"}, "2"},
+		{0, true, []string{"2.2.2.2:53"}, "3"},
+		{0, true, []string{"1.1.1.1:53", "2.2.2.2:53"}, "3"}, // all tripped
+		{0, false, []string{"1.1.1.1:53", "2.2.2.2:53"}, "3"},
+		{0, false, []string{"2.2.2.2:53"}, "3"}, // recovered
+	} {
+		now = now.Add(tt.after)
+		if tt.down {
+			delete(rtts, "2.2.2.2:53")
+		} else {
+			rtts["2.2.2.2:53"] = time.Millisecond
+		}
+		got = got[:0]
+		_, addr, err := f.forward(Message(Question("foo.bar.", dns.TypeA)), "udp")
+		if (err != nil) != tt.down {
+			t.Errorf("test #%d: got error %v", i, err)
+		} else if err == nil && addr != "2.2.2.2:53" {
+			t.Errorf("test #%d: got an answer from %q, want 2.2.2.2:53", i, addr)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("test #%d: got exchanges with %v, want %v", i, got, tt.want)
+		}
+		if trips.String() != tt.trips {
+			t.Errorf("test #%d: got %s trips, want %s", i, trips.String(), tt.trips)
+		}
+	}
+}
+
+func TestForwarder_breakerRcodes(t *testing.T) {
+	addrs := []string{"1.1.1.1:53", "2.2.2.2:53"}
+	rcodes := []int{dns.RcodeRefused, dns.RcodeServerFailure}
+	exs := map[string]Exchanger{
+		"udp": Func(func(m *dns.Msg, a string) (*dns.Msg, time.Duration, error) {
+			rcode := dns.RcodeSuccess
+			if a == "1.1.1.1:53" && len(rcodes) > 0 {
+				rcode, rcodes = rcodes[0], rcodes[1:]
+			}
+			return Message(Header(false, rcode), Question("foo.bar.", dns.TypeA)), time.Millisecond, nil
+		}),
+	}
+	var trips logging.LogCounter
+	f := newForwarder(addrs, exs, WithBreaker(2, 30*time.Second, &trips))
+	f.now = func() time.Time { return time.Unix(0, 0) }
+
+	// 1.1.1.1:53 answers REFUSED and then SERVFAIL, which trips it
+	for i, want := range []string{"1.1.1.1:53", "1.1.1.1:53", "2.2.2.2:53"} {
+		if _, addr, err := f.forward(Message(Question("foo.bar.", dns.TypeA)), "udp"); err != nil || addr != want {
+			t.Errorf("test #%d: got an answer from %q, %v; want one from %s", i, addr, err, want)
+		}
+	}
+	if trips.String() != "1" {
+		t.Errorf("got %s trips, want 1", trips.String())
+	}
+}
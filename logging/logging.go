@@ -1,9 +1,7 @@
 package logging
 
 import (
-	"io/ioutil"
 	"log"
-	"os"
 	"strconv"
 	"sync/atomic"
 
@@ -15,12 +13,18 @@ var (
 	VerboseFlag bool
 	// VeryVerboseFlag enables very verbose logging if set to true.
 	VeryVerboseFlag bool
-	// Verbose is this package's verbose Logger.
-	Verbose *log.Logger
-	// VeryVerbose is this package's very verbose Logger.
-	VeryVerbose *log.Logger
-	// Error is this package's error Logger.
-	Error *log.Logger
+	// Verbose writes InfoLevel entries to the root Logger.
+	//
+	// Deprecated: use Log.Info instead.
+	Verbose = log.New(levelWriter(InfoLevel), "", 0)
+	// VeryVerbose writes DebugLevel entries to the root Logger.
+	//
+	// Deprecated: use Log.Debug instead.
+	VeryVerbose = log.New(levelWriter(DebugLevel), "", 0)
+	// Error writes ErrorLevel entries to the root Logger.
+	//
+	// Deprecated: use Log.Error instead.
+	Error = log.New(levelWriter(ErrorLevel), "", 0)
 )
 
 // Counter defines an interface for a monotonically incrementing value.
@@ -44,41 +48,70 @@ func (lc *LogCounter) String() string {
 	return strconv.FormatUint(atomic.LoadUint64(&lc.value), 10)
 }
 
+// MarshalJSON implements the json.Marshaler interface, encoding the counter
+// as a number.
+func (lc *LogCounter) MarshalJSON() ([]byte, error) {
+	return []byte(lc.String()), nil
+}
+
 // LogOut holds metrics captured in an instrumented runtime.
 type LogOut struct {
-	MesosRequests     Counter
-	MesosSuccess      Counter
-	MesosNXDomain     Counter
-	MesosFailed       Counter
-	NonMesosRequests  Counter
-	NonMesosSuccess   Counter
-	NonMesosNXDomain  Counter
-	NonMesosFailed    Counter
-	NonMesosForwarded Counter
+	MesosRequests       Counter
+	MesosSuccess        Counter
+	MesosNXDomain       Counter
+	MesosFailed         Counter
+	NonMesosRequests    Counter
+	NonMesosSuccess     Counter
+	NonMesosNXDomain    Counter
+	NonMesosFailed      Counter
+	NonMesosForwarded   Counter
+	ForwardCacheHits    Counter
+	ForwardCacheMisses  Counter
+	ForwardBreakerTrips Counter
+	RateLimited         Counter
+	RRLSlipped          Counter
+	RRLDropped          Counter
+	NotifySent          Counter
+	NotifySuccess       Counter
+	NotifyFailed        Counter
+	StreamEvents        Counter
+	StreamDropped       Counter
+	QueryLogDropped     Counter
 }
 
 // CurLog is the default package level LogOut.
 var CurLog = LogOut{
-	MesosRequests:     &LogCounter{},
-	MesosSuccess:      &LogCounter{},
-	MesosNXDomain:     &LogCounter{},
-	MesosFailed:       &LogCounter{},
-	NonMesosRequests:  &LogCounter{},
-	NonMesosSuccess:   &LogCounter{},
-	NonMesosNXDomain:  &LogCounter{},
-	NonMesosFailed:    &LogCounter{},
-	NonMesosForwarded: &LogCounter{},
+	MesosRequests:       &LogCounter{},
+	MesosSuccess:        &LogCounter{},
+	MesosNXDomain:       &LogCounter{},
+	MesosFailed:         &LogCounter{},
+	NonMesosRequests:    &LogCounter{},
+	NonMesosSuccess:     &LogCounter{},
+	NonMesosNXDomain:    &LogCounter{},
+	NonMesosFailed:      &LogCounter{},
+	NonMesosForwarded:   &LogCounter{},
+	ForwardCacheHits:    &LogCounter{},
+	ForwardCacheMisses:  &LogCounter{},
+	ForwardBreakerTrips: &LogCounter{},
+	RateLimited:         &LogCounter{},
+	RRLSlipped:          &LogCounter{},
+	RRLDropped:          &LogCounter{},
+	NotifySent:          &LogCounter{},
+	NotifySuccess:       &LogCounter{},
+	NotifyFailed:        &LogCounter{},
+	StreamEvents:        &LogCounter{},
+	StreamDropped:       &LogCounter{},
+	QueryLogDropped:     &LogCounter{},
 }
 
-// PrintCurLog prints out the current LogOut and then resets
+// PrintCurLog logs the current LogOut at DebugLevel.
 func PrintCurLog() {
-	VeryVerbose.Printf("%+v\n", CurLog)
+	Log.Debug("counters", "counters", CurLog)
 }
 
-// SetupLogs provides the following logs
-// Verbose = optional verbosity
-// VeryVerbose = optional verbosity
-// Error = stderr
+// SetupLogs sets the level of the entries written by all Loggers from glog's
+// -v flag, or from VerboseFlag and VeryVerboseFlag: WarnLevel by default,
+// InfoLevel if verbose and DebugLevel if very verbose.
 func SetupLogs() {
 	// initialize logging flags
 	if glog.V(2) {
@@ -87,18 +120,12 @@ func SetupLogs() {
 		VerboseFlag = true
 	}
 
-	logopts := log.Ldate | log.Ltime | log.Lshortfile
-
-	if VerboseFlag {
-		Verbose = log.New(os.Stdout, "VERBOSE: ", logopts)
-		VeryVerbose = log.New(ioutil.Discard, "VERY VERBOSE: ", logopts)
-	} else if VeryVerboseFlag {
-		Verbose = log.New(os.Stdout, "VERY VERBOSE: ", logopts)
-		VeryVerbose = Verbose
-	} else {
-		Verbose = log.New(ioutil.Discard, "VERBOSE: ", logopts)
-		VeryVerbose = log.New(ioutil.Discard, "VERY VERBOSE: ", logopts)
+	switch {
+	case VeryVerboseFlag:
+		SetLevel(DebugLevel)
+	case VerboseFlag:
+		SetLevel(InfoLevel)
+	default:
+		SetLevel(WarnLevel)
 	}
-
-	Error = log.New(os.Stderr, "ERROR: ", logopts)
 }
@@ -0,0 +1,306 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+)
+
+// metricsPrefix is the prefix of the names of all exposed metrics.
+const metricsPrefix = "mesos_dns_"
+
+var (
+	// LatencyBuckets are the histogram buckets, in seconds, of latencies.
+	LatencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+	// Queries counts the DNS responses sent, by query type.
+	Queries = NewCounterVec()
+	// Rcodes counts the DNS responses sent, by response code.
+	Rcodes = NewCounterVec()
+	// ForwardLatency observes the latency of forwarded queries, by upstream.
+	ForwardLatency = NewHistogramVec(LatencyBuckets...)
+	// StateFetchDuration observes the duration of state.json fetches.
+	StateFetchDuration = NewHistogram(LatencyBuckets...)
+	// StateFetchBytes is the size of the last fetched state, as transferred.
+	StateFetchBytes = &Gauge{}
+	// StateFetchFailures counts the failed state.json fetches.
+	StateFetchFailures = &LogCounter{}
+)
+
+// Value returns the current value of the counter.
+func (lc *LogCounter) Value() uint64 {
+	return atomic.LoadUint64(&lc.value)
+}
+
+// CounterVec is a set of LogCounters partitioned by a label value.
+// It's safe for concurrent use.
+type CounterVec struct {
+	mu       sync.RWMutex
+	counters map[string]*LogCounter
+}
+
+// NewCounterVec returns an empty CounterVec.
+func NewCounterVec() *CounterVec {
+	return &CounterVec{counters: map[string]*LogCounter{}}
+}
+
+// With returns the Counter of the given label value, creating it if needed.
+func (cv *CounterVec) With(label string) Counter {
+	cv.mu.RLock()
+	c, ok := cv.counters[label]
+	cv.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	cv.mu.Lock()
+	defer cv.mu.Unlock()
+	if c, ok = cv.counters[label]; !ok {
+		c = &LogCounter{}
+		cv.counters[label] = c
+	}
+	return c
+}
+
+// values returns the current counter values by label value.
+func (cv *CounterVec) values() map[string]float64 {
+	cv.mu.RLock()
+	defer cv.mu.RUnlock()
+	vs := make(map[string]float64, len(cv.counters))
+	for label, c := range cv.counters {
+		vs[label] = float64(c.Value())
+	}
+	return vs
+}
+
+// Gauge is a float64 value which can go up and down.
+// It's safe for concurrent use.
+type Gauge struct {
+	bits uint64
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+}
+
+// Value returns the current value of the gauge.
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+// An Observer records observations of a value, e.g. a latency, labeled with
+// a label value.
+type Observer interface {
+	Observe(label string, v float64)
+}
+
+// Histogram counts observations in cumulative buckets.
+// It's safe for concurrent use.
+type Histogram struct {
+	mu      sync.Mutex
+	bounds  []float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// NewHistogram returns an empty Histogram with the given sorted bucket upper
+// bounds.
+func NewHistogram(bounds ...float64) *Histogram {
+	return &Histogram{bounds: bounds, buckets: make([]uint64, len(bounds))}
+}
+
+// Observe adds the observation v to the histogram.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// snapshot returns a copy of the histogram's state.
+func (h *Histogram) snapshot() (buckets []uint64, count uint64, sum float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]uint64(nil), h.buckets...), h.count, h.sum
+}
+
+// HistogramVec is a set of Histograms partitioned by a label value.
+// It's safe for concurrent use.
+type HistogramVec struct {
+	mu     sync.RWMutex
+	bounds []float64
+	hs     map[string]*Histogram
+}
+
+// NewHistogramVec returns an empty HistogramVec whose Histograms have the
+// given sorted bucket upper bounds.
+func NewHistogramVec(bounds ...float64) *HistogramVec {
+	return &HistogramVec{bounds: bounds, hs: map[string]*Histogram{}}
+}
+
+// Observe implements the Observer interface.
+func (hv *HistogramVec) Observe(label string, v float64) {
+	hv.mu.RLock()
+	h, ok := hv.hs[label]
+	hv.mu.RUnlock()
+	if !ok {
+		hv.mu.Lock()
+		if h, ok = hv.hs[label]; !ok {
+			h = NewHistogram(hv.bounds...)
+			hv.hs[label] = h
+		}
+		hv.mu.Unlock()
+	}
+	h.Observe(v)
+}
+
+// MetricsWriter writes metrics in the Prometheus text exposition format.
+// The first write error is retained and returned by Err.
+type MetricsWriter struct {
+	w   io.Writer
+	err error
+}
+
+// NewMetricsWriter returns a MetricsWriter writing to w.
+func NewMetricsWriter(w io.Writer) *MetricsWriter {
+	return &MetricsWriter{w: w}
+}
+
+// Err returns the first error encountered while writing metrics.
+func (mw *MetricsWriter) Err() error { return mw.err }
+
+func (mw *MetricsWriter) printf(format string, args ...interface{}) {
+	if mw.err == nil {
+		_, mw.err = fmt.Fprintf(mw.w, format, args...)
+	}
+}
+
+func (mw *MetricsWriter) header(name, help, typ string) {
+	mw.printf("# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, typ)
+}
+
+// Counter writes a counter metric.
+func (mw *MetricsWriter) Counter(name, help string, v uint64) {
+	mw.header(name, help, "counter")
+	mw.printf("%s%s %d\n", metricsPrefix, name, v)
+}
+
+// CounterVec writes a counter metric with a sample per label value.
+func (mw *MetricsWriter) CounterVec(name, help, label string, cv *CounterVec) {
+	mw.vec(name, help, "counter", label, cv.values())
+}
+
+// Gauge writes a gauge metric.
+func (mw *MetricsWriter) Gauge(name, help string, v float64) {
+	mw.header(name, help, "gauge")
+	mw.printf("%s%s %s\n", metricsPrefix, name, formatFloat(v))
+}
+
+// GaugeVec writes a gauge metric with a sample per label value.
+func (mw *MetricsWriter) GaugeVec(name, help, label string, vs map[string]float64) {
+	mw.vec(name, help, "gauge", label, vs)
+}
+
+func (mw *MetricsWriter) vec(name, help, typ, label string, vs map[string]float64) {
+	mw.header(name, help, typ)
+	for _, value := range sortedLabels(vs) {
+		mw.printf("%s%s{%s=%q} %s\n", metricsPrefix, name, label, value, formatFloat(vs[value]))
+	}
+}
+
+// Histogram writes a histogram metric.
+func (mw *MetricsWriter) Histogram(name, help string, h *Histogram) {
+	mw.header(name, help, "histogram")
+	mw.histogram(name, "", h)
+}
+
+// HistogramVec writes a histogram metric with a histogram per label value.
+func (mw *MetricsWriter) HistogramVec(name, help, label string, hv *HistogramVec) {
+	hv.mu.RLock()
+	hs := make(map[string]*Histogram, len(hv.hs))
+	values := make(map[string]float64, len(hv.hs))
+	for value, h := range hv.hs {
+		hs[value], values[value] = h, 0
+	}
+	hv.mu.RUnlock()
+
+	mw.header(name, help, "histogram")
+	for _, value := range sortedLabels(values) {
+		mw.histogram(name, fmt.Sprintf("%s=%q,", label, value), hs[value])
+	}
+}
+
+// histogram writes the samples of h, each with the given labels prefix.
+func (mw *MetricsWriter) histogram(name, labels string, h *Histogram) {
+	buckets, count, sum := h.snapshot()
+	for i, bound := range h.bounds {
+		mw.printf("%s%s_bucket{%sle=%q} %d\n", metricsPrefix, name, labels, formatFloat(bound), buckets[i])
+	}
+	mw.printf("%s%s_bucket{%sle=\"+Inf\"} %d\n", metricsPrefix, name, labels, count)
+	labels = strings.TrimSuffix(labels, ",")
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	mw.printf("%s%s_sum%s %s\n", metricsPrefix, name, labels, formatFloat(sum))
+	mw.printf("%s%s_count%s %d\n", metricsPrefix, name, labels, count)
+}
+
+// WriteMetrics writes every counter of CurLog along with the package level
+// metrics to mw.
+func WriteMetrics(mw *MetricsWriter) {
+	v := reflect.ValueOf(CurLog)
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i).Name
+		if c, ok := v.Field(i).Interface().(*LogCounter); ok {
+			mw.Counter(snakeCase(field)+"_total", "Value of the "+field+" counter.", c.Value())
+		}
+	}
+	mw.CounterVec("queries_total", "DNS responses sent, by query type.", "qtype", Queries)
+	mw.CounterVec("responses_total", "DNS responses sent, by response code.", "rcode", Rcodes)
+	mw.HistogramVec("forward_latency_seconds", "Latency of forwarded DNS queries, by upstream.", "upstream", ForwardLatency)
+	mw.Histogram("state_fetch_duration_seconds", "Duration of state.json fetches from the Mesos master.", StateFetchDuration)
+	mw.Gauge("state_fetch_bytes", "Size of the last state fetched from the Mesos master, as transferred.", StateFetchBytes.Value())
+	mw.Counter("state_fetch_failures_total", "Failed state.json fetches from the Mesos master.", StateFetchFailures.Value())
+}
+
+// snakeCase converts a CamelCase name to snake_case, e.g. NonMesosNXDomain
+// to non_mesos_nx_domain.
+func snakeCase(s string) string {
+	rs := []rune(s)
+	out := make([]rune, 0, len(rs)+4)
+	for i, r := range rs {
+		if unicode.IsUpper(r) && i > 0 &&
+			(unicode.IsLower(rs[i-1]) || (i+1 < len(rs) && unicode.IsLower(rs[i+1]))) {
+			out = append(out, '_')
+		}
+		out = append(out, unicode.ToLower(r))
+	}
+	return string(out)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedLabels(vs map[string]float64) []string {
+	labels := make([]string, 0, len(vs))
+	for label := range vs {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return labels
+}
@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMetricsWriter(t *testing.T) {
+	cv := NewCounterVec()
+	cv.With("AAAA").Inc()
+	cv.With("A").Inc()
+	cv.With("A").Inc()
+
+	hv := NewHistogramVec(.1, 1)
+	hv.Observe("1.2.3.4:53", .05)
+	hv.Observe("1.2.3.4:53", .5)
+	hv.Observe("1.2.3.4:53", 2)
+
+	var buf bytes.Buffer
+	mw := NewMetricsWriter(&buf)
+	mw.Counter("foo_total", "Foo.", 3)
+	mw.CounterVec("queries_total", "Queries.", "qtype", cv)
+	mw.Gauge("age_seconds", "Age.", 1.5)
+	mw.HistogramVec("latency_seconds", "Latency.", "upstream", hv)
+	if err := mw.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP mesos_dns_foo_total Foo.
+# TYPE mesos_dns_foo_total counter
+mesos_dns_foo_total 3
+# HELP mesos_dns_queries_total Queries.
+# TYPE mesos_dns_queries_total counter
+mesos_dns_queries_total{qtype="A"} 2
+mesos_dns_queries_total{qtype="AAAA"} 1
+# HELP mesos_dns_age_seconds Age.
+# TYPE mesos_dns_age_seconds gauge
+mesos_dns_age_seconds 1.5
+# HELP mesos_dns_latency_seconds Latency.
+# TYPE mesos_dns_latency_seconds histogram
+mesos_dns_latency_seconds_bucket{upstream="1.2.3.4:53",le="0.1"} 1
+mesos_dns_latency_seconds_bucket{upstream="1.2.3.4:53",le="1"} 2
+mesos_dns_latency_seconds_bucket{upstream="1.2.3.4:53",le="+Inf"} 3
+mesos_dns_latency_seconds_sum{upstream="1.2.3.4:53"} 2.55
+mesos_dns_latency_seconds_count{upstream="1.2.3.4:53"} 3
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	for i, tt := range []struct{ in, want string }{
+		{"MesosRequests", "mesos_requests"},
+		{"NonMesosNXDomain", "non_mesos_nx_domain"},
+		{"NotifySent", "notify_sent"},
+	} {
+		if got := snakeCase(tt.in); got != tt.want {
+			t.Errorf("test #%d: got %q, want %q", i, got, tt.want)
+		}
+	}
+}
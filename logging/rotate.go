@@ -0,0 +1,118 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// errClosed is returned when writing to a closed RotatingFile.
+var errClosed = errors.New("write to a closed file")
+
+// RotatingFile is an io.WriteCloser appending to a file which it rotates once
+// it would grow past a maximum size: the file is renamed with a ".1" suffix,
+// the previous backups being shifted to ".2" and so on up to a maximum number
+// of them, and a new one is created. It's safe for concurrent use.
+type RotatingFile struct {
+	path    string
+	maxSize int64
+	backups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenRotatingFile opens the given file for appending, rotating it once it
+// would grow past maxSize bytes, unless maxSize is 0, and keeping the given
+// number of backups.
+func OpenRotatingFile(path string, maxSize int64, backups int) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, maxSize: maxSize, backups: backups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f, rf.size = f, fi.Size()
+	return nil
+}
+
+// Write implements the io.Writer interface, writing p at once to the current
+// file, after rotating it if needed. If the rotation fails, p is still written
+// to the current file, and the rotation error returned; it's retried on the
+// next Write.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return 0, errClosed
+	}
+	var rotateErr error
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			rotateErr = fmt.Errorf("failed to rotate %s: %v", rf.path, err)
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+// rotate shifts the backups and opens a new file, closing the current one
+// only once replaced, so that it's kept if the rotation fails. rf.mu must be
+// held.
+func (rf *RotatingFile) rotate() error {
+	if rf.backups == 0 {
+		if err := os.Remove(rf.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		for i := rf.backups - 1; i > 0; i-- {
+			err := os.Rename(rf.backup(i), rf.backup(i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(rf.path, rf.backup(1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	old := rf.f
+	if err := rf.open(); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+// backup returns the path of the i-th backup.
+func (rf *RotatingFile) backup(i int) string {
+	return rf.path + "." + strconv.Itoa(i)
+}
+
+// Close implements the io.Closer interface.
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.f == nil {
+		return nil
+	}
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
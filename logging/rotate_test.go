@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesos-dns-rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "queries.log")
+	if err = ioutil.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rf, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"a\n", "bbbb\n", "cccc\n", "dddddddddddd\n", "e\n"} {
+		if _, err = rf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rf.Write([]byte("f\n")); err == nil {
+		t.Error("expected an error writing to a closed file")
+	}
+
+	// "old\na\n" was rotated out past 2 backups, and lines longer than the
+	// maximum size get a file of their own
+	for name, want := range map[string]string{
+		path:        "e\n",
+		path + ".1": "dddddddddddd\n",
+		path + ".2": "bbbb\ncccc\n",
+		path + ".3": "",
+	} {
+		got, err := ioutil.ReadFile(name)
+		if want == "" {
+			if !os.IsNotExist(err) {
+				t.Errorf("%s: got %q, %v; want no such file", name, got, err)
+			}
+		} else if string(got) != want {
+			t.Errorf("%s: got %q, %v; want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestRotatingFile_Failed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesos-dns-rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "queries.log")
+	// a non empty directory in the way of the first backup
+	if err = os.MkdirAll(filepath.Join(path+".1", "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	rf, err := OpenRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	if _, err = rf.Write([]byte("aaaa\n")); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := rf.Write([]byte("bbbbbbb\n")); n != 8 || err == nil {
+		t.Errorf("got %d, %v; want the line written despite a rotation error", n, err)
+	}
+
+	// the rotation is retried once it can succeed
+	if err = os.RemoveAll(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rf.Write([]byte("c\n")); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		path:        "c\n",
+		path + ".1": "aaaa\nbbbbbbb\n",
+	} {
+		if got, err := ioutil.ReadFile(name); string(got) != want {
+			t.Errorf("%s: got %q, %v; want %q", name, got, err, want)
+		}
+	}
+}
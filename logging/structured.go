@@ -0,0 +1,249 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level is the severity of a log entry.
+type Level int32
+
+// Levels of log entries, in increasing severity.
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// String implements the fmt.Stringer interface.
+func (l Level) String() string {
+	if l < DebugLevel || l > ErrorLevel {
+		return "Level(" + strconv.Itoa(int(l)) + ")"
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the Level of the given name: "debug", "info", "warn" or
+// "error".
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", name)
+}
+
+// Formats of log entries.
+const (
+	// TextFormat writes entries as key=value pairs.
+	TextFormat = "text"
+	// JSONFormat writes entries as JSON objects.
+	JSONFormat = "json"
+)
+
+// output is where all the Loggers write their entries.
+var output = struct {
+	level int32 // a Level, accessed atomically
+
+	mu   sync.Mutex
+	w    io.Writer
+	json bool
+}{level: int32(WarnLevel), w: os.Stderr}
+
+// SetLevel sets the minimum level of the entries written by all Loggers.
+func SetLevel(l Level) {
+	atomic.StoreInt32(&output.level, int32(l))
+}
+
+// CurrentLevel returns the minimum level of the entries written by all
+// Loggers.
+func CurrentLevel() Level {
+	return Level(atomic.LoadInt32(&output.level))
+}
+
+// SetFormat sets the format of the entries written by all Loggers: TextFormat
+// or JSONFormat.
+func SetFormat(format string) error {
+	switch format {
+	case TextFormat, JSONFormat:
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	output.mu.Lock()
+	defer output.mu.Unlock()
+	output.json = format == JSONFormat
+	return nil
+}
+
+// SetOutput sets the writer of the entries written by all Loggers, stderr
+// by default.
+func SetOutput(w io.Writer) {
+	output.mu.Lock()
+	defer output.mu.Unlock()
+	output.w = w
+}
+
+// Configure sets the level and the format of the entries written by all
+// Loggers from their names, leaving them unchanged if empty.
+func Configure(level, format string) error {
+	if level != "" {
+		l, err := ParseLevel(level)
+		if err != nil {
+			return err
+		}
+		SetLevel(l)
+	}
+	if format != "" {
+		return SetFormat(format)
+	}
+	return nil
+}
+
+// Logger writes leveled entries made of a message and of key-value pairs of
+// fields, along with the fields of the Logger. It's safe for concurrent use.
+type Logger struct {
+	fields []interface{}
+}
+
+// Log is the root Logger, without fields.
+var Log = &Logger{}
+
+// With returns a Logger adding the given key-value pairs to the fields of
+// the entries of l.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	return &Logger{fields: append(append(fields, l.fields...), kv...)}
+}
+
+// Enabled returns true if entries of the given level are written.
+func (l *Logger) Enabled(lv Level) bool {
+	return lv >= CurrentLevel()
+}
+
+// Debug writes a DebugLevel entry with the given message and key-value pairs.
+func (l *Logger) Debug(msg string, kv ...interface{}) { l.log(DebugLevel, msg, kv) }
+
+// Info writes an InfoLevel entry with the given message and key-value pairs.
+func (l *Logger) Info(msg string, kv ...interface{}) { l.log(InfoLevel, msg, kv) }
+
+// Warn writes a WarnLevel entry with the given message and key-value pairs.
+func (l *Logger) Warn(msg string, kv ...interface{}) { l.log(WarnLevel, msg, kv) }
+
+// Error writes an ErrorLevel entry with the given message and key-value pairs.
+func (l *Logger) Error(msg string, kv ...interface{}) { l.log(ErrorLevel, msg, kv) }
+
+func (l *Logger) log(lv Level, msg string, kv []interface{}) {
+	if !l.Enabled(lv) {
+		return
+	}
+	fields := append(append([]interface{}{
+		"time", time.Now().UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+		"level", lv.String(),
+		"msg", msg,
+	}, l.fields...), kv...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, nil)
+	}
+
+	output.mu.Lock()
+	defer output.mu.Unlock()
+	var buf bytes.Buffer
+	if output.json {
+		encodeJSON(&buf, fields)
+	} else {
+		encodeText(&buf, fields)
+	}
+	buf.WriteByte('\n')
+	_, _ = output.w.Write(buf.Bytes())
+}
+
+// encodeJSON writes the given key-value pairs as a JSON object.
+func encodeJSON(buf *bytes.Buffer, fields []interface{}) {
+	buf.WriteByte('{')
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(fmt.Sprint(fields[i]))
+		buf.Write(key)
+		buf.WriteByte(':')
+		value, err := json.Marshal(jsonValue(fields[i+1]))
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(fields[i+1]))
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+}
+
+// jsonValue returns what the given field value is encoded as in JSON: the
+// message of errors, the string of Stringers which aren't JSON Marshalers,
+// and the value itself otherwise.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case json.Marshaler:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
+
+// encodeText writes the given key-value pairs as space separated key=value
+// pairs, quoting the values as needed. Values other than strings, numbers,
+// booleans, errors and Stringers are encoded in JSON.
+func encodeText(buf *bytes.Buffer, fields []interface{}) {
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(fmt.Sprint(fields[i]))
+		buf.WriteByte('=')
+		var s string
+		switch v := fields[i+1].(type) {
+		case nil:
+			s = "null"
+		case string:
+			s = v
+		case error:
+			s = v.Error()
+		case fmt.Stringer:
+			s = v.String()
+		case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			s = fmt.Sprint(v)
+		default:
+			b, err := json.Marshal(v)
+			if err != nil {
+				b = []byte(fmt.Sprint(v))
+			}
+			s = string(b)
+		}
+		if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+			s = strconv.Quote(s)
+		}
+		buf.WriteString(s)
+	}
+}
+
+// levelWriter is an io.Writer writing each line written to it as an entry of
+// the given level of the root Logger, for log.Loggers.
+type levelWriter Level
+
+func (lw levelWriter) Write(p []byte) (int, error) {
+	Log.log(Level(lw), strings.TrimSuffix(string(p), "\n"), nil)
+	return len(p), nil
+}
@@ -0,0 +1,111 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// capture returns the entries written by fn, without their time, in the
+// given format and at the given level.
+func capture(t *testing.T, format string, level Level, fn func()) []string {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stderr)
+	if err := SetFormat(format); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = SetFormat(TextFormat) }()
+	defer SetLevel(CurrentLevel())
+	SetLevel(level)
+
+	fn()
+
+	var entries []string
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		if format == JSONFormat {
+			var e map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &e); err != nil {
+				t.Fatalf("invalid entry %q: %v", line, err)
+			}
+			if _, err := time.Parse(time.RFC3339, e["time"].(string)); err != nil {
+				t.Errorf("invalid time in %q: %v", line, err)
+			}
+			line = `{"level"` + strings.SplitN(line, `"level"`, 2)[1]
+		} else {
+			if !strings.HasPrefix(line, "time=") {
+				t.Errorf("got entry %q, want a time first", line)
+			}
+			line = strings.SplitN(line, " ", 2)[1]
+		}
+		entries = append(entries, line)
+	}
+	return entries
+}
+
+func TestLogger(t *testing.T) {
+	l := Log.With("component", "test")
+	log := func() {
+		l.Debug("hidden")
+		l.Info("loaded", "file", "/etc/mesos-dns/config.json", "n", 3, "ok", true)
+		l.With("master", "10.0.0.1:5050").Warn("fetch failed", "error", errors.New("connection refused"))
+		l.Error("odd", "key", map[string]int{"a": 1}, "backoff", 1500*time.Millisecond, "dangling")
+		Verbose.Println("bridged")
+	}
+
+	for format, want := range map[string][]string{
+		TextFormat: {
+			`level=info msg=loaded component=test file=/etc/mesos-dns/config.json n=3 ok=true`,
+			`level=warn msg="fetch failed" component=test master=10.0.0.1:5050 error="connection refused"`,
+			`level=error msg=odd component=test key="{\"a\":1}" backoff=1.5s dangling=null`,
+			`level=info msg=bridged`,
+		},
+		JSONFormat: {
+			`{"level":"info","msg":"loaded","component":"test","file":"/etc/mesos-dns/config.json","n":3,"ok":true}`,
+			`{"level":"warn","msg":"fetch failed","component":"test","master":"10.0.0.1:5050","error":"connection refused"}`,
+			`{"level":"error","msg":"odd","component":"test","key":{"a":1},"backoff":"1.5s","dangling":null}`,
+			`{"level":"info","msg":"bridged"}`,
+		},
+	} {
+		got := capture(t, format, InfoLevel, log)
+		if len(got) != len(want) {
+			t.Fatalf("%s: got entries %q, want %q", format, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s entry #%d: got %s, want %s", format, i, got[i], want[i])
+			}
+		}
+	}
+
+	if got := capture(t, TextFormat, ErrorLevel, log); len(got) != 1 {
+		t.Errorf("got entries %q, want the error one only", got)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for i, tt := range []struct {
+		name string
+		want Level
+		ok   bool
+	}{
+		{"debug", DebugLevel, true},
+		{"Info", InfoLevel, true},
+		{"WARN", WarnLevel, true},
+		{"error", ErrorLevel, true},
+		{"verbose", 0, false},
+		{"", 0, false},
+	} {
+		got, err := ParseLevel(tt.name)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("test #%d: got %v and error %v, want %v", i, got, err, tt.want)
+		}
+	}
+}
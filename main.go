@@ -3,8 +3,10 @@ package main
 import (
 	"flag"
 	"fmt"
-	"log"
 	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
 	"time"
 
 	"github.com/mesos/mesos-go/detector"
@@ -38,8 +40,14 @@ func main() {
 	logging.SetupLogs()
 
 	// initialize resolver
-	config := records.SetConfig(*cjson)
-	res := resolver.New(Version, config)
+	config, err := records.SetConfig(*cjson)
+	if err != nil {
+		fatal("invalid configuration", "error", err)
+	}
+	res, err := resolver.New(Version, config)
+	if err != nil {
+		fatal("failed to start", "error", err)
+	}
 	errch := make(chan error)
 
 	// launch DNS server
@@ -52,7 +60,7 @@ func main() {
 		go func() { errch <- <-res.LaunchHTTP() }()
 	}
 
-	changed := detectMasters(config.Zk, config.Masters)
+	md, changed := detectMasters(config.Zk, config.Masters)
 	reload := time.NewTicker(time.Second * time.Duration(config.RefreshSeconds))
 	zkTimeout := time.Second * time.Duration(config.ZkDetectionTimeout)
 	timeout := time.AfterFunc(zkTimeout, func() {
@@ -61,7 +69,11 @@ func main() {
 		}
 	})
 
-	defer reload.Stop()
+	// reload the config file on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	defer func() { reload.Stop() }()
 	defer util.HandleCrash()
 	for {
 		select {
@@ -73,26 +85,55 @@ func main() {
 			} else {
 				timeout.Stop()
 			}
-			logging.VeryVerbose.Printf("new masters detected: %v", masters)
+			logging.Log.Debug("new masters detected", "masters", masters)
 			res.SetMasters(masters)
 			res.Reload()
+		case <-hup:
+			if err := res.ReloadConfig(); err != nil {
+				logging.Log.Error("config reload failed", "error", err)
+			}
+		case c := <-res.ConfigReloads():
+			if c.RefreshSeconds != config.RefreshSeconds {
+				reload.Stop()
+				reload = time.NewTicker(time.Second * time.Duration(c.RefreshSeconds))
+			}
+			if c.Zk != config.Zk || !reflect.DeepEqual(c.Masters, config.Masters) {
+				if md != nil {
+					md.Cancel()
+				}
+				md, changed = detectMasters(c.Zk, c.Masters)
+			}
+			config = c
+			res.Reload()
 		case err := <-errch:
-			logging.Error.Fatal(err)
+			fatal("server failed", "error", err)
 		}
 	}
 }
 
-func detectMasters(zk string, masters []string) <-chan []string {
+// fatal logs an error entry with the given message and key-value pairs, and
+// exits.
+func fatal(msg string, kv ...interface{}) {
+	logging.Log.Error(msg, kv...)
+	os.Exit(1)
+}
+
+// detectMasters returns the channel to which the masters detected in the
+// given ZK are sent, along with their detector, or the given masters if zk is
+// empty, in which case the detector is nil.
+func detectMasters(zk string, masters []string) (detector.Master, <-chan []string) {
 	changed := make(chan []string, 1)
-	if zk != "" {
-		logging.Verbose.Println("Starting master detector for ZK ", zk)
-		if md, err := detector.New(zk); err != nil {
-			log.Fatalf("failed to create master detector: %v", err)
-		} else if err := md.Detect(detect.NewMasters(masters, changed)); err != nil {
-			log.Fatalf("failed to initialize master detector: %v", err)
-		}
-	} else {
+	if zk == "" {
 		changed <- masters
+		return nil, changed
+	}
+
+	logging.Log.Info("starting the master detector", "zk", zk)
+	md, err := detector.New(zk)
+	if err != nil {
+		fatal("failed to create the master detector", "error", err)
+	} else if err := md.Detect(detect.NewMasters(masters, changed)); err != nil {
+		fatal("failed to initialize the master detector", "error", err)
 	}
-	return changed
+	return md, changed
 }
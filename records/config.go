@@ -43,10 +43,14 @@ type Config struct {
 	SOARname   string // email of admin esponsible
 	// Mesos master(s): a list of IP:port pairs for one or more Mesos masters
 	Masters []string
-	// DNS server: IP address of the DNS server for forwarded accesses
+	// DNS server: IP address of the DNS server for forwarded accesses, or
+	// tls:// or https:// URL of an encrypted one
 	Resolvers []string
 	// IPSources is the prioritized list of task IP sources
 	IPSources []string // e.g. ["host", "docker", "mesos", "rkt"]
+	// TXTLabels is the list of task label keys published in TXT records,
+	// next to the task's DiscoveryInfo labels
+	TXTLabels []string
 	// Zookeeper: a single Zk url
 	Zk string
 	//  Domain: name of the domain used (default "mesos", ie .mesos domain)
@@ -66,63 +70,302 @@ type Config struct {
 	EnforceRFC952 bool
 	// Enumeration enabled via the API enumeration endpoint
 	EnumerationOn bool
+	// ZoneTransferCIDRs is the list of client networks (in CIDR notation)
+	// allowed to transfer the Mesos domain via AXFR and IXFR
+	ZoneTransferCIDRs []string
+	// NotifySecondaries is the list of secondary DNS servers (IP or IP:port)
+	// sent a DNS NOTIFY whenever the records of the Mesos domain change
+	NotifySecondaries []string
+	// DNSSECKeys is the list of BIND-style DNSSEC key files, without their
+	// .key and .private extensions, used to sign the Mesos domain
+	DNSSECKeys []string
+	// DNSSECNSEC3 enables NSEC3 rather than NSEC denial of existence
+	DNSSECNSEC3 bool
+	// ReadyMaxStaleSeconds is the maximum age in seconds of the served
+	// records before Mesos-DNS reports not being ready (default 180)
+	ReadyMaxStaleSeconds int
+	// ReadyMaxLeaderlessSeconds is how long in seconds Mesos-DNS may go
+	// without a leading master detected in Zookeeper before it reports not
+	// being ready (default 60)
+	ReadyMaxLeaderlessSeconds int
+	// EventStreamOn enables keeping the records up to date from the event
+	// stream of the Mesos v1 operator API rather than polling state.json
+	EventStreamOn bool
+	// MesosHTTPSOn fetches the state of the Mesos masters over HTTPS
+	MesosHTTPSOn bool
+	// CACertFile is the PEM file of the CA certificates verifying the
+	// certificates of the Mesos masters, instead of the system ones
+	CACertFile string
+	// CertFile and KeyFile are the PEM files of the client certificate and
+	// key presented to the Mesos masters
+	CertFile string
+	KeyFile  string
+	// MesosCredentials are the HTTP basic auth credentials of the Mesos
+	// masters
+	MesosCredentials Credentials
+	// MesosAuthTokenFile is the file holding the bearer token sent to the
+	// Mesos masters, read before each request
+	MesosAuthTokenFile string
+	// IAMConfigFile is the service account file used to log in to an IAM
+	// service, whose token is sent to the Mesos masters
+	IAMConfigFile string
+	// StaticRecordsFile is the zone or JSON file of the static records merged
+	// into the generated ones
+	StaticRecordsFile string
+	// ForwardZones maps zones, e.g. "consul", to the upstream resolvers their
+	// queries are forwarded to instead of Resolvers
+	ForwardZones map[string]ForwardZone
+	// ForwardCacheSize is the maximum size in bytes of the cached responses
+	// to forwarded queries, 0 disabling the cache
+	ForwardCacheSize int
+	// ForwardStrategy selects the upstream resolvers queries are forwarded
+	// to: "sequential", "random", "round_robin", "fastest" or "parallel"
+	ForwardStrategy string
+	// ForwardBreakerFailures is the number of failed queries in a row after
+	// which an upstream resolver is skipped, 0 never skipping them
+	ForwardBreakerFailures int
+	// ForwardBreakerCooldownSeconds is how long a failing upstream resolver
+	// is skipped
+	ForwardBreakerCooldownSeconds int
+	// ForwardCACertFile is the PEM file of the CA certificates verifying the
+	// certificates of the tls:// and https:// resolvers, instead of the system
+	// ones
+	ForwardCACertFile string
+	// DoTOn enables the DNS-over-TLS (RFC 7858) listener on DoTPort
+	DoTOn   bool
+	DoTPort int
+	// DoHOn enables DNS-over-HTTPS (RFC 8484) at /dns-query on the HTTP
+	// server
+	DoHOn bool
+	// TLSCertFile and TLSKeyFile are the PEM files of the certificate and key
+	// of the DNS-over-TLS listener
+	TLSCertFile string
+	TLSKeyFile  string
+	// HTTPCertFile and HTTPKeyFile are the PEM files of the certificate and
+	// key of the HTTP server, which then serves HTTPS
+	HTTPCertFile string
+	HTTPKeyFile  string
+	// RateLimitQPS is the number of queries per second each client network
+	// may send, up to RateLimitBurst at once, 0 disabling the limit
+	RateLimitQPS   int
+	RateLimitBurst int
+	// RateLimitIPv4Prefix and RateLimitIPv6Prefix are the prefix lengths of
+	// the client networks rate limits apply to
+	RateLimitIPv4Prefix int
+	RateLimitIPv6Prefix int
+	// RateLimitExemptCIDRs are the client networks exempt from rate limits
+	RateLimitExemptCIDRs []string
+	// RRLResponsesPerSecond is the number of identical responses per second
+	// sent to each client network over UDP, 0 disabling the limit
+	RRLResponsesPerSecond int
+	// RRLSlip is how often responses over the limit are sent truncated
+	// rather than dropped: every RRLSlip-th one, 0 dropping them all
+	RRLSlip int
+	// Views are the split-horizon views of the records served to the clients
+	// in their networks, the first matching one applying
+	Views []View
+	// AdminCIDRs are the client networks allowed to use the admin HTTP
+	// endpoints, the clients in no view if empty
+	AdminCIDRs []string
+	// QueryLogOn enables the query log, a JSON line per sampled query written
+	// to QueryLogFile, or stdout if empty
+	QueryLogOn   bool
+	QueryLogFile string
+	// QueryLogSampleRate is the fraction of the queries logged
+	QueryLogSampleRate float64
+	// QueryLogMaxSizeMB is the size past which QueryLogFile is rotated,
+	// keeping QueryLogMaxBackups of the previous ones, 0 disabling rotation
+	QueryLogMaxSizeMB  int
+	QueryLogMaxBackups int
+
+	// LogLevel is the minimum level ("debug", "info", "warn" or "error") of
+	// the logged entries, set from the -v flag if empty
+	LogLevel string
+	// LogFormat is the format of the logged entries: "text" or "json"
+	LogFormat string
+}
+
+// View configures what the clients in its networks are served.
+type View struct {
+	// Name identifies the view
+	Name string
+	// CIDRs are the client networks of the view
+	CIDRs []string
+	// Subdomains are the frameworks and other subdomains of the Mesos domain
+	// visible in the view, all of them if empty
+	Subdomains []string
+	// ExternalOn allows forwarding the non-Mesos queries of the view
+	ExternalOn bool
+	// IPSources overrides the IPSources of the view's task records
+	IPSources []string
+}
+
+// ForwardZone configures the forwarding of the queries of a zone.
+type ForwardZone struct {
+	// Resolvers are the upstream resolvers of the zone (IP, IP:port, or
+	// tls:// or https:// URL)
+	Resolvers []string
+	// Protocol is the protocol ("udp" or "tcp") of the forwarded queries,
+	// if not the one of the original query
+	Protocol string
+	// Timeout overrides the connect/read/write timeout for the queries of
+	// the zone
+	Timeout int
+	// Strategy overrides ForwardStrategy for the resolvers of the zone
+	Strategy string
+}
+
+// Credentials holds HTTP basic auth credentials.
+type Credentials struct {
+	Principal string
+	Secret    string
+}
+
+// MarshalJSON implements the json.Marshaler interface, redacting the secret
+// so it's not exposed by the /v1/config HTTP endpoint.
+func (c Credentials) MarshalJSON() ([]byte, error) {
+	secret := ""
+	if c.Secret != "" {
+		secret = "<redacted>"
+	}
+	return json.Marshal(struct{ Principal, Secret string }{c.Principal, secret})
 }
 
 // NewConfig return the default config of the resolver
 func NewConfig() Config {
 	return Config{
-		ZkDetectionTimeout:  30,
-		RefreshSeconds:      60,
-		TTL:                 60,
-		Domain:              "mesos",
-		Port:                53,
-		Timeout:             5,
-		StateTimeoutSeconds: 300,
-		SOARname:            "root.ns1.mesos",
-		SOAMname:            "ns1.mesos",
-		SOARefresh:          60,
-		SOARetry:            600,
-		SOAExpire:           86400,
-		SOAMinttl:           60,
-		Resolvers:           []string{"8.8.8.8"},
-		Listener:            "0.0.0.0",
-		HTTPPort:            8123,
-		DNSOn:               true,
-		HTTPOn:              true,
-		ExternalOn:          true,
-		RecurseOn:           true,
-		IPSources:           []string{"netinfo", "mesos", "host"},
-		EnumerationOn:       true,
+		ZkDetectionTimeout:            30,
+		RefreshSeconds:                60,
+		TTL:                           60,
+		Domain:                        "mesos",
+		Port:                          53,
+		Timeout:                       5,
+		StateTimeoutSeconds:           300,
+		SOARname:                      "root.ns1.mesos",
+		SOAMname:                      "ns1.mesos",
+		SOARefresh:                    60,
+		SOARetry:                      600,
+		SOAExpire:                     86400,
+		SOAMinttl:                     60,
+		Resolvers:                     []string{"8.8.8.8"},
+		Listener:                      "0.0.0.0",
+		HTTPPort:                      8123,
+		DNSOn:                         true,
+		HTTPOn:                        true,
+		ExternalOn:                    true,
+		RecurseOn:                     true,
+		IPSources:                     []string{"netinfo", "mesos", "host"},
+		TXTLabels:                     []string{},
+		EnumerationOn:                 true,
+		ZoneTransferCIDRs:             []string{},
+		NotifySecondaries:             []string{},
+		DNSSECKeys:                    []string{},
+		ForwardZones:                  map[string]ForwardZone{},
+		ForwardStrategy:               "sequential",
+		ForwardBreakerFailures:        3,
+		ForwardBreakerCooldownSeconds: 30,
+		DoTPort:                       853,
+		RateLimitIPv4Prefix:           24,
+		RateLimitIPv6Prefix:           56,
+		RateLimitExemptCIDRs:          []string{},
+		RRLSlip:                       2,
+		Views:                         []View{},
+		AdminCIDRs:                    []string{},
+		QueryLogSampleRate:            1,
+		QueryLogMaxSizeMB:             100,
+		QueryLogMaxBackups:            5,
+		LogFormat:                     "text",
+		ReadyMaxStaleSeconds:          180,
+		ReadyMaxLeaderlessSeconds:     60,
+	}
+}
+
+// SetConfig instantiates a Config struct read in from config.json, and
+// applies its logging parameters.
+func SetConfig(cjson string) (Config, error) {
+	c, err := LoadConfig(cjson)
+	if err != nil {
+		return Config{}, err
+	}
+	if err = logging.Configure(c.LogLevel, c.LogFormat); err != nil {
+		return Config{}, err
 	}
+	logger.Info("Mesos-DNS configuration", "config", c)
+	return c, nil
 }
 
-// SetConfig instantiates a Config struct read in from config.json
-func SetConfig(cjson string) Config {
+// LoadConfig reads the config file at cjson, then validates and completes it.
+// Unlike SetConfig, it doesn't apply the logging parameters, so it can be used
+// to validate the configuration reloaded by a running Mesos-DNS first.
+func LoadConfig(cjson string) (Config, error) {
 	c, err := readConfig(cjson)
 	if err != nil {
-		logging.Error.Fatal(err)
+		return Config{}, err
 	}
-	logging.Verbose.Printf("config loaded from %q", c.File)
+	logger.Info("config loaded", "file", c.File)
 	// validate and complete configuration file
-	err = validateEnabledServices(c)
-	if err != nil {
-		logging.Error.Fatalf("service validation failed: %v", err)
+	if err = validateEnabledServices(c); err != nil {
+		return Config{}, fmt.Errorf("service validation failed: %v", err)
 	}
 	if err = validateMasters(c.Masters); err != nil {
-		logging.Error.Fatalf("Masters validation failed: %v", err)
+		return Config{}, fmt.Errorf("Masters validation failed: %v", err)
 	}
 
 	if c.ExternalOn {
 		if len(c.Resolvers) == 0 {
-			c.Resolvers = GetLocalDNS()
+			if c.Resolvers, err = GetLocalDNS(); err != nil {
+				return Config{}, fmt.Errorf("Resolvers validation failed: %v", err)
+			}
 		}
 		if err = validateResolvers(c.Resolvers); err != nil {
-			logging.Error.Fatalf("Resolvers validation failed: %v", err)
+			return Config{}, fmt.Errorf("Resolvers validation failed: %v", err)
+		}
+		if err = validateForwardZones(c.ForwardZones, c.Domain); err != nil {
+			return Config{}, fmt.Errorf("ForwardZones validation failed: %v", err)
+		}
+		if err = validateForwarding(c); err != nil {
+			return Config{}, fmt.Errorf("forwarding validation failed: %v", err)
 		}
 	}
 
 	if err = validateIPSources(c.IPSources); err != nil {
-		logging.Error.Fatalf("IPSources validation failed: %v", err)
+		return Config{}, fmt.Errorf("IPSources validation failed: %v", err)
+	}
+
+	if err = validateCIDRs(c.ZoneTransferCIDRs); err != nil {
+		return Config{}, fmt.Errorf("ZoneTransferCIDRs validation failed: %v", err)
+	}
+
+	if err = validateSecondaries(c.NotifySecondaries); err != nil {
+		return Config{}, fmt.Errorf("NotifySecondaries validation failed: %v", err)
+	}
+
+	if err = validateMesosAuth(c); err != nil {
+		return Config{}, fmt.Errorf("Mesos authentication validation failed: %v", err)
+	}
+
+	if err = validateEncryptedListeners(c); err != nil {
+		return Config{}, fmt.Errorf("encrypted listeners validation failed: %v", err)
+	}
+
+	if err = validateRateLimits(c); err != nil {
+		return Config{}, fmt.Errorf("rate limits validation failed: %v", err)
+	}
+
+	if err = validateViews(c.Views); err != nil {
+		return Config{}, fmt.Errorf("Views validation failed: %v", err)
+	}
+
+	if err = validateCIDRs(c.AdminCIDRs); err != nil {
+		return Config{}, fmt.Errorf("AdminCIDRs validation failed: %v", err)
+	}
+
+	if err = validateQueryLog(c); err != nil {
+		return Config{}, fmt.Errorf("query log validation failed: %v", err)
+	}
+
+	if err = validateLogging(c); err != nil {
+		return Config{}, fmt.Errorf("logging validation failed: %v", err)
 	}
 
 	c.Domain = strings.ToLower(c.Domain)
@@ -132,37 +375,7 @@ func SetConfig(cjson string) Config {
 	c.SOAMname = strings.TrimRight(c.SOAMname, ".") + "."
 	c.SOASerial = uint32(time.Now().Unix())
 
-	// print configuration file
-	logging.Verbose.Println("Mesos-DNS configuration:")
-	logging.Verbose.Println("   - Masters: " + strings.Join(c.Masters, ", "))
-	logging.Verbose.Println("   - Zookeeper: ", c.Zk)
-	logging.Verbose.Println("   - ZookeeperDetectionTimeout: ", c.ZkDetectionTimeout)
-	logging.Verbose.Println("   - RefreshSeconds: ", c.RefreshSeconds)
-	logging.Verbose.Println("   - Domain: " + c.Domain)
-	logging.Verbose.Println("   - Listener: " + c.Listener)
-	logging.Verbose.Println("   - Port: ", c.Port)
-	logging.Verbose.Println("   - DnsOn: ", c.DNSOn)
-	logging.Verbose.Println("   - TTL: ", c.TTL)
-	logging.Verbose.Println("   - Timeout: ", c.Timeout)
-	logging.Verbose.Println("   - StateTimeoutSeconds: ", c.StateTimeoutSeconds)
-	logging.Verbose.Println("   - Resolvers: " + strings.Join(c.Resolvers, ", "))
-	logging.Verbose.Println("   - ExternalOn: ", c.ExternalOn)
-	logging.Verbose.Println("   - SOAMname: " + c.SOAMname)
-	logging.Verbose.Println("   - SOARname: " + c.SOARname)
-	logging.Verbose.Println("   - SOASerial: ", c.SOASerial)
-	logging.Verbose.Println("   - SOARefresh: ", c.SOARefresh)
-	logging.Verbose.Println("   - SOARetry: ", c.SOARetry)
-	logging.Verbose.Println("   - SOAExpire: ", c.SOAExpire)
-	logging.Verbose.Println("   - SOAExpire: ", c.SOAMinttl)
-	logging.Verbose.Println("   - RecurseOn: ", c.RecurseOn)
-	logging.Verbose.Println("   - HttpPort: ", c.HTTPPort)
-	logging.Verbose.Println("   - HttpOn: ", c.HTTPOn)
-	logging.Verbose.Println("   - ConfigFile: ", c.File)
-	logging.Verbose.Println("   - EnforceRFC952: ", c.EnforceRFC952)
-	logging.Verbose.Println("   - IPSources: ", c.IPSources)
-	logging.Verbose.Println("   - EnumerationOn", c.EnumerationOn)
-
-	return *c
+	return *c, nil
 }
 
 func readConfig(file string) (*Config, error) {
@@ -202,13 +415,13 @@ func unique(ss []string) []string {
 
 // GetLocalDNS returns the first nameserver in /etc/resolv.conf
 // Used for non-Mesos queries.
-func GetLocalDNS() []string {
+func GetLocalDNS() ([]string, error) {
 	conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
 	if err != nil {
-		logging.Error.Fatalf("%v", err)
+		return nil, err
 	}
 
-	return nonLocalAddies(conf.Servers)
+	return nonLocalAddies(conf.Servers), nil
 }
 
 // Returns non-local nameserver entries
@@ -237,7 +450,7 @@ func nonLocalAddies(cservers []string) []string {
 func localAddies() []string {
 	addies, err := net.InterfaceAddrs()
 	if err != nil {
-		logging.Error.Println(err)
+		logger.Warn("failed to list the interface addresses", "error", err)
 	}
 
 	bad := []string{}
@@ -245,7 +458,7 @@ func localAddies() []string {
 	for i := 0; i < len(addies); i++ {
 		ip, _, err := net.ParseCIDR(addies[i].String())
 		if err != nil {
-			logging.Error.Println(err)
+			logger.Warn("invalid interface address", "error", err)
 		}
 		t4 := ip.To4()
 		if t4 != nil {
@@ -1,6 +1,7 @@
 package records
 
 import (
+	"encoding/json"
 	"testing"
 )
 
@@ -29,6 +30,14 @@ func TestNewConfigValidates(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
+	err = validateCIDRs(c.ZoneTransferCIDRs)
+	if err != nil {
+		t.Error(err)
+	}
+	err = validateSecondaries(c.NotifySecondaries)
+	if err != nil {
+		t.Error(err)
+	}
 	err = validateEnabledServices(&c)
 	if err == nil {
 		t.Error("expected error because no masters and no zk servers are configured by default")
@@ -39,3 +48,17 @@ func TestNewConfigValidates(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestCredentialsRedacted(t *testing.T) {
+	b, err := json.Marshal(Credentials{Principal: "mesos-dns", Secret: "s3cr3t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Credentials
+	if err = json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := (Credentials{Principal: "mesos-dns", Secret: "<redacted>"}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
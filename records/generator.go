@@ -3,14 +3,16 @@
 package records
 
 import (
+	"compress/gzip"
 	"crypto/sha1"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"math"
 	"net"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -19,9 +21,12 @@ import (
 	"github.com/mesosphere/mesos-dns/logging"
 	"github.com/mesosphere/mesos-dns/records/labels"
 	"github.com/mesosphere/mesos-dns/records/state"
+	"github.com/miekg/dns"
 	"github.com/tv42/zbase32"
 )
 
+var logger = logging.Log.With("component", "records")
+
 // Map host/service name to DNS answer
 // REFACTOR - when discoveryinfo is integrated
 // Will likely become map[string][]discoveryinfo
@@ -46,6 +51,25 @@ func (r rrs) add(name, host string) bool {
 	return true
 }
 
+// equal returns true if r and o hold the same names and hosts.
+func (r rrs) equal(o rrs) bool {
+	if len(r) != len(o) {
+		return false
+	}
+	for name, hosts := range r {
+		other, ok := o[name]
+		if !ok || len(hosts) != len(other) {
+			return false
+		}
+		for host := range hosts {
+			if _, ok := other[host]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 func (r rrs) First(name string) (string, bool) {
 	for host := range r[name] {
 		return host, true
@@ -53,21 +77,136 @@ func (r rrs) First(name string) (string, bool) {
 	return "", false
 }
 
+// HostPort is a port allocated to a task, either in its resources or in its
+// DiscoveryInfo.
+type HostPort struct {
+	Port int `json:"port"`
+	// Protocol is empty if the port may be used with any protocol
+	Protocol string `json:"protocol"`
+	// Name is the DiscoveryInfo name of the port, if any
+	Name   string `json:"name"`
+	TaskID string `json:"task_id"`
+	// Source is either "resources" or "discovery"
+	Source string `json:"source"`
+}
+
+// Port sources of HostPorts
+const (
+	PortSourceResources = "resources"
+	PortSourceDiscovery = "discovery"
+)
+
+// Map host name to the ports allocated to the tasks it resolves to
+type hostPorts map[string]map[HostPort]struct{}
+
+func (hp hostPorts) add(host string, port HostPort) {
+	ports, ok := hp[host]
+	if !ok {
+		ports = map[HostPort]struct{}{}
+		hp[host] = ports
+	}
+	ports[port] = struct{}{}
+}
+
+// SRVPreference is the priority and weight of an SRV record.
+type SRVPreference struct {
+	Priority uint16 `json:"priority"`
+	Weight   uint16 `json:"weight"`
+}
+
+// Map SRV name to the preferences of the tasks behind each of its targets, by
+// task ID
+type srvPreferences map[string]map[string]map[string]SRVPreference
+
+func (sp srvPreferences) add(name, target, taskID string, pref SRVPreference) {
+	targets, ok := sp[name]
+	if !ok {
+		targets = map[string]map[string]SRVPreference{}
+		sp[name] = targets
+	}
+	tasks, ok := targets[target]
+	if !ok {
+		tasks = map[string]SRVPreference{}
+		targets[target] = tasks
+	}
+	tasks[taskID] = pref
+}
+
+// Get returns the preference of the given SRV target of the given name, zero
+// by default. A target shared by several tasks gets the best priority of
+// theirs, being as available as the most available of them, and the sum of
+// their weights, taking their load together.
+func (sp srvPreferences) Get(name, target string) SRVPreference {
+	tasks := sp[name][target]
+	if len(tasks) == 0 {
+		return SRVPreference{}
+	}
+	pref := SRVPreference{Priority: math.MaxUint16}
+	weight := 0
+	for _, p := range tasks {
+		if p.Priority < pref.Priority {
+			pref.Priority = p.Priority
+		}
+		weight += int(p.Weight)
+	}
+	if weight > math.MaxUint16 {
+		weight = math.MaxUint16
+	}
+	pref.Weight = uint16(weight)
+	return pref
+}
+
+// equal returns true if sp and o give the same preferences to the same
+// targets.
+func (sp srvPreferences) equal(o srvPreferences) bool {
+	if len(sp) != len(o) {
+		return false
+	}
+	for name, targets := range sp {
+		other, ok := o[name]
+		if !ok || len(targets) != len(other) {
+			return false
+		}
+		for target := range targets {
+			if _, ok := other[target]; !ok || sp.Get(name, target) != o.Get(name, target) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 type rrsKind string
 
 const (
 	// A record types
 	A rrsKind = "A"
+	// AAAA record types
+	AAAA rrsKind = "AAAA"
 	// SRV record types
 	SRV = "SRV"
+	// PTR record types
+	PTR = "PTR"
+	// TXT record types
+	TXT = "TXT"
+	// CNAME record types
+	CNAME = "CNAME"
 )
 
 func (kind rrsKind) rrs(rg *RecordGenerator) rrs {
 	switch kind {
 	case A:
 		return rg.As
+	case AAAA:
+		return rg.AAAAs
 	case SRV:
 		return rg.SRVs
+	case PTR:
+		return rg.PTRs
+	case TXT:
+		return rg.TXTs
+	case CNAME:
+		return rg.CNAMEs
 	default:
 		return nil
 	}
@@ -76,11 +215,23 @@ func (kind rrsKind) rrs(rg *RecordGenerator) rrs {
 // RecordGenerator contains DNS records and methods to access and manipulate
 // them. TODO(kozyraki): Refactor when discovery id is available.
 type RecordGenerator struct {
-	As         rrs
-	SRVs       rrs
-	SlaveIPs   map[string]string
-	EnumData   EnumerationData
+	As        rrs
+	AAAAs     rrs
+	SRVs      rrs
+	PTRs      rrs
+	TXTs      rrs
+	CNAMEs    rrs
+	HostPorts hostPorts
+	SlaveIPs  map[string]string
+	EnumData  EnumerationData
+	// SRVPreferences are the priorities and weights of the SRV records, by
+	// name and target
+	SRVPreferences srvPreferences
+	// Views are the records of the Views with their own, by view name
+	Views      map[string]*RecordGenerator
 	httpClient http.Client
+	scheme     string
+	static     *StaticRecords
 }
 
 // EnumerableRecord is the lowest level object, and should map 1:1 with DNS records
@@ -107,35 +258,154 @@ type EnumerableFramework struct {
 // enumerable frameworks containing enumerable tasks
 type EnumerationData struct {
 	Frameworks []*EnumerableFramework `json:"frameworks"`
+	// Static are the static records merged into the generated ones
+	Static []EnumerableRecord `json:"static,omitempty"`
+	// Conflicts are the task aliases left out for conflicting with other names
+	Conflicts []EnumerableConflict `json:"conflicts,omitempty"`
+}
+
+// EnumerableConflict is a task alias left out for conflicting with the
+// records of its name
+type EnumerableConflict struct {
+	Name   string `json:"name"`
+	Host   string `json:"host"`
+	TaskID string `json:"task_id"`
+	Reason string `json:"reason"`
+}
+
+// An Option configures a RecordGenerator.
+type Option func(*RecordGenerator)
+
+// WithTransport returns an Option setting the HTTP RoundTripper fetching the
+// state of the Mesos masters, e.g. as returned by NewTransport.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(rg *RecordGenerator) {
+		rg.httpClient.Transport = rt
+		if t, ok := rt.(*authTransport); ok {
+			rg.httpClient.CheckRedirect = t.checkRedirect
+		}
+	}
+}
+
+// WithHTTPS returns an Option fetching the state of the Mesos masters over
+// HTTPS rather than HTTP if on is set.
+func WithHTTPS(on bool) Option {
+	return func(rg *RecordGenerator) {
+		if on {
+			rg.scheme = "https"
+		} else {
+			rg.scheme = "http"
+		}
+	}
+}
+
+// WithStaticRecords returns an Option merging the given static records into
+// the records generated by InsertState. A nil StaticRecords has no records.
+func WithStaticRecords(s *StaticRecords) Option {
+	return func(rg *RecordGenerator) { rg.static = s }
 }
 
 // NewRecordGenerator returns a RecordGenerator that's been configured with a timeout.
-func NewRecordGenerator(httpTimeout time.Duration) *RecordGenerator {
-	rg := &RecordGenerator{httpClient: http.Client{Timeout: httpTimeout}}
+func NewRecordGenerator(httpTimeout time.Duration, opts ...Option) *RecordGenerator {
+	rg := &RecordGenerator{httpClient: http.Client{Timeout: httpTimeout}, scheme: "http"}
+	for _, opt := range opts {
+		opt(rg)
+	}
 	return rg
 }
 
+// Changed returns true if the records served for the Mesos domain differ
+// between rg and other, i.e. if any of their A, AAAA, CNAME, SRV or TXT
+// records, or SRV preferences, do.
+func (rg *RecordGenerator) Changed(other *RecordGenerator) bool {
+	for _, kind := range []rrsKind{A, AAAA, CNAME, SRV, TXT} {
+		if !kind.rrs(rg).equal(kind.rrs(other)) {
+			return true
+		}
+	}
+	return !rg.SRVPreferences.equal(other.SRVPreferences)
+}
+
+// Ports returns the ports allocated to the tasks the given host name resolves
+// to, sorted by port number.
+func (rg *RecordGenerator) Ports(host string) []HostPort {
+	ports := make([]HostPort, 0, len(rg.HostPorts[host]))
+	for port := range rg.HostPorts[host] {
+		ports = append(ports, port)
+	}
+	sort.Sort(byPort(ports))
+	return ports
+}
+
+type byPort []HostPort
+
+func (ps byPort) Len() int      { return len(ps) }
+func (ps byPort) Swap(i, j int) { ps[i], ps[j] = ps[j], ps[i] }
+func (ps byPort) Less(i, j int) bool {
+	a, b := ps[i], ps[j]
+	switch {
+	case a.Port != b.Port:
+		return a.Port < b.Port
+	case a.Protocol != b.Protocol:
+		return a.Protocol < b.Protocol
+	case a.Source != b.Source:
+		return a.Source < b.Source
+	case a.TaskID != b.TaskID:
+		return a.TaskID < b.TaskID
+	}
+	return a.Name < b.Name
+}
+
+// RecordCounts returns the number of records held by rg, by kind.
+func (rg *RecordGenerator) RecordCounts() map[string]int {
+	counts := map[string]int{}
+	for _, kind := range []rrsKind{A, AAAA, CNAME, SRV, PTR, TXT} {
+		n := 0
+		for _, hosts := range kind.rrs(rg) {
+			n += len(hosts)
+		}
+		counts[string(kind)] = n
+	}
+	return counts
+}
+
 // ParseState retrieves and parses the Mesos master /state.json and converts it
 // into DNS records.
 func (rg *RecordGenerator) ParseState(c Config, masters ...string) error {
 	// find master -- return if error
 	sj, err := rg.findMaster(masters...)
 	if err != nil {
-		logging.Error.Println("no master")
+		logger.Error("no master found", "error", err)
 		return err
 	}
 	if sj.Leader == "" {
-		logging.Error.Println("Unexpected error")
+		logger.Error("empty master leader")
 		err = errors.New("empty master")
 		return err
 	}
 
+	return rg.ConvertState(sj, c, masters...)
+}
+
+// ConvertState converts the given state into DNS records as configured by
+// c, with masters as in ParseState.
+func (rg *RecordGenerator) ConvertState(sj state.State, c Config, masters ...string) error {
 	hostSpec := labels.RFC1123
 	if c.EnforceRFC952 {
 		hostSpec = labels.RFC952
 	}
 
-	return rg.InsertState(sj, c.Domain, c.SOARname, c.Listener, masters, c.IPSources, hostSpec)
+	if err := rg.InsertState(sj, c.Domain, c.SOARname, c.Listener, masters, c.IPSources, c.TXTLabels, hostSpec); err != nil {
+		return err
+	}
+
+	rg.Views = make(map[string]*RecordGenerator, len(c.Views))
+	for _, v := range c.Views {
+		if vrg := rg.viewRecords(sj, c, v, masters, hostSpec); vrg != nil {
+			rg.Views[v.Name] = vrg
+		}
+	}
+	return nil
 }
 
 // Tries each master and looks for the leader
@@ -150,31 +420,31 @@ func (rg *RecordGenerator) findMaster(masters ...string) (state.State, error) {
 
 	// Check if ZK leader is correct
 	if leader != "" {
-		logging.VeryVerbose.Println("Zookeeper says the leader is: ", leader)
+		logger.Debug("leader detected by Zookeeper", "leader", leader)
 		ip, port, err := getProto(leader)
 		if err != nil {
-			logging.Error.Println(err)
+			logger.Error("invalid leader", "leader", leader, "error", err)
 		}
 
 		if sj, err = rg.loadWrap(ip, port); err == nil && sj.Leader != "" {
 			return sj, nil
 		}
-		logging.Verbose.Println("Warning: Zookeeper is wrong about leader")
+		logger.Warn("Zookeeper is wrong about the leader", "leader", leader)
 		if len(masters) == 0 {
 			return sj, errors.New("no master")
 		}
-		logging.Verbose.Println("Warning: falling back to Masters config field: ", masters)
+		logger.Warn("falling back to the Masters config field", "masters", masters)
 	}
 
 	// try each listed mesos master before dying
 	for i, master := range masters {
 		ip, port, err := getProto(master)
 		if err != nil {
-			logging.Error.Println(err)
+			logger.Error("invalid master", "master", master, "error", err)
 		}
 
 		if sj, err = rg.loadWrap(ip, port); err == nil && sj.Leader == "" {
-			logging.VeryVerbose.Println("Warning: not a leader - trying next one")
+			logger.Debug("not the leader, trying the next master", "master", master)
 			if len(masters)-1 == i {
 				return sj, errors.New("no master")
 			}
@@ -187,45 +457,82 @@ func (rg *RecordGenerator) findMaster(masters ...string) (state.State, error) {
 	return sj, errors.New("no master")
 }
 
-// Loads state.json from mesos master
-func (rg *RecordGenerator) loadFromMaster(ip string, port string) (state.State, error) {
-	// REFACTOR: state.json security
-
-	var sj state.State
-	u := url.URL{
-		Scheme: "http",
-		Host:   net.JoinHostPort(ip, port),
-		Path:   "/master/state.json",
-	}
+// statePaths are the paths of the state endpoint of Mesos masters, tried in
+// turn until one is served: the v1 one, its alias, and the deprecated one.
+var statePaths = []string{"/master/state", "/state", "/master/state.json"}
 
-	req, err := http.NewRequest("GET", u.String(), nil)
+// Loads the state from mesos master, gzipped if it supports it
+func (rg *RecordGenerator) loadFromMaster(ip string, port string) (state.State, error) {
+	start := time.Now()
+	resp, err := rg.fetchState(net.JoinHostPort(ip, port))
 	if err != nil {
-		logging.Error.Println(err)
+		logger.Error("failed to fetch the state", "master", ip, "error", err)
+		logging.StateFetchFailures.Inc()
 		return state.State{}, err
 	}
+	defer errorutil.Ignore(resp.Body.Close)
 
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := rg.httpClient.Do(req)
-	if err != nil {
-		logging.Error.Println(err)
-		return state.State{}, err
+	body := &countingReader{r: resp.Body}
+	var r io.Reader = body
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		if r, err = gzip.NewReader(body); err != nil {
+			logger.Error("failed to decompress the state", "master", ip, "error", err)
+			logging.StateFetchFailures.Inc()
+			return state.State{}, err
+		}
 	}
 
-	defer errorutil.Ignore(resp.Body.Close)
-	body, err := ioutil.ReadAll(resp.Body)
+	sj, err := state.Decode(r)
 	if err != nil {
-		logging.Error.Println(err)
+		logger.Error("failed to decode the state", "master", ip, "error", err)
+		logging.StateFetchFailures.Inc()
 		return state.State{}, err
 	}
+	logging.StateFetchDuration.Observe(time.Since(start).Seconds())
+	logging.StateFetchBytes.Set(float64(body.n))
 
-	err = json.Unmarshal(body, &sj)
-	if err != nil {
-		logging.Error.Println(err)
-		return state.State{}, err
+	return sj, nil
+}
+
+// fetchState requests the state of the given master from the first of
+// statePaths it serves.
+func (rg *RecordGenerator) fetchState(host string) (*http.Response, error) {
+	for i := 0; ; i++ {
+		u := url.URL{Scheme: rg.scheme, Host: host, Path: statePaths[i]}
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		resp, err := rg.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusNotFound && i < len(statePaths)-1 {
+			errorutil.Ignore(resp.Body.Close)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			errorutil.Ignore(resp.Body.Close)
+			return nil, fmt.Errorf("unexpected status %s fetching the state of master %s", resp.Status, host)
+		}
+		logger.Debug("fetching the state", "master", host, "path", u.Path)
+		return resp, nil
 	}
+}
 
-	return sj, nil
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
 }
 
 // Catches an attempt to load state.json from a mesos master
@@ -236,13 +543,13 @@ func (rg *RecordGenerator) loadWrap(ip string, port string) (state.State, error)
 	var err error
 	var sj state.State
 
-	logging.VeryVerbose.Println("reloading from master " + ip)
+	logger.Debug("reloading from master", "master", ip)
 	sj, err = rg.loadFromMaster(ip, port)
 	if err != nil {
 		return state.State{}, err
 	}
 	if rip := leaderIP(sj.Leader); rip != ip {
-		logging.VeryVerbose.Println("Warning: master changed to " + ip)
+		logger.Debug("master changed", "master", rip)
 		sj, err = rg.loadFromMaster(rip, port)
 		return sj, err
 	}
@@ -259,33 +566,63 @@ func hashString(s string) string {
 	return zbase32.EncodeToString(hash[:])[:5]
 }
 
-// attempt to translate the hostname into an IPv4 address. logs an error if IP
-// lookup fails. if an IP address cannot be found, returns the same hostname
-// that was given. upon success returns the IP address as a string.
-func hostToIP4(hostname string) (string, bool) {
-	ip := net.ParseIP(hostname)
-	if ip == nil {
-		t, err := net.ResolveIPAddr("ip4", hostname)
-		if err != nil {
-			logging.Error.Printf("cannot translate hostname %q into an ip4 address", hostname)
-			return hostname, false
+// attempt to translate the hostname into its first IPv4 and its first IPv6
+// addresses, in that order. logs an error if IP lookup fails. if no IP address
+// can be found, returns the same hostname that was given.
+func hostToIPs(hostname string) ([]string, bool) {
+	if ip := net.ParseIP(hostname); ip != nil {
+		return []string{ip.String()}, true
+	}
+	addrs, err := net.LookupIP(hostname)
+	if err != nil || len(addrs) == 0 {
+		logger.Error("cannot translate hostname into an ip address", "hostname", hostname, "error", err)
+		return []string{hostname}, false
+	}
+	return firstIPs(addrs), true
+}
+
+// firstIPs returns the first IPv4 and the first IPv6 address of ips, in that
+// order.
+func firstIPs(ips []net.IP) []string {
+	var ip4, ip6 string
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			if ip4 == "" {
+				ip4 = ip.String()
+			}
+		} else if ip6 == "" {
+			ip6 = ip.String()
 		}
-		ip = t.IP
 	}
-	return ip.String(), true
+	var first []string
+	for _, ip := range []string{ip4, ip6} {
+		if ip != "" {
+			first = append(first, ip)
+		}
+	}
+	return first
 }
 
 // InsertState transforms a StateJSON into RecordGenerator RRs
-func (rg *RecordGenerator) InsertState(sj state.State, domain, ns, listener string, masters, ipSources []string, spec labels.Func) error {
+func (rg *RecordGenerator) InsertState(sj state.State, domain, ns, listener string, masters, ipSources, txtLabels []string, spec labels.Func) error {
 
 	rg.SlaveIPs = map[string]string{}
 	rg.SRVs = rrs{}
 	rg.As = rrs{}
+	rg.AAAAs = rrs{}
+	rg.PTRs = rrs{}
+	rg.TXTs = rrs{}
+	rg.CNAMEs = rrs{}
+	rg.HostPorts = hostPorts{}
+	rg.SRVPreferences = srvPreferences{}
 	rg.frameworkRecords(sj, domain, spec)
 	rg.slaveRecords(sj, domain, spec)
 	rg.listenerRecord(listener, ns)
 	rg.masterRecord(domain, masters, sj.Leader)
-	rg.taskRecords(sj, domain, spec, ipSources)
+	rg.taskRecords(sj, domain, spec, ipSources, txtLabels)
+	if rg.static != nil {
+		rg.insertStatic(rg.static)
+	}
 
 	return nil
 }
@@ -297,9 +634,11 @@ func (rg *RecordGenerator) frameworkRecords(sj state.State, domain string, spec
 	for _, f := range sj.Frameworks {
 		fname := labels.DomainFrag(f.Name, labels.Sep, spec)
 		host, port := f.HostPort()
-		if address, ok := hostToIP4(host); ok {
+		if addresses, ok := hostToIPs(host); ok {
 			a := fname + "." + domain + "."
-			rg.insertRR(a, address, A)
+			for _, address := range addresses {
+				rg.insertRR(a, address, ipKind(address))
+			}
 			if port != "" {
 				srvAddress := net.JoinHostPort(a, port)
 				rg.insertRR("_framework._tcp."+a, srvAddress, SRV)
@@ -308,28 +647,32 @@ func (rg *RecordGenerator) frameworkRecords(sj state.State, domain string, spec
 	}
 }
 
-// slaveRecords injects A and SRV records into the generator store:
+// slaveRecords injects A, AAAA, SRV and PTR records into the generator store:
 //     slave.domain.      // resolves to IPs of all slaves
 //     _slave._tc.domain. // resolves to the driver port and IP of all slaves
 func (rg *RecordGenerator) slaveRecords(sj state.State, domain string, spec labels.Func) {
 	for _, slave := range sj.Slaves {
-		address, ok := hostToIP4(slave.PID.Host)
+		addresses, ok := hostToIPs(slave.PID.Host)
+		address := addresses[0]
 		if ok {
 			a := "slave." + domain + "."
-			rg.insertRR(a, address, A)
+			for _, address := range addresses {
+				rg.insertRR(a, address, ipKind(address))
+				rg.insertPTR(address, a)
+			}
 			srv := net.JoinHostPort(a, slave.PID.Port)
 			rg.insertRR("_slave._tcp."+domain+".", srv, SRV)
 		} else {
-			logging.VeryVerbose.Printf("string '%q' for slave with id %q is not a valid IP address", address, slave.ID)
+			logger.Debug("invalid slave IP address", "address", address, "slave", slave.ID)
 			address = labels.DomainFrag(address, labels.Sep, spec)
 		}
 		rg.SlaveIPs[slave.ID] = address
 	}
 }
 
-// masterRecord injects A and SRV records into the generator store:
+// masterRecord injects A, AAAA, SRV and PTR records into the generator store:
 //     master.domain.  // resolves to IPs of all masters
-//     masterN.domain. // one IP address for each master
+//     masterN.domain. // one IP address for each master, also its PTR target
 //     leader.domain.  // one IP address for the leading master
 //
 // The current func implementation makes an assumption about the order of masters:
@@ -358,19 +701,19 @@ func (rg *RecordGenerator) masterRecord(domain string, masters []string, leader
 	// A records
 	h := strings.Split(leader, "@")
 	if len(h) < 2 {
-		logging.Error.Println(leader)
+		logger.Error("invalid leader", "leader", leader)
 		return // avoid a panic later
 	}
 	leaderAddress := h[1]
 	ip, port, err := getProto(leaderAddress)
 	if err != nil {
-		logging.Error.Println(err)
+		logger.Error("invalid leader", "leader", leader, "error", err)
 		return
 	}
 	arec := "leader." + domain + "."
-	rg.insertRR(arec, ip, A)
+	rg.insertRR(arec, ip, ipKind(ip))
 	arec = "master." + domain + "."
-	rg.insertRR(arec, ip, A)
+	rg.insertRR(arec, ip, ipKind(ip))
 
 	// SRV records
 	tcp := "_leader._tcp." + domain + "."
@@ -385,14 +728,14 @@ func (rg *RecordGenerator) masterRecord(domain string, masters []string, leader
 	for _, master := range masters {
 		masterIP, _, err := getProto(master)
 		if err != nil {
-			logging.Error.Println(err)
+			logger.Error("invalid master", "master", master, "error", err)
 			continue
 		}
 
-		// A records (master and masterN)
+		// A/AAAA records (master and masterN)
 		if master != leaderAddress {
 			arec := "master." + domain + "."
-			added := rg.insertRR(arec, masterIP, A)
+			added := rg.insertRR(arec, masterIP, ipKind(masterIP))
 			if !added {
 				// duplicate master?!
 				continue
@@ -405,7 +748,8 @@ func (rg *RecordGenerator) masterRecord(domain string, masters []string, leader
 		}
 
 		arec := "master" + strconv.Itoa(idx) + "." + domain + "."
-		rg.insertRR(arec, masterIP, A)
+		rg.insertRR(arec, masterIP, ipKind(masterIP))
+		rg.insertPTR(masterIP, arec)
 		idx++
 
 		if master == leaderAddress {
@@ -416,25 +760,27 @@ func (rg *RecordGenerator) masterRecord(domain string, masters []string, leader
 	if !addedLeaderMasterN {
 		// only a flake if there were fallback masters configured
 		if len(masters) > 0 {
-			logging.Error.Printf("warning: leader %q is not in master list", leader)
+			logger.Warn("leader not in the master list", "leader", leader)
 		}
 		arec = "master" + strconv.Itoa(idx) + "." + domain + "."
-		rg.insertRR(arec, ip, A)
+		rg.insertRR(arec, ip, ipKind(ip))
+		rg.insertPTR(ip, arec)
 	}
 }
 
-// A record for mesos-dns (the name is listed in SOA replies)
+// A or AAAA record for mesos-dns (the name is listed in SOA replies)
 func (rg *RecordGenerator) listenerRecord(listener string, ns string) {
-	if listener == "0.0.0.0" {
+	if listener == "0.0.0.0" || listener == "::" {
 		rg.setFromLocal(listener, ns)
 	} else if listener == "127.0.0.1" {
 		rg.insertRR(ns, "127.0.0.1", A)
 	} else {
-		rg.insertRR(ns, listener, A)
+		rg.insertRR(ns, listener, ipKind(listener))
 	}
 }
 
-func (rg *RecordGenerator) taskRecords(sj state.State, domain string, spec labels.Func, ipSources []string) {
+func (rg *RecordGenerator) taskRecords(sj state.State, domain string, spec labels.Func, ipSources, txtLabels []string) {
+	var aliases []taskAlias
 	for _, f := range sj.Frameworks {
 		enumerableFramework := &EnumerableFramework{Name: f.Name}
 		rg.EnumData.Frameworks = append(rg.EnumData.Frameworks, enumerableFramework)
@@ -445,9 +791,120 @@ func (rg *RecordGenerator) taskRecords(sj state.State, domain string, spec label
 
 			// only do running and discoverable tasks
 			if ok && (task.State == "TASK_RUNNING") {
-				rg.taskRecord(task, f, domain, spec, ipSources, enumerableFramework)
+				rg.taskRecord(task, f, domain, spec, ipSources, txtLabels, enumerableFramework)
+				enumTask := enumerableFramework.Tasks[len(enumerableFramework.Tasks)-1]
+				if a, ok := newTaskAlias(task, f, domain, spec, enumTask); ok {
+					aliases = append(aliases, a)
+				}
+			}
+		}
+	}
+	// aliases come last so that they never shadow the names of other tasks
+	rg.aliasRecords(aliases)
+}
+
+// SRVPriorityLabel and SRVWeightLabel are the keys of the task labels, or of
+// the DiscoveryInfo labels which take precedence, setting the priority and
+// the weight of the SRV records of the task. Tasks failing their health checks
+// get the lowest priority regardless.
+const (
+	SRVPriorityLabel = "MESOS_DNS_SRV_PRIORITY"
+	SRVWeightLabel   = "MESOS_DNS_SRV_WEIGHT"
+)
+
+// taskSRVPreference returns the preference of the SRV records of the given
+// task.
+func taskSRVPreference(task state.Task) SRVPreference {
+	var pref SRVPreference
+	for _, ls := range [][]state.Label{task.Labels, task.DiscoveryInfo.Labels.Labels} {
+		for _, l := range ls {
+			var field *uint16
+			switch l.Key {
+			case SRVPriorityLabel:
+				field = &pref.Priority
+			case SRVWeightLabel:
+				field = &pref.Weight
+			default:
+				continue
+			}
+			n, err := strconv.ParseUint(l.Value, 10, 16)
+			if err != nil {
+				logger.Debug("skipping invalid SRV preference label", "key", l.Key, "value", l.Value, "task", task.ID)
+				continue
+			}
+			*field = uint16(n)
+		}
+	}
+	if task.Unhealthy() {
+		pref.Priority = math.MaxUint16
+	}
+	return pref
+}
+
+// AliasLabel is the key of the task label defining an alias of the task in
+// the Mesos domain, i.e. a CNAME record pointing at its A records. The value
+// of the label is sanitized as a domain fragment, so that for instance
+// MESOS_DNS_ALIAS=api.payments makes api.payments.mesos an alias of the task.
+const AliasLabel = "MESOS_DNS_ALIAS"
+
+// taskAlias is the CNAME record of a task alias.
+type taskAlias struct {
+	name, target string
+	enumTask     *EnumerableTask
+}
+
+// newTaskAlias returns the alias of the given task, if it has an AliasLabel,
+// pointing at its taskname.framework.domain. record.
+func newTaskAlias(task state.Task, f state.Framework, domain string, spec labels.Func, enumTask *EnumerableTask) (taskAlias, bool) {
+	var alias string
+	for _, l := range task.Labels {
+		if l.Key == AliasLabel {
+			alias = labels.DomainFrag(l.Value, labels.Sep, spec)
+			break
+		}
+	}
+	if alias == "" {
+		return taskAlias{}, false
+	}
+
+	name := task.Name
+	if task.HasDiscoveryInfo() {
+		name = task.DiscoveryInfo.Name
+	}
+	tail := "." + domain + "."
+	return taskAlias{
+		name:     alias + tail,
+		target:   spec(name) + "." + labels.DomainFrag(f.Name, labels.Sep, spec) + tail,
+		enumTask: enumTask,
+	}, true
+}
+
+// aliasRecords inserts the CNAME records of the given task aliases, in order,
+// except for those whose name already has other records: they're enumerated
+// as conflicts instead.
+func (rg *RecordGenerator) aliasRecords(aliases []taskAlias) {
+	for _, a := range aliases {
+		reason := ""
+		if target, ok := rg.CNAMEs.First(a.name); ok && target != a.target {
+			reason = "already an alias of " + target
+		}
+		for _, kind := range []rrsKind{A, AAAA, SRV, TXT} {
+			if len(kind.rrs(rg)[a.name]) > 0 {
+				reason = "already has generated " + string(kind) + " records"
+				break
 			}
 		}
+		if reason != "" {
+			logger.Debug("skipping alias", "name", a.name, "task", a.enumTask.ID, "reason", reason)
+			rg.EnumData.Conflicts = append(rg.EnumData.Conflicts, EnumerableConflict{
+				Name:   a.name,
+				Host:   a.target,
+				TaskID: a.enumTask.ID,
+				Reason: reason,
+			})
+			continue
+		}
+		rg.insertTaskRR(a.name, a.target, CNAME, a.enumTask)
 	}
 }
 
@@ -455,11 +912,12 @@ type context struct {
 	taskName,
 	taskID,
 	slaveID,
-	taskIP,
 	slaveIP string
+	taskIPs,
+	txts []string
 }
 
-func (rg *RecordGenerator) taskRecord(task state.Task, f state.Framework, domain string, spec labels.Func, ipSources []string, enumFW *EnumerableFramework) {
+func (rg *RecordGenerator) taskRecord(task state.Task, f state.Framework, domain string, spec labels.Func, ipSources, txtLabels []string, enumFW *EnumerableFramework) {
 
 	newTask := &EnumerableTask{ID: task.ID, Name: task.Name}
 
@@ -470,8 +928,9 @@ func (rg *RecordGenerator) taskRecord(task state.Task, f state.Framework, domain
 		spec(task.Name),
 		hashString(task.ID),
 		slaveIDTail(task.SlaveID),
-		task.IP(ipSources...),
 		task.SlaveIP,
+		taskIPs(task, ipSources),
+		taskTXTs(task, txtLabels),
 	}
 
 	// use DiscoveryInfo name if defined instead of task name
@@ -497,21 +956,42 @@ func (rg *RecordGenerator) taskContextRecord(ctx context, task state.Task, f sta
 	canonical := ctx.taskName + "-" + ctx.taskID + "-" + ctx.slaveID + "." + fname
 	arec := ctx.taskName + "." + fname
 
-	rg.insertTaskRR(arec+tail, ctx.taskIP, A, enumTask)
-	rg.insertTaskRR(canonical+tail, ctx.taskIP, A, enumTask)
+	for _, ip := range ctx.taskIPs {
+		rg.insertTaskRR(arec+tail, ip, ipKind(ip), enumTask)
+		rg.insertTaskRR(canonical+tail, ip, ipKind(ip), enumTask)
+		// the slave's own PTR record already covers tasks using its IP, and
+		// legacy (unsanitized) task names make for invalid PTR targets
+		if ip != ctx.slaveIP && ctx.taskName == spec(ctx.taskName) {
+			rg.insertPTR(ip, canonical+tail)
+		}
+	}
+
+	rg.insertTaskRR(arec+".slave"+tail, ctx.slaveIP, ipKind(ctx.slaveIP), enumTask)
+	rg.insertTaskRR(canonical+".slave"+tail, ctx.slaveIP, ipKind(ctx.slaveIP), enumTask)
 
-	rg.insertTaskRR(arec+".slave"+tail, ctx.slaveIP, A, enumTask)
-	rg.insertTaskRR(canonical+".slave"+tail, ctx.slaveIP, A, enumTask)
+	// TXT strings differ between the tasks of an app, e.g. a canary's, so
+	// they're only published under the name of each task
+	for _, txt := range ctx.txts {
+		rg.insertTaskRR(canonical+tail, txt, TXT, enumTask)
+	}
+
+	hosts := []string{arec + tail, canonical + tail, arec + ".slave" + tail, canonical + ".slave" + tail}
+	rg.insertHostPorts(hosts, task)
 
 	// recordName generates records for ctx.taskName, given some generation chain
 	recordName := func(gen chain) { gen("_" + ctx.taskName) }
 
 	// asSRV is always the last link in a chain, it must insert RR's
+	pref := taskSRVPreference(task)
 	asSRV := func(target string) chain {
 		return func(records ...string) {
 			for i := range records {
 				name := records[i] + tail
 				rg.insertTaskRR(name, target, SRV, enumTask)
+				// the target may be shared with other tasks
+				if _, ok := rg.SRVs[name][target]; ok {
+					rg.SRVPreferences.add(name, target, task.ID, pref)
+				}
 			}
 		}
 	}
@@ -542,14 +1022,69 @@ func (rg *RecordGenerator) taskContextRecord(ctx context, task state.Task, f sta
 	}
 }
 
-// A records for each local interface
+// taskIPs returns the first IPv4 and the first IPv6 address of the first of
+// the given prioritized task IP sources with any address, so that an IPv6
+// only container doesn't get the IPv4 address of its agent, say.
+func taskIPs(task state.Task, ipSources []string) []string {
+	for _, src := range ipSources {
+		if ips := task.IPs(src); len(ips) > 0 {
+			return firstIPs(ips)
+		}
+	}
+	return nil
+}
+
+// maxTXTLen is the maximum length of a single TXT record string.
+const maxTXTLen = 255
+
+// taskTXTs returns the "key=value" strings published in a task's TXT records:
+// its DiscoveryInfo version, environment, location and labels followed by the
+// task labels whose keys are in the given list.
+func taskTXTs(task state.Task, txtLabels []string) []string {
+	var txts []string
+	add := func(key, value string) {
+		txt := key + "=" + value
+		if len(txt) > maxTXTLen {
+			logger.Debug("skipping TXT record longer than the maximum", "key", key, "task", task.ID, "max", maxTXTLen)
+			return
+		}
+		txts = append(txts, txt)
+	}
+
+	di := task.DiscoveryInfo
+	for _, kv := range [][2]string{
+		{"version", di.Version},
+		{"environment", di.Environment},
+		{"location", di.Location},
+	} {
+		if kv[1] != "" {
+			add(kv[0], kv[1])
+		}
+	}
+	for _, l := range di.Labels.Labels {
+		if l.Key != "" {
+			add(l.Key, l.Value)
+		}
+	}
+
+	for _, key := range txtLabels {
+		for _, l := range task.Labels {
+			if l.Key == key {
+				add(l.Key, l.Value)
+			}
+		}
+	}
+	return txts
+}
+
+// A and AAAA records for each local interface
 // If this causes problems you should explicitly set the
 // listener address in config.json
 func (rg *RecordGenerator) setFromLocal(host string, ns string) {
 
 	ifaces, err := net.Interfaces()
 	if err != nil {
-		logging.Error.Println(err)
+		logger.Error("failed to list the interfaces", "error", err)
 	}
 
 	// handle err
@@ -557,7 +1092,7 @@ func (rg *RecordGenerator) setFromLocal(host string, ns string) {
 
 		addrs, err := i.Addrs()
 		if err != nil {
-			logging.Error.Println(err)
+			logger.Error("failed to list the interface addresses", "interface", i.Name, "error", err)
 		}
 
 		for _, addr := range addrs {
@@ -569,16 +1104,15 @@ func (rg *RecordGenerator) setFromLocal(host string, ns string) {
 				ip = v.IP
 			}
 
-			if ip == nil || ip.IsLoopback() {
+			if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
 				continue
 			}
 
-			ip = ip.To4()
-			if ip == nil {
-				continue
+			if ip4 := ip.To4(); ip4 != nil {
+				rg.insertRR(ns, ip4.String(), A)
+			} else {
+				rg.insertRR(ns, ip.String(), AAAA)
 			}
-
-			rg.insertRR(ns, ip.String(), A)
 		}
 	}
 }
@@ -595,20 +1129,71 @@ func (rg *RecordGenerator) insertTaskRR(name, host string, kind rrsKind, enumTas
 	return false
 }
 
+// insertPTR adds a PTR record pointing the reverse lookup name of the given
+// address (in-addr.arpa. or ip6.arpa.) at the given name. returns true if added,
+// false otherwise.
+func (rg *RecordGenerator) insertPTR(address, name string) bool {
+	arpa, err := dns.ReverseAddr(address)
+	if err != nil {
+		return false
+	}
+	return rg.insertRR(arpa, name, PTR)
+}
+
+// insertHostPorts indexes the resources and DiscoveryInfo ports of the given
+// task under each of the given host names.
+func (rg *RecordGenerator) insertHostPorts(hosts []string, task state.Task) {
+	var ports []HostPort
+	for _, port := range task.Ports() {
+		if p, err := strconv.Atoi(port); err == nil {
+			ports = append(ports, HostPort{Port: p, TaskID: task.ID, Source: PortSourceResources})
+		}
+	}
+	if task.HasDiscoveryInfo() {
+		for _, port := range task.DiscoveryInfo.Ports.DiscoveryPorts {
+			ports = append(ports, HostPort{
+				Port:     port.Number,
+				Protocol: strings.ToLower(port.Protocol),
+				Name:     port.Name,
+				TaskID:   task.ID,
+				Source:   PortSourceDiscovery,
+			})
+		}
+	}
+	for _, host := range hosts {
+		for _, port := range ports {
+			rg.HostPorts.add(host, port)
+		}
+	}
+}
+
 func (rg *RecordGenerator) insertRR(name, host string, kind rrsKind) (added bool) {
 	if rrs := kind.rrs(rg); rrs != nil {
 		if added = rrs.add(name, host); added {
-			logging.VeryVerbose.Println("[" + string(kind) + "]\t" + name + ": " + host)
+			logger.Debug("record added", "kind", string(kind), "name", name, "host", host)
 		}
 	}
 	return
 }
 
+// ipKind returns the record kind holding the given address: AAAA for IPv6
+// addresses and A for everything else.
+func ipKind(address string) rrsKind {
+	if ip := net.ParseIP(address); ip != nil && ip.To4() == nil {
+		return AAAA
+	}
+	return A
+}
+
 // leaderIP returns the ip for the mesos master
 // input format master@ip:port
 func leaderIP(leader string) string {
 	pair := strings.Split(leader, "@")[1]
-	return strings.Split(pair, ":")[0]
+	ip, _, err := getProto(pair)
+	if err != nil {
+		return pair
+	}
+	return ip
 }
 
 // return the slave number from a Mesos slave id
@@ -623,6 +1208,12 @@ func slaveIDTail(slaveID string) string {
 // zk://username:password@host1:port1,host2:port2,.../path
 // file:///path/to/file (where file contains one of the above)
 func getProto(pair string) (string, string, error) {
+	// bracketed IPv6 literals, i.e. [::1]:5050
+	if strings.HasPrefix(pair, "[") {
+		if host, port, err := net.SplitHostPort(pair); err == nil {
+			return host, port, nil
+		}
+	}
 	h := strings.SplitN(pair, ":", 2)
 	if len(h) != 2 {
 		return "", "", fmt.Errorf("unable to parse proto from %q", pair)
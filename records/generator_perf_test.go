@@ -87,6 +87,6 @@ func BenchmarkTaskRecord_withoutDiscoveryInfo(b *testing.B) {
 		tt.task.Name = tasks[ti]
 		tt.task.SlaveIP = slaves[si]
 		tt.task.SlaveID = "ID-" + slaves[si]
-		tt.rg.taskRecord(tt.task, tt.f, tt.domain, tt.spec, tt.ipSources, &tt.enumFW)
+		tt.rg.taskRecord(tt.task, tt.f, tt.domain, tt.spec, tt.ipSources, nil, &tt.enumFW)
 	}
 }
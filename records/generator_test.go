@@ -1,6 +1,7 @@
 package records
 
 import (
+	"compress/gzip"
 	"encoding/json"
 	"io/ioutil"
 	"math/rand"
@@ -9,6 +10,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"strconv"
 	"testing"
 	"testing/quick"
 	"time"
@@ -175,7 +177,59 @@ func TestLeaderIP(t *testing.T) {
 	}
 }
 
-func testRecordGenerator(t *testing.T, spec labels.Func, ipSources []string) RecordGenerator {
+func TestLeaderIP6(t *testing.T) {
+	l := "master@[2001:db8::1]:5050"
+
+	ip := leaderIP(l)
+
+	if ip != "2001:db8::1" {
+		t.Errorf("not parsing ipv6: %q", ip)
+	}
+}
+
+func TestIPKind(t *testing.T) {
+	for i, tt := range []struct {
+		address string
+		kind    rrsKind
+	}{
+		{"1.2.3.4", A},
+		{"::ffff:1.2.3.4", A},
+		{"fd01::1", AAAA},
+		{"bob", A},
+		{"", A},
+	} {
+		if got := ipKind(tt.address); got != tt.kind {
+			t.Errorf("test #%d: ipKind(%q): got %q, want %q", i, tt.address, got, tt.kind)
+		}
+	}
+}
+
+func TestFirstIPs(t *testing.T) {
+	for i, tt := range []struct {
+		ips  []string
+		want []string
+	}{
+		{nil, nil},
+		{[]string{"1.2.3.4", "1.2.3.5"}, []string{"1.2.3.4"}},
+		{[]string{"fd01::1", "1.2.3.4", "fd01::2"}, []string{"1.2.3.4", "fd01::1"}},
+		{[]string{"fd01::1"}, []string{"fd01::1"}},
+	} {
+		var ips []net.IP
+		for _, ip := range tt.ips {
+			ips = append(ips, net.ParseIP(ip))
+		}
+		if got := firstIPs(ips); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("test #%d: got %q, want %q", i, got, tt.want)
+		}
+	}
+
+	if got, ok := hostToIPs("fd01::1"); !ok || !reflect.DeepEqual(got, []string{"fd01::1"}) {
+		t.Errorf("got %q, %t, want the IPv6 address itself", got, ok)
+	}
+}
+
+// fakeState returns the state of factories/fake.json.
+func fakeState(t *testing.T) state.State {
 	var sj state.State
 
 	b, err := ioutil.ReadFile("../factories/fake.json")
@@ -184,12 +238,16 @@ func testRecordGenerator(t *testing.T, spec labels.Func, ipSources []string) Rec
 	} else if err = json.Unmarshal(b, &sj); err != nil {
 		t.Fatal(err)
 	}
+	return sj
+}
 
+func testRecordGenerator(t *testing.T, spec labels.Func, ipSources []string) RecordGenerator {
+	sj := fakeState(t)
 	sj.Leader = "master@144.76.157.37:5050"
 	masters := []string{"144.76.157.37:5050"}
 
 	var rg RecordGenerator
-	if err := rg.InsertState(sj, "mesos", "mesos-dns.mesos.", "127.0.0.1", masters, ipSources, spec); err != nil {
+	if err := rg.InsertState(sj, "mesos", "mesos-dns.mesos.", "127.0.0.1", masters, ipSources, []string{"owner"}, spec); err != nil {
 		t.Fatal(err)
 	}
 
@@ -221,6 +279,40 @@ func TestInsertState(t *testing.T) {
 		{rg.As, "slave.mesos.", []string{"1.2.3.10", "1.2.3.11", "1.2.3.12"}},
 		{rg.As, "some-box.chronoswithaspaceandmixe.mesos.", []string{"1.2.3.11"}}, // ensure we translate the framework name as well
 		{rg.As, "marathon.mesos.", []string{"1.2.3.11"}},
+		{rg.As, "toy-store.marathon.mesos.", nil},
+		{rg.AAAAs, "toy-store.marathon.mesos.", []string{"fd01:b::1:8"}},
+		{rg.AAAAs, "toy-store.marathon.slave.mesos.", nil},
+		{rg.AAAAs, "liquor-store.marathon.mesos.", nil},
+		// TXT strings differing between tasks, e.g. their canary label,
+		// aren't mixed up under the app name
+		{rg.TXTs, "liquor-store.marathon.mesos.", nil},
+		{rg.TXTs, "liquor-store-4dfjd-0.marathon.mesos.", []string{
+			"version=1.0",
+			"environment=prod",
+			"location=europe",
+			"dc=de1",
+			"canary=Teneriffa",
+		}},
+		{rg.TXTs, "liquor-store-zasmd-1.marathon.mesos.", []string{
+			"version=1.0",
+			"environment=prod",
+			"location=europe",
+			"dc=de1",
+			"canary=Lanzarote",
+		}},
+		{rg.TXTs, "toy-store.marathon.mesos.", nil},
+		{rg.TXTs, "toy-store-n96qe-0.marathon.mesos.", []string{"owner=toys"}},
+		{rg.TXTs, "car-store.marathon.mesos.", nil},
+		{rg.PTRs, "3.0.3.10.in-addr.arpa.", []string{"nginx-6ud99-0.marathon.mesos."}},
+		{rg.PTRs, "1.0.3.10.in-addr.arpa.", []string{
+			"big-dog-4dfjd-0.marathon.mesos.",
+			"liquor-store-4dfjd-0.marathon.mesos.",
+		}},
+		{rg.PTRs, "8.0.0.0.1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.b.0.0.0.1.0.d.f.ip6.arpa.", []string{
+			"toy-store-n96qe-0.marathon.mesos.",
+		}},
+		{rg.PTRs, "11.3.2.1.in-addr.arpa.", []string{"slave.mesos."}},
+		{rg.PTRs, "37.157.76.144.in-addr.arpa.", []string{"master0.mesos."}},
 		{rg.SRVs, "_big-dog._tcp.marathon.mesos.", []string{
 			"big-dog-4dfjd-0.marathon.mesos.:80",
 			"big-dog-4dfjd-0.marathon.mesos.:443",
@@ -258,6 +350,7 @@ func TestInsertState(t *testing.T) {
 		{rgSlave.As, "liquor-store.marathon.slave.mesos.", []string{"1.2.3.11", "1.2.3.12"}},
 		{rgSlave.As, "nginx.marathon.mesos.", []string{"1.2.3.11"}},
 		{rgSlave.As, "car-store.marathon.slave.mesos.", []string{"1.2.3.11"}},
+		{rgSlave.AAAAs, "toy-store.marathon.mesos.", nil},
 
 		{rgMesos.As, "liquor-store.marathon.mesos.", []string{"1.2.3.11", "1.2.3.12"}},
 		{rgMesos.As, "liquor-store.marathon.slave.mesos.", []string{"1.2.3.11", "1.2.3.12"}},
@@ -300,6 +393,187 @@ func TestNTasks(t *testing.T) {
 	}
 }
 
+func TestPorts(t *testing.T) {
+	rg := testRecordGenerator(t, labels.RFC952, []string{"docker", "mesos", "host"})
+
+	id := "liquor-store.b71166c1-562f-11e4-a088-c20493233aa5"
+	want := []HostPort{
+		{80, "tcp", "http", id, PortSourceDiscovery},
+		{443, "tcp", "https", id, PortSourceDiscovery},
+		{31737, "", "", id, PortSourceResources},
+		{31738, "", "", id, PortSourceResources},
+	}
+	for _, host := range []string{
+		"liquor-store-zasmd-1.marathon.mesos.",
+		"liquor-store-zasmd-1.marathon.slave.mesos.",
+	} {
+		if got := rg.Ports(host); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, want %+v", host, got, want)
+		}
+	}
+
+	if got := rg.Ports("missing.mesos."); len(got) != 0 {
+		t.Errorf("got %+v, want no ports", got)
+	}
+}
+
+func TestAliases(t *testing.T) {
+	sj := fakeState(t)
+	for _, alias := range []struct{ task, alias string }{
+		{"chronos", "Api.Payments"},
+		{"liquor.store", "liquor"},
+		{"non-human-readable-liquor-store", "liquor"},
+		{"big.dog2", "api.payments"},
+		{"nginx", "chronos.marathon"},
+		{"car.store", "_"},
+	} {
+		for _, f := range sj.Frameworks {
+			for i := range f.Tasks {
+				if f.Tasks[i].Name == alias.task {
+					f.Tasks[i].Labels = append(f.Tasks[i].Labels, state.Label{Key: AliasLabel, Value: alias.alias})
+				}
+			}
+		}
+	}
+	var rg RecordGenerator
+	if err := rg.InsertState(sj, "mesos", "mesos-dns.mesos.", "127.0.0.1", nil, []string{"docker", "mesos", "host"}, nil, labels.RFC952); err != nil {
+		t.Fatal(err)
+	}
+
+	want := rrs{
+		"api.payments.mesos.": {"big-dog.marathon.mesos.": {}},
+		"liquor.mesos.":       {"liquor-store.marathon.mesos.": {}},
+	}
+	if !reflect.DeepEqual(rg.CNAMEs, want) {
+		t.Errorf("got CNAME records %v, want %v", rg.CNAMEs, want)
+	}
+
+	var enumerated int
+	for _, f := range rg.EnumData.Frameworks {
+		for _, task := range f.Tasks {
+			for _, r := range task.Records {
+				if r.Rtype == string(CNAME) {
+					enumerated++
+				}
+			}
+		}
+	}
+	if enumerated != len(want) {
+		t.Errorf("got %d enumerated CNAME records, want %d", enumerated, len(want))
+	}
+
+	wantConflicts := []EnumerableConflict{
+		{"api.payments.mesos.", "chronos.marathon.mesos.", "chronos.49b91a9a-3dda-11e4-a088-c20493233aa5", "already an alias of big-dog.marathon.mesos."},
+		{"chronos.marathon.mesos.", "nginx.marathon.mesos.", "nginx.1bc32344-3dda-11e4-a088-c20493233aa5", "already has generated A records"},
+	}
+	if !reflect.DeepEqual(rg.EnumData.Conflicts, wantConflicts) {
+		t.Errorf("got conflicts %+v, want %+v", rg.EnumData.Conflicts, wantConflicts)
+	}
+}
+
+func TestSRVPreferences(t *testing.T) {
+	sj := fakeState(t)
+	unhealthy := false
+	for _, f := range sj.Frameworks {
+		for i := range f.Tasks {
+			task := &f.Tasks[i]
+			switch task.Name {
+			case "car.store":
+				task.Labels = append(task.Labels,
+					state.Label{Key: SRVPriorityLabel, Value: "10"},
+					state.Label{Key: SRVWeightLabel, Value: "5"})
+			case "big.dog2":
+				task.Labels = append(task.Labels, state.Label{Key: SRVWeightLabel, Value: "1"})
+				task.DiscoveryInfo.Labels.Labels = append(task.DiscoveryInfo.Labels.Labels,
+					state.Label{Key: SRVWeightLabel, Value: "2"})
+			case "reviewbot":
+				task.Labels = append(task.Labels, state.Label{Key: SRVWeightLabel, Value: "65536"})
+			case "chronos":
+				task.Labels = append(task.Labels, state.Label{Key: SRVPriorityLabel, Value: "1"})
+				task.Statuses[0].Healthy = &unhealthy
+			}
+		}
+	}
+	var rg RecordGenerator
+	if err := rg.InsertState(sj, "mesos", "mesos-dns.mesos.", "127.0.0.1", nil, []string{"host"}, nil, labels.RFC952); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tt := range []struct {
+		name string
+		want SRVPreference
+	}{
+		{"_car-store._tcp.marathon.mesos.", SRVPreference{Priority: 10, Weight: 5}},
+		{"_car-store._tcp.marathon.slave.mesos.", SRVPreference{Priority: 10, Weight: 5}},
+		{"_big-dog._tcp.marathon.mesos.", SRVPreference{Weight: 2}},
+		{"_reviewbot._tcp.marathon.mesos.", SRVPreference{}},
+		{"_chronos._tcp.marathon.mesos.", SRVPreference{Priority: 65535}},
+	} {
+		if len(rg.SRVs[tt.name]) == 0 {
+			t.Errorf("test #%d: no SRV records for %s", i, tt.name)
+		}
+		for target := range rg.SRVs[tt.name] {
+			if got := rg.SRVPreferences.Get(tt.name, target); got != tt.want {
+				t.Errorf("test #%d: got preference %+v for %s %s, want %+v", i, got, tt.name, target, tt.want)
+			}
+		}
+	}
+
+	same := rg
+	same.SRVPreferences = srvPreferences{}
+	if !rg.Changed(&same) {
+		t.Error("SRV preference changes should be detected")
+	}
+
+	// tasks sharing a target combine their preferences
+	for i, tt := range []struct {
+		prefs []SRVPreference
+		want  SRVPreference
+	}{
+		{[]SRVPreference{{10, 5}, {20, 3}}, SRVPreference{10, 8}},
+		{[]SRVPreference{{10, 5}, {}}, SRVPreference{0, 5}},
+		{[]SRVPreference{{65535, 0}, {1, 40000}, {2, 40000}}, SRVPreference{1, 65535}},
+	} {
+		sp := srvPreferences{}
+		for j, pref := range tt.prefs {
+			sp.add("_a._tcp.mesos.", "a.mesos.:80", strconv.Itoa(j), pref)
+		}
+		if got := sp.Get("_a._tcp.mesos.", "a.mesos.:80"); got != tt.want {
+			t.Errorf("test #%d: got preference %+v, want %+v", i, got, tt.want)
+		}
+	}
+}
+
+func TestChanged(t *testing.T) {
+	rg := testRecordGenerator(t, labels.RFC952, []string{"docker", "mesos", "host"})
+	same := testRecordGenerator(t, labels.RFC952, []string{"docker", "mesos", "host"})
+	other := testRecordGenerator(t, labels.RFC952, []string{"host"})
+
+	for i, tt := range []struct {
+		a, b *RecordGenerator
+		want bool
+	}{
+		{&RecordGenerator{}, &RecordGenerator{As: rrs{}}, false},
+		{&rg, &same, false},
+		{&rg, &other, true},
+		{&rg, &RecordGenerator{}, true},
+	} {
+		if got := tt.a.Changed(tt.b); got != tt.want {
+			t.Errorf("test #%d: got %t, want %t", i, got, tt.want)
+		}
+	}
+
+	// PTRs aren't part of the Mesos domain
+	same.PTRs = rrs{}
+	if rg.Changed(&same) {
+		t.Error("PTR changes should be ignored")
+	}
+	same.TXTs.add("foo.mesos.", "bar")
+	if !rg.Changed(&same) {
+		t.Error("TXT changes should be detected")
+	}
+}
+
 func TestHashString(t *testing.T) {
 	val := hashString("test")
 	if len(val) != 5 {
@@ -357,3 +631,37 @@ func TestTimeout(t *testing.T) {
 		t.Errorf("Did not receive a timeout, instead: %#v", err)
 	}
 }
+
+func TestLoadFromMaster(t *testing.T) {
+	var paths []string
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path != "/state" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			t.Errorf("got Accept-Encoding %q, want gzip", r.Header.Get("Accept-Encoding"))
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		_, _ = gz.Write([]byte(`{"leader":"master@10.0.0.1:5050","completed_frameworks":[{"tasks":[]}]}`))
+		_ = gz.Close()
+	}))
+	defer master.Close()
+
+	host, port, err := net.SplitHostPort(master.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sj, err := NewRecordGenerator(time.Second).loadFromMaster(host, port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sj.Leader != "master@10.0.0.1:5050" {
+		t.Errorf("got leader %q, want master@10.0.0.1:5050", sj.Leader)
+	}
+	if want := []string{"/master/state", "/state"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("got requests to %v, want %v", paths, want)
+	}
+}
@@ -0,0 +1,157 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Decode decodes the state served by the /master/state Mesos HTTP endpoint
+// from r, token by token. Unlike json.Unmarshal, it never holds the whole
+// state in memory: the fields State doesn't hold, e.g. the completed
+// frameworks and tasks, are skipped as they're read, and only a single task,
+// slave or skipped element is buffered at a time.
+func Decode(r io.Reader) (State, error) {
+	var s State
+	dec := json.NewDecoder(r)
+	err := decodeObject(dec, func(key string) error {
+		switch key {
+		case "frameworks":
+			s.Frameworks = []Framework{}
+			return decodeArray(dec, func() error {
+				var f Framework
+				if err := decodeFramework(dec, &f); err != nil {
+					return err
+				}
+				s.Frameworks = append(s.Frameworks, f)
+				return nil
+			})
+		case "slaves":
+			s.Slaves = []Slave{}
+			return decodeArray(dec, func() error {
+				var slave Slave
+				if err := dec.Decode(&slave); err != nil {
+					return err
+				}
+				s.Slaves = append(s.Slaves, slave)
+				return nil
+			})
+		case "leader":
+			return dec.Decode(&s.Leader)
+		default:
+			return skip(dec)
+		}
+	})
+	return s, err
+}
+
+func decodeFramework(dec *json.Decoder, f *Framework) error {
+	return decodeObject(dec, func(key string) error {
+		switch key {
+		case "tasks":
+			f.Tasks = []Task{}
+			return decodeArray(dec, func() error {
+				var t Task
+				if err := dec.Decode(&t); err != nil {
+					return err
+				}
+				f.Tasks = append(f.Tasks, t)
+				return nil
+			})
+		case "id":
+			return dec.Decode(&f.ID)
+		case "pid":
+			return dec.Decode(&f.PID)
+		case "name":
+			return dec.Decode(&f.Name)
+		case "hostname":
+			return dec.Decode(&f.Hostname)
+		default:
+			return skip(dec)
+		}
+	})
+}
+
+// decodeObject calls field with the key of each field of the next JSON
+// object of dec, which must then decode or skip its value. A null object
+// has no fields.
+func decodeObject(dec *json.Decoder, field func(key string) error) error {
+	if ok, err := begin(dec, '{'); !ok || err != nil {
+		return err
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if err = field(t.(string)); err != nil {
+			return err
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// decodeArray calls elem to decode each element of the next JSON array of
+// dec. A null array has no elements.
+func decodeArray(dec *json.Decoder, elem func() error) error {
+	if ok, err := begin(dec, '['); !ok || err != nil {
+		return err
+	}
+	for dec.More() {
+		if err := elem(); err != nil {
+			return err
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// begin reads the given opening delimiter from dec, returning false if it
+// reads null instead.
+func begin(dec *json.Decoder, delim json.Delim) (bool, error) {
+	t, err := dec.Token()
+	if err != nil || t == nil {
+		return false, err
+	}
+	if t != delim {
+		return false, fmt.Errorf("expected %v, got %v", delim, t)
+	}
+	return true, nil
+}
+
+// skip skips the next JSON value of dec. The elements of arrays and the
+// fields of objects, e.g. completed tasks, are buffered one at a time.
+func skip(dec *json.Decoder) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch t {
+	case json.Delim('['):
+		for dec.More() {
+			if err = dec.Decode(&ignored{}); err != nil {
+				return err
+			}
+		}
+	case json.Delim('{'):
+		for dec.More() {
+			if _, err = dec.Token(); err != nil {
+				return err
+			}
+			if err = dec.Decode(&ignored{}); err != nil {
+				return err
+			}
+		}
+	default:
+		return nil
+	}
+	_, err = dec.Token()
+	return err
+}
+
+// ignored is a JSON value decoded to nothing.
+type ignored struct{}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (*ignored) UnmarshalJSON([]byte) error { return nil }
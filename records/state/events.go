@@ -0,0 +1,328 @@
+package state
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mesos/mesos-go/upid"
+)
+
+// Event holds an event of the Mesos v1 operator API SUBSCRIBE stream.
+// See http://mesos.apache.org/documentation/latest/operator-http-api/#events
+type Event struct {
+	Type       string `json:"type"`
+	Subscribed *struct {
+		GetState                 v1State `json:"get_state"`
+		HeartbeatIntervalSeconds float64 `json:"heartbeat_interval_seconds"`
+	} `json:"subscribed,omitempty"`
+	TaskAdded *struct {
+		Task v1Task `json:"task"`
+	} `json:"task_added,omitempty"`
+	TaskUpdated *struct {
+		FrameworkID v1ID     `json:"framework_id"`
+		Status      v1Status `json:"status"`
+		State       string   `json:"state"`
+	} `json:"task_updated,omitempty"`
+	AgentAdded *struct {
+		Agent v1Agent `json:"agent"`
+	} `json:"agent_added,omitempty"`
+	AgentRemoved *struct {
+		AgentID v1ID `json:"agent_id"`
+	} `json:"agent_removed,omitempty"`
+	FrameworkAdded *struct {
+		Framework v1Framework `json:"framework"`
+	} `json:"framework_added,omitempty"`
+	FrameworkUpdated *struct {
+		Framework v1Framework `json:"framework"`
+	} `json:"framework_updated,omitempty"`
+	FrameworkRemoved *struct {
+		FrameworkInfo v1FrameworkInfo `json:"framework_info"`
+	} `json:"framework_removed,omitempty"`
+}
+
+// HeartbeatInterval returns the interval in seconds of the heartbeats of a
+// SUBSCRIBED event, or zero for any other event.
+func (e *Event) HeartbeatInterval() float64 {
+	if e.Subscribed == nil {
+		return 0
+	}
+	return e.Subscribed.HeartbeatIntervalSeconds
+}
+
+// Apply applies the event e to s, replacing its frameworks, slaves and tasks
+// with the snapshot of a SUBSCRIBED event, or updating them with any other
+// event. Events of unknown or irrelevant types, e.g. HEARTBEAT, are ignored.
+func (s *State) Apply(e Event) error {
+	switch e.Type {
+	case "SUBSCRIBED":
+		if e.Subscribed == nil {
+			return malformed(e)
+		}
+		s.snapshot(e.Subscribed.GetState)
+	case "TASK_ADDED":
+		if e.TaskAdded == nil {
+			return malformed(e)
+		}
+		t := e.TaskAdded.Task.task()
+		f := s.framework(t.FrameworkID)
+		f.Tasks = append(f.Tasks, t)
+	case "TASK_UPDATED":
+		if e.TaskUpdated == nil {
+			return malformed(e)
+		}
+		u := e.TaskUpdated
+		s.updateTask(u.FrameworkID.Value, u.Status.TaskID.Value, u.State, u.Status.status())
+	case "AGENT_ADDED":
+		if e.AgentAdded == nil {
+			return malformed(e)
+		}
+		slave := e.AgentAdded.Agent.slave()
+		s.removeSlave(slave.ID)
+		s.Slaves = append(s.Slaves, slave)
+	case "AGENT_REMOVED":
+		if e.AgentRemoved == nil {
+			return malformed(e)
+		}
+		s.removeSlave(e.AgentRemoved.AgentID.Value)
+	case "FRAMEWORK_ADDED", "FRAMEWORK_UPDATED":
+		fw := e.FrameworkAdded
+		if e.Type == "FRAMEWORK_UPDATED" {
+			fw = e.FrameworkUpdated
+		}
+		if fw == nil {
+			return malformed(e)
+		}
+		info := fw.Framework.FrameworkInfo
+		f := s.framework(info.ID.Value)
+		f.Name, f.Hostname = info.Name, info.Hostname
+	case "FRAMEWORK_REMOVED":
+		if e.FrameworkRemoved == nil {
+			return malformed(e)
+		}
+		s.removeFramework(e.FrameworkRemoved.FrameworkInfo.ID.Value)
+	}
+	return nil
+}
+
+func malformed(e Event) error {
+	return fmt.Errorf("malformed %s event", e.Type)
+}
+
+// snapshot replaces the frameworks and slaves of s with those of st.
+func (s *State) snapshot(st v1State) {
+	s.Frameworks, s.Slaves = nil, nil
+	for _, f := range st.GetFrameworks.Frameworks {
+		info := f.FrameworkInfo
+		s.Frameworks = append(s.Frameworks, Framework{
+			ID:       info.ID.Value,
+			Name:     info.Name,
+			Hostname: info.Hostname,
+		})
+	}
+	for _, a := range st.GetAgents.Agents {
+		s.Slaves = append(s.Slaves, a.slave())
+	}
+	for _, t := range st.GetTasks.Tasks {
+		t := t.task()
+		f := s.framework(t.FrameworkID)
+		f.Tasks = append(f.Tasks, t)
+	}
+}
+
+// framework returns the framework of s with the given ID, adding it if it's
+// unknown.
+func (s *State) framework(id string) *Framework {
+	for i := range s.Frameworks {
+		if s.Frameworks[i].ID == id {
+			return &s.Frameworks[i]
+		}
+	}
+	s.Frameworks = append(s.Frameworks, Framework{ID: id})
+	return &s.Frameworks[len(s.Frameworks)-1]
+}
+
+func (s *State) removeFramework(id string) {
+	fs := s.Frameworks[:0]
+	for _, f := range s.Frameworks {
+		if f.ID != id {
+			fs = append(fs, f)
+		}
+	}
+	s.Frameworks = fs
+}
+
+func (s *State) removeSlave(id string) {
+	slaves := s.Slaves[:0]
+	for _, slave := range s.Slaves {
+		if slave.ID != id {
+			slaves = append(slaves, slave)
+		}
+	}
+	s.Slaves = slaves
+}
+
+// updateTask sets the state of the given task and appends the given status
+// to it. Tasks reaching a terminal state are removed, as in the completed
+// tasks of /state.json.
+func (s *State) updateTask(frameworkID, taskID, state string, status Status) {
+	var f *Framework
+	for i := range s.Frameworks {
+		if s.Frameworks[i].ID == frameworkID {
+			f = &s.Frameworks[i]
+		}
+	}
+	if f == nil {
+		return
+	}
+	for i := range f.Tasks {
+		if f.Tasks[i].ID != taskID {
+			continue
+		}
+		if terminal[state] {
+			f.Tasks = append(f.Tasks[:i], f.Tasks[i+1:]...)
+		} else {
+			f.Tasks[i].State = state
+			f.Tasks[i].Statuses = append(f.Tasks[i].Statuses, status)
+		}
+		return
+	}
+}
+
+// terminal holds the terminal task states.
+var terminal = map[string]bool{
+	"TASK_FINISHED":         true,
+	"TASK_FAILED":           true,
+	"TASK_KILLED":           true,
+	"TASK_LOST":             true,
+	"TASK_ERROR":            true,
+	"TASK_DROPPED":          true,
+	"TASK_GONE":             true,
+	"TASK_GONE_BY_OPERATOR": true,
+}
+
+// The following types hold the messages of the Mesos v1 operator API which
+// differ from their /state.json counterparts.
+
+type v1ID struct {
+	Value string `json:"value"`
+}
+
+type v1Labels struct {
+	Labels []Label `json:"labels"`
+}
+
+type v1State struct {
+	GetTasks struct {
+		Tasks []v1Task `json:"tasks"`
+	} `json:"get_tasks"`
+	GetFrameworks struct {
+		Frameworks []v1Framework `json:"frameworks"`
+	} `json:"get_frameworks"`
+	GetAgents struct {
+		Agents []v1Agent `json:"agents"`
+	} `json:"get_agents"`
+}
+
+type v1Task struct {
+	Name        string        `json:"name"`
+	TaskID      v1ID          `json:"task_id"`
+	FrameworkID v1ID          `json:"framework_id"`
+	AgentID     v1ID          `json:"agent_id"`
+	State       string        `json:"state"`
+	Statuses    []v1Status    `json:"statuses"`
+	Labels      v1Labels      `json:"labels"`
+	Resources   []v1Resource  `json:"resources"`
+	Discovery   DiscoveryInfo `json:"discovery"`
+}
+
+// task returns the /state.json Task of t.
+func (t v1Task) task() Task {
+	task := Task{
+		FrameworkID:   t.FrameworkID.Value,
+		ID:            t.TaskID.Value,
+		Name:          t.Name,
+		SlaveID:       t.AgentID.Value,
+		State:         t.State,
+		Labels:        t.Labels.Labels,
+		DiscoveryInfo: t.Discovery,
+	}
+	for _, s := range t.Statuses {
+		task.Statuses = append(task.Statuses, s.status())
+	}
+	var ranges []string
+	for _, r := range t.Resources {
+		if r.Name != "ports" {
+			continue
+		}
+		for _, rg := range r.Ranges.Range {
+			ranges = append(ranges, strconv.FormatUint(rg.Begin, 10)+"-"+strconv.FormatUint(rg.End, 10))
+		}
+	}
+	if len(ranges) > 0 {
+		task.PortRanges = "[" + strings.Join(ranges, ", ") + "]"
+	}
+	return task
+}
+
+type v1Status struct {
+	TaskID          v1ID            `json:"task_id"`
+	State           string          `json:"state"`
+	Timestamp       float64         `json:"timestamp"`
+	Labels          v1Labels        `json:"labels"`
+	ContainerStatus ContainerStatus `json:"container_status"`
+	Healthy         *bool           `json:"healthy"`
+}
+
+// status returns the /state.json Status of s.
+func (s v1Status) status() Status {
+	return Status{
+		Timestamp:       s.Timestamp,
+		State:           s.State,
+		Labels:          s.Labels.Labels,
+		ContainerStatus: s.ContainerStatus,
+		Healthy:         s.Healthy,
+	}
+}
+
+type v1Resource struct {
+	Name   string `json:"name"`
+	Ranges struct {
+		Range []struct {
+			Begin uint64 `json:"begin"`
+			End   uint64 `json:"end"`
+		} `json:"range"`
+	} `json:"ranges"`
+}
+
+type v1Agent struct {
+	AgentInfo struct {
+		ID       v1ID   `json:"id"`
+		Hostname string `json:"hostname"`
+		Port     int    `json:"port"`
+	} `json:"agent_info"`
+	PID string `json:"pid"`
+}
+
+// slave returns the /state.json Slave of a, whose PID defaults to its
+// hostname and port.
+func (a v1Agent) slave() Slave {
+	info := a.AgentInfo
+	slave := Slave{ID: info.ID.Value, Hostname: info.Hostname}
+	if pid, err := upid.Parse(a.PID); err == nil {
+		slave.PID.UPID = pid
+	} else {
+		slave.PID.UPID = &upid.UPID{ID: "slave(1)", Host: info.Hostname, Port: strconv.Itoa(info.Port)}
+	}
+	return slave
+}
+
+type v1Framework struct {
+	FrameworkInfo v1FrameworkInfo `json:"framework_info"`
+}
+
+type v1FrameworkInfo struct {
+	ID       v1ID   `json:"id"`
+	Name     string `json:"name"`
+	Hostname string `json:"hostname"`
+}
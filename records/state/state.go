@@ -51,6 +51,8 @@ type Status struct {
 	State           string          `json:"state"`
 	Labels          []Label         `json:"labels,omitempty"`
 	ContainerStatus ContainerStatus `json:"container_status,omitempty"`
+	// Healthy is the result of the health checks of the task, if any
+	Healthy *bool `json:"healthy,omitempty"`
 }
 
 // ContainerStatus holds container metadata as defined in the /state.json
@@ -81,6 +83,7 @@ type Task struct {
 	SlaveID       string   `json:"slave_id"`
 	State         string   `json:"state"`
 	Statuses      []Status `json:"statuses"`
+	Labels        []Label  `json:"labels,omitempty"`
 	Resources     `json:"resources"`
 	DiscoveryInfo DiscoveryInfo `json:"discovery"`
 
@@ -172,8 +175,23 @@ func mesosIPs(t *Task) []string {
 	return statusIPs(t.Statuses, labels(MesosIPLabel))
 }
 
+// Unhealthy returns true if the latest running status of the task reports
+// failing health checks.
+func (t *Task) Unhealthy() bool {
+	s := latestRunning(t.Statuses)
+	return s != nil && s.Healthy != nil && !*s.Healthy
+}
+
 // statusIPs returns the latest running status IPs extracted with the given src
 func statusIPs(st []Status, src func(*Status) []string) []string {
+	if s := latestRunning(st); s != nil {
+		return src(s)
+	}
+	return nil
+}
+
+// latestRunning returns the latest running status of st, or nil if none.
+func latestRunning(st []Status) *Status {
 	// the state.json we extract from mesos makes no guarantees re: the order
 	// of the task statuses so we should check the timestamps to avoid problems
 	// down the line. we can't rely on seeing the same sequence. (@joris)
@@ -185,7 +203,7 @@ func statusIPs(st []Status, src func(*Status) []string) []string {
 		}
 	}
 	if j >= 0 {
-		return src(&st[j])
+		return &st[j]
 	}
 	return nil
 }
@@ -206,6 +224,7 @@ func labels(key string) func(*Status) []string {
 
 // Framework holds a framework as defined in the /state.json Mesos HTTP endpoint.
 type Framework struct {
+	ID       string `json:"id"`
 	Tasks    []Task `json:"tasks"`
 	PID      PID    `json:"pid"`
 	Name     string `json:"name"`
@@ -1,9 +1,13 @@
 package state_test
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/mesos/mesos-go/upid"
@@ -224,3 +228,92 @@ func oldnewnetinfo(oldip string, newip string) NetworkInfo {
 func timestamp(t float64) statusOpt {
 	return func(s *Status) { s.Timestamp = t }
 }
+
+func TestState_Apply(t *testing.T) {
+	b, err := ioutil.ReadFile("../../factories/events.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []Event
+	if err = json.Unmarshal(b, &events); err != nil {
+		t.Fatal(err)
+	}
+
+	// summary lists the frameworks with their tasks and the slaves of s
+	summary := func(s State) string {
+		var out []string
+		for _, f := range s.Frameworks {
+			var tasks []string
+			for _, task := range f.Tasks {
+				tasks = append(tasks, fmt.Sprintf("%s:%s:%s:%s", task.ID, task.State, task.IP("netinfo"), task.PortRanges))
+			}
+			out = append(out, fmt.Sprintf("%s%v", f.Name, tasks))
+		}
+		for _, slave := range s.Slaves {
+			out = append(out, slave.ID+"@"+slave.PID.Host+":"+slave.PID.Port)
+		}
+		return strings.Join(out, " ")
+	}
+
+	var s State
+	for i, want := range []string{
+		"marathon[web.1:TASK_RUNNING:172.17.0.2:[31000-31001]] agent-1@10.0.1.1:5051",
+		"marathon[web.1:TASK_RUNNING:172.17.0.2:[31000-31001]] agent-1@10.0.1.1:5051 agent-2@10.0.1.2:5051",
+		"marathon[web.1:TASK_RUNNING:172.17.0.2:[31000-31001] api.1:TASK_STAGING::[31005-31005]] agent-1@10.0.1.1:5051 agent-2@10.0.1.2:5051",
+		"marathon[web.1:TASK_RUNNING:172.17.0.2:[31000-31001] api.1:TASK_STAGING::[31005-31005]] agent-1@10.0.1.1:5051 agent-2@10.0.1.2:5051",
+		"marathon[web.1:TASK_RUNNING:172.17.0.2:[31000-31001] api.1:TASK_RUNNING::[31005-31005]] agent-1@10.0.1.1:5051 agent-2@10.0.1.2:5051",
+		"marathon[web.1:TASK_RUNNING:172.17.0.2:[31000-31001] api.1:TASK_RUNNING::[31005-31005]] chronos[] agent-1@10.0.1.1:5051 agent-2@10.0.1.2:5051",
+		"marathon[api.1:TASK_RUNNING::[31005-31005]] chronos[] agent-1@10.0.1.1:5051 agent-2@10.0.1.2:5051",
+		"marathon[api.1:TASK_RUNNING::[31005-31005]] agent-1@10.0.1.1:5051 agent-2@10.0.1.2:5051",
+		"marathon[api.1:TASK_RUNNING::[31005-31005]] agent-2@10.0.1.2:5051",
+	} {
+		if err := s.Apply(events[i]); err != nil {
+			t.Fatalf("event #%d: %v", i, err)
+		}
+		if got := summary(s); got != want {
+			t.Errorf("event #%d (%s):\ngot:  %s\nwant: %s", i, events[i].Type, got, want)
+		}
+	}
+
+	if err := s.Apply(Event{Type: "TASK_ADDED"}); err == nil {
+		t.Error("expected an error applying a malformed event")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	b, err := ioutil.ReadFile("../../factories/fake.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want State
+	if err = json.Unmarshal(b, &want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode and json.Unmarshal differ:\n%+v\n%+v", got, want)
+	}
+
+	for i, tt := range []struct {
+		in   string
+		want State
+		ok   bool
+	}{
+		{`{}`, State{}, true},
+		{`{"leader":"master@1.2.3.4:5050","frameworks":null}`, State{Leader: "master@1.2.3.4:5050", Frameworks: []Framework{}}, true},
+		{`{"completed_frameworks":[{"tasks":[{"id":"a","x":[1,{"y":null}]}]}],"frameworks":[{"name":"f","completed_tasks":[{"id":"b"}],"tasks":[]}]}`,
+			State{Frameworks: []Framework{{Name: "f", Tasks: []Task{}}}}, true},
+		{`[]`, State{}, false},
+		{`{"frameworks":[{"name":"f"}`, State{Frameworks: []Framework{{Name: "f"}}}, false},
+	} {
+		got, err := Decode(strings.NewReader(tt.in))
+		if (err == nil) != tt.ok {
+			t.Errorf("test #%d: got error %v, want ok %t", i, err, tt.ok)
+		} else if tt.ok && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("test #%d: got %+v, want %+v", i, got, tt.want)
+		}
+	}
+}
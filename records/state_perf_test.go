@@ -0,0 +1,139 @@
+package records
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+// largeState returns the JSON of the state of a large cluster, most of which
+// is made of completed tasks and frameworks ignored by Mesos-DNS.
+func largeState() []byte {
+	const (
+		slaveCount     = 2000
+		frameworkCount = 20
+		taskCount      = 500 // per framework, as many completed ones
+	)
+	var buf bytes.Buffer
+	task := func(f, t int, state string) {
+		fmt.Fprintf(&buf, `{"id":"task-%d-%d","name":"app%d","framework_id":"fw-%d","slave_id":"slave-%d",`+
+			`"state":%q,"resources":{"cpus":0.5,"mem":128,"disk":0,"ports":"[%d-%d]"},`+
+			`"statuses":[{"state":"TASK_RUNNING","timestamp":1444830000.5,"labels":[{"key":"k","value":"v"}],`+
+			`"container_status":{"network_infos":[{"ip_addresses":[{"ip_address":"10.0.%d.%d"}]}]}}],`+
+			`"discovery":{"visibility":"FRAMEWORK","name":"app%d","ports":{"ports":[{"number":%d,"protocol":"tcp"}]}}}`,
+			f, t, t%50, f, t%slaveCount, state, 31000+t, 31000+t, t/250, t%250, t%50, 31000+t)
+	}
+	framework := func(f int, state string) {
+		fmt.Fprintf(&buf, `{"id":"fw-%d","name":"framework%d","hostname":"master","pid":"scheduler-%d@10.0.0.1:1234","tasks":[`, f, f, f)
+		for t := 0; t < taskCount; t++ {
+			if t > 0 {
+				buf.WriteByte(',')
+			}
+			task(f, t, state)
+		}
+		buf.WriteString(`],"completed_tasks":[`)
+		for t := 0; t < taskCount; t++ {
+			if t > 0 {
+				buf.WriteByte(',')
+			}
+			task(f, t, "TASK_FINISHED")
+		}
+		buf.WriteString(`]}`)
+	}
+
+	buf.WriteString(`{"leader":"master@10.0.0.1:5050","frameworks":[`)
+	for f := 0; f < frameworkCount; f++ {
+		if f > 0 {
+			buf.WriteByte(',')
+		}
+		framework(f, "TASK_RUNNING")
+	}
+	buf.WriteString(`],"completed_frameworks":[`)
+	for f := 0; f < frameworkCount; f++ {
+		if f > 0 {
+			buf.WriteByte(',')
+		}
+		framework(frameworkCount+f, "TASK_KILLED")
+	}
+	buf.WriteString(`],"slaves":[`)
+	for s := 0; s < slaveCount; s++ {
+		if s > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(&buf, `{"id":"slave-%d","hostname":"agent%d","pid":"slave(1)@10.1.%d.%d:5051",`+
+			`"resources":{"cpus":8,"mem":32000,"ports":"[31000-32000]"},"attributes":{"rack":"r%d"}}`,
+			s, s, s/250, s%250, s%10)
+	}
+	buf.WriteString(`]}`)
+	return buf.Bytes()
+}
+
+func BenchmarkDecodeState(b *testing.B) {
+	body := largeState()
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := state.Decode(bytes.NewReader(body)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkUnmarshalState is the baseline of BenchmarkDecodeState, reading
+// the whole state before unmarshaling it.
+func BenchmarkUnmarshalState(b *testing.B) {
+	body := largeState()
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf, err := ioutil.ReadAll(bytes.NewReader(body))
+		if err != nil {
+			b.Fatal(err)
+		}
+		var sj state.State
+		if err = json.Unmarshal(buf, &sj); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoadFromMaster_gzip(b *testing.B) {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	if _, err := gz.Write(largeState()); err != nil {
+		b.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		b.Fatal(err)
+	}
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		_, _ = w.Write(body.Bytes())
+	}))
+	defer master.Close()
+	host, port, err := net.SplitHostPort(master.Listener.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	rg := NewRecordGenerator(time.Minute)
+	b.SetBytes(int64(body.Len()))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := rg.loadFromMaster(host, port); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
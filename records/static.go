@@ -0,0 +1,290 @@
+package records
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/errorutil"
+	"github.com/miekg/dns"
+)
+
+// StaticRecords holds records of the Mesos domain which don't come from the
+// Mesos state, merged by InsertState into the generated ones with the
+// following precedence:
+//
+//   - blackholed names lose all their records, and so don't exist;
+//   - static records replace the generated records of the same name and
+//     type, and static CNAME records all the generated records of their name;
+//   - all other generated records are kept.
+type StaticRecords struct {
+	rrs        map[rrsKind]rrs
+	blackholes map[string]struct{}
+}
+
+// staticKinds are the kinds of static records, in enumeration order.
+var staticKinds = []rrsKind{A, AAAA, CNAME, SRV, TXT}
+
+// LoadStaticRecords loads the static records of the given domain from the
+// given file: a JSON records file if it has a .json extension, and an RFC
+// 1035 zone file, whose origin is the domain, otherwise. JSON records files
+// look like:
+//
+//	{
+//	  "records": [
+//	    {"name": "db.mesos", "type": "CNAME", "value": "db.rds.amazonaws.com"},
+//	    {"name": "registry.mesos", "type": "A", "value": "10.0.0.5"},
+//	    {"name": "_registry._tcp.mesos", "type": "SRV", "value": "registry.mesos:5000"}
+//	  ],
+//	  "blackholes": ["legacy.marathon.mesos"]
+//	}
+//
+// Only A, AAAA, CNAME, SRV and TXT records are supported, and the SOA and NS
+// records of zone files are ignored. SRV values are given as target:port, and
+// their priority and weight are ignored. Names are case insensitive and
+// qualified, so they must all be in the domain.
+func LoadStaticRecords(file, domain string) (*StaticRecords, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer errorutil.Ignore(f.Close)
+
+	s := &StaticRecords{rrs: map[rrsKind]rrs{}, blackholes: map[string]struct{}{}}
+	for _, kind := range staticKinds {
+		s.rrs[kind] = rrs{}
+	}
+	zone := strings.ToLower(dns.Fqdn(domain))
+
+	if filepath.Ext(file) == ".json" {
+		var records struct {
+			Records []struct {
+				Name  string `json:"name"`
+				Type  string `json:"type"`
+				Value string `json:"value"`
+			} `json:"records"`
+			Blackholes []string `json:"blackholes"`
+		}
+		if err = json.NewDecoder(f).Decode(&records); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal static records file %q: %v", file, err)
+		}
+		for _, r := range records.Records {
+			if err = s.add(zone, r.Name, rrsKind(strings.ToUpper(r.Type)), r.Value); err != nil {
+				return nil, fmt.Errorf("invalid %s record %s in %q: %v", r.Type, r.Name, file, err)
+			}
+		}
+		for _, name := range records.Blackholes {
+			name = strings.ToLower(dns.Fqdn(name))
+			if !dns.IsSubDomain(zone, name) {
+				return nil, fmt.Errorf("blackholed name %s in %q isn't in the %s domain", name, file, zone)
+			}
+			s.blackholes[name] = struct{}{}
+		}
+	} else {
+		for t := range dns.ParseZone(f, zone, file) {
+			if err != nil {
+				continue // drain the parser
+			} else if t.Error != nil {
+				err = t.Error
+			} else if err = s.addRR(zone, t.RR); err != nil {
+				err = fmt.Errorf("invalid record %q in %q: %v", t.RR, file, err)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for name := range s.rrs[CNAME] {
+		for _, kind := range staticKinds {
+			if kind != CNAME && len(s.rrs[kind][name]) > 0 {
+				return nil, fmt.Errorf("CNAME record %s in %q has other records", name, file)
+			}
+		}
+		if len(s.rrs[CNAME][name]) > 1 {
+			return nil, fmt.Errorf("CNAME record %s in %q has more than one target", name, file)
+		}
+	}
+	return s, nil
+}
+
+// addRR adds the given record of a zone file to s.
+func (s *StaticRecords) addRR(zone string, rr dns.RR) error {
+	name := rr.Header().Name
+	switch rr := rr.(type) {
+	case *dns.A:
+		return s.add(zone, name, A, rr.A.String())
+	case *dns.AAAA:
+		return s.add(zone, name, AAAA, rr.AAAA.String())
+	case *dns.CNAME:
+		return s.add(zone, name, CNAME, rr.Target)
+	case *dns.SRV:
+		return s.add(zone, name, SRV, net.JoinHostPort(rr.Target, strconv.Itoa(int(rr.Port))))
+	case *dns.TXT:
+		return s.add(zone, name, TXT, strings.Join(rr.Txt, ""))
+	case *dns.SOA, *dns.NS:
+		return nil
+	default:
+		return fmt.Errorf("unsupported type %s", dns.TypeToString[rr.Header().Rrtype])
+	}
+}
+
+// add adds a record of the given kind and value, as held by a
+// RecordGenerator, to s.
+func (s *StaticRecords) add(zone, name string, kind rrsKind, value string) error {
+	name = strings.ToLower(dns.Fqdn(name))
+	if !dns.IsSubDomain(zone, name) {
+		return fmt.Errorf("not in the %s domain", zone)
+	}
+	switch kind {
+	case A, AAAA:
+		ip := net.ParseIP(value)
+		if ip == nil || (ip.To4() != nil) != (kind == A) {
+			return fmt.Errorf("invalid address %q", value)
+		}
+		value = ip.String()
+	case CNAME:
+		if _, ok := dns.IsDomainName(value); !ok || value == "" {
+			return fmt.Errorf("invalid target %q", value)
+		}
+		value = strings.ToLower(dns.Fqdn(value))
+	case SRV:
+		host, port, err := net.SplitHostPort(value)
+		if err != nil {
+			return err
+		} else if _, err = strconv.ParseUint(port, 10, 16); err != nil {
+			return fmt.Errorf("invalid port %q", port)
+		}
+		value = net.JoinHostPort(strings.ToLower(dns.Fqdn(host)), port)
+	case TXT:
+		if len(value) > maxTXTLen {
+			return fmt.Errorf("longer than %d characters", maxTXTLen)
+		}
+	default:
+		return fmt.Errorf("unsupported type")
+	}
+	s.rrs[kind].add(name, value)
+	return nil
+}
+
+// insertStatic merges s into the records of rg with the precedence of
+// StaticRecords, and enumerates them.
+func (rg *RecordGenerator) insertStatic(s *StaticRecords) {
+	dropped := map[string]struct{}{}
+	drop := func(name string, kinds ...rrsKind) {
+		for _, kind := range kinds {
+			delete(kind.rrs(rg), name)
+		}
+		dropped[name] = struct{}{}
+	}
+
+	for name := range s.blackholes {
+		drop(name, staticKinds...)
+		delete(rg.HostPorts, name)
+	}
+	for _, kind := range staticKinds {
+		for name, values := range s.rrs[kind] {
+			if _, ok := s.blackholes[name]; ok {
+				continue
+			}
+			if kind == CNAME {
+				drop(name, staticKinds...)
+			} else {
+				drop(name, kind, CNAME)
+			}
+			for value := range values {
+				rg.insertRR(name, value, kind)
+				rg.EnumData.Static = append(rg.EnumData.Static, EnumerableRecord{Name: name, Host: value, Rtype: string(kind)})
+			}
+		}
+	}
+
+	// reverse lookups of blackholed names and the enumeration of the records
+	// replaced follow suit
+	for arpa, names := range rg.PTRs {
+		for name := range names {
+			if _, ok := s.blackholes[name]; ok {
+				delete(names, name)
+			}
+		}
+		if len(names) == 0 {
+			delete(rg.PTRs, arpa)
+		}
+	}
+	for _, f := range rg.EnumData.Frameworks {
+		for _, t := range f.Tasks {
+			records := t.Records[:0]
+			for _, r := range t.Records {
+				if _, ok := dropped[r.Name]; !ok {
+					records = append(records, r)
+				} else if _, ok := rrsKind(r.Rtype).rrs(rg)[r.Name][r.Host]; ok {
+					records = append(records, r)
+				}
+			}
+			t.Records = records
+		}
+	}
+}
+
+// StaticFile is a static records file, loaded with LoadStaticRecords and
+// reloaded by Refresh whenever it changes.
+type StaticFile struct {
+	path, domain string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	records *StaticRecords
+}
+
+// NewStaticFile returns the StaticFile of the given path, holding static
+// records of the given domain.
+func NewStaticFile(path, domain string) (*StaticFile, error) {
+	f := &StaticFile{path: path, domain: domain}
+	if _, err := f.Refresh(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Records returns the current records of f. A nil StaticFile has none.
+func (f *StaticFile) Records() *StaticRecords {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.records
+}
+
+// Refresh reloads the records of f if its file changed since they were
+// loaded, returning whether they were. The current records are kept if the
+// file can't be loaded. A nil StaticFile never changes.
+func (f *StaticFile) Refresh() (bool, error) {
+	if f == nil {
+		return false, nil
+	}
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return false, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.records != nil && fi.ModTime().Equal(f.modTime) && fi.Size() == f.size {
+		return false, nil
+	}
+	records, err := LoadStaticRecords(f.path, f.domain)
+	if err != nil {
+		return false, err
+	}
+	logger.Info("static records loaded", "file", f.path)
+	f.records, f.modTime, f.size = records, fi.ModTime(), fi.Size()
+	return true, nil
+}
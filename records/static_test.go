@@ -0,0 +1,213 @@
+package records
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mesosphere/mesos-dns/records/labels"
+)
+
+const (
+	staticZone = `$TTL 60
+@              IN SOA   ns1.mesos. root.ns1.mesos. 1 60 600 86400 60
+db             IN CNAME db.rds.amazonaws.com.
+Registry       IN A     10.0.0.5
+registry       IN AAAA  fd00::5
+_registry._tcp IN SRV   0 0 5000 registry
+registry       IN TXT   "owner=ops"
+`
+	staticJSON = `{
+  "records": [
+    {"name": "db.mesos", "type": "CNAME", "value": "db.rds.amazonaws.com"},
+    {"name": "Registry.mesos.", "type": "A", "value": "10.0.0.5"},
+    {"name": "registry.mesos", "type": "aaaa", "value": "fd00::5"},
+    {"name": "_registry._tcp.mesos", "type": "SRV", "value": "registry.mesos:5000"},
+    {"name": "registry.mesos", "type": "TXT", "value": "owner=ops"}
+  ],
+  "blackholes": ["legacy.marathon.mesos"]
+}`
+)
+
+// writeStatic writes a static records file of the given name and content to
+// the given directory, returning its path.
+func writeStatic(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadStaticRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesos-dns-static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	want := map[rrsKind]rrs{
+		A:     {"registry.mesos.": {"10.0.0.5": {}}},
+		AAAA:  {"registry.mesos.": {"fd00::5": {}}},
+		CNAME: {"db.mesos.": {"db.rds.amazonaws.com.": {}}},
+		SRV:   {"_registry._tcp.mesos.": {"registry.mesos.:5000": {}}},
+		TXT:   {"registry.mesos.": {"owner=ops": {}}},
+	}
+	for _, tt := range []struct {
+		name, content string
+		blackholes    map[string]struct{}
+	}{
+		{"static.zone", staticZone, map[string]struct{}{}},
+		{"static.json", staticJSON, map[string]struct{}{"legacy.marathon.mesos.": {}}},
+	} {
+		s, err := LoadStaticRecords(writeStatic(t, dir, tt.name, tt.content), "mesos")
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(s.rrs, want) {
+			t.Errorf("%s: got records %v, want %v", tt.name, s.rrs, want)
+		}
+		if !reflect.DeepEqual(s.blackholes, tt.blackholes) {
+			t.Errorf("%s: got blackholes %v, want %v", tt.name, s.blackholes, tt.blackholes)
+		}
+	}
+
+	for i, tt := range []struct{ name, content string }{
+		{"outside.zone", "db.example.com. IN A 10.0.0.1\n"},
+		{"mx.zone", "mesos. IN MX 10 mail.example.com.\n"},
+		{"cname.zone", "db IN CNAME db.example.com.\ndb IN A 10.0.0.1\n"},
+		{"syntax.zone", "db IN A\n"},
+		{"ipv6.json", `{"records": [{"name": "db.mesos", "type": "A", "value": "fd00::1"}]}`},
+		{"port.json", `{"records": [{"name": "_db._tcp.mesos", "type": "SRV", "value": "db.mesos:http"}]}`},
+		{"type.json", `{"records": [{"name": "db.mesos", "type": "MX", "value": "mail.mesos"}]}`},
+		{"blackhole.json", `{"blackholes": ["db.example.com"]}`},
+		{"syntax.json", `{"records": [`},
+	} {
+		if _, err := LoadStaticRecords(writeStatic(t, dir, tt.name, tt.content), "mesos"); err == nil {
+			t.Errorf("test #%d: expected an error loading %s", i, tt.name)
+		}
+	}
+}
+
+func TestInsertState_static(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesos-dns-static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := LoadStaticRecords(writeStatic(t, dir, "static.json", `{
+  "records": [
+    {"name": "chronos.marathon.mesos", "type": "A", "value": "10.0.0.1"},
+    {"name": "toy-store.marathon.mesos", "type": "CNAME", "value": "registry.mesos"},
+    {"name": "registry.mesos", "type": "A", "value": "10.0.0.5"}
+  ],
+  "blackholes": ["nginx.marathon.mesos", "nginx-6ud99-0.marathon.mesos"]
+}`), "mesos")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rg := NewRecordGenerator(0, WithStaticRecords(s))
+	err = rg.InsertState(fakeState(t), "mesos", "mesos-dns.mesos.", "127.0.0.1", nil, []string{"docker", "mesos", "host"}, []string{"owner"}, labels.RFC952)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tt := range []struct {
+		kind rrsKind
+		name string
+		want []string
+	}{
+		{A, "chronos.marathon.mesos.", []string{"10.0.0.1"}},
+		{A, "registry.mesos.", []string{"10.0.0.5"}},
+		{CNAME, "toy-store.marathon.mesos.", []string{"registry.mesos."}},
+		{A, "toy-store.marathon.mesos.", nil},
+		{AAAA, "toy-store.marathon.mesos.", nil},
+		{TXT, "toy-store.marathon.mesos.", nil},
+		{TXT, "toy-store-n96qe-0.marathon.mesos.", []string{"owner=toys"}},
+		{A, "nginx.marathon.mesos.", nil},
+		{A, "nginx.marathon.slave.mesos.", []string{"1.2.3.11"}},
+		{PTR, "3.0.3.10.in-addr.arpa.", nil},
+		{A, "liquor-store.marathon.mesos.", []string{"10.3.0.1", "10.3.0.2"}},
+	} {
+		var got []string
+		for host := range tt.kind.rrs(rg)[tt.name] {
+			got = append(got, host)
+		}
+		if !equalSets(got, tt.want) {
+			t.Errorf("test #%d: got %s records %v of %s, want %v", i, tt.kind, got, tt.name, tt.want)
+		}
+	}
+
+	if got := len(rg.EnumData.Static); got != 3 {
+		t.Errorf("got %d enumerated static records, want 3", got)
+	}
+	for _, f := range rg.EnumData.Frameworks {
+		for _, task := range f.Tasks {
+			for _, r := range task.Records {
+				if _, ok := rrsKind(r.Rtype).rrs(rg)[r.Name][r.Host]; !ok {
+					t.Errorf("enumerated %s record %s: %s of task %s isn't served", r.Rtype, r.Name, r.Host, task.ID)
+				}
+			}
+		}
+	}
+}
+
+// equalSets returns whether a and b hold the same strings, in any order.
+func equalSets(a, b []string) bool {
+	set := map[string]int{}
+	for _, s := range a {
+		set[s]++
+	}
+	for _, s := range b {
+		set[s]--
+	}
+	for _, n := range set {
+		if n != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestStaticFile_Refresh(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesos-dns-static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeStatic(t, dir, "static.zone", "db IN A 10.0.0.1\n")
+	f, err := NewStaticFile(path, "mesos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := f.Refresh(); changed || err != nil {
+		t.Errorf("got changed %t, error %v refreshing an unchanged file", changed, err)
+	}
+
+	writeStatic(t, dir, "static.zone", "db IN A 10.0.0.2\ndb IN A 10.0.0.3\n")
+	if changed, err := f.Refresh(); !changed || err != nil {
+		t.Errorf("got changed %t, error %v refreshing a changed file", changed, err)
+	}
+	if got := len(f.Records().rrs[A]["db.mesos."]); got != 2 {
+		t.Errorf("got %d A records, want the 2 of the changed file", got)
+	}
+
+	writeStatic(t, dir, "static.zone", "db IN A bogus\n")
+	if changed, err := f.Refresh(); changed || err == nil {
+		t.Errorf("got changed %t, error %v refreshing an invalid file", changed, err)
+	}
+	if got := len(f.Records().rrs[A]["db.mesos."]); got != 2 {
+		t.Errorf("got %d A records, want the 2 kept from the valid file", got)
+	}
+
+	var nilFile *StaticFile
+	if changed, err := nilFile.Refresh(); changed || err != nil || nilFile.Records() != nil {
+		t.Error("a nil StaticFile should have no records and never change")
+	}
+}
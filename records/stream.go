@@ -0,0 +1,204 @@
+package records
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/errorutil"
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+const (
+	// missedHeartbeats is the number of heartbeat intervals without any event
+	// after which an event stream is considered dropped.
+	missedHeartbeats = 3
+	// maxEventSize is the maximum size of a single event, which bounds the
+	// size of the state snapshot of the SUBSCRIBED event.
+	maxEventSize = 256 << 20
+)
+
+// ErrStreamStopped is returned by Stream when stopped by its caller.
+var ErrStreamStopped = errors.New("event stream stopped")
+
+// Stream subscribes to the event stream of the Mesos v1 operator API of the
+// leading master, trying masters in the same order as ParseState. It calls
+// update with the state snapshot the master subscribed with, and then with
+// that state after each batch of events applied to it; the state mustn't be
+// retained once update returns. It calls heartbeat, if not nil, on each
+// HEARTBEAT event, showing the records are still up to date. Stream blocks
+// until the stream drops, update returns an error or stop is closed,
+// returning why; it's then up to the caller to poll the state with ParseState
+// and to subscribe again.
+func (rg *RecordGenerator) Stream(update func(state.State) error, heartbeat func(), stop <-chan struct{}, masters ...string) error {
+	// the state timeout only bounds the subscription; the stream itself is
+	// bounded by its heartbeats
+	timeout := rg.httpClient.Timeout
+	client := rg.httpClient
+	client.Timeout = 0
+
+	cancel := make(chan struct{})
+	var once sync.Once
+	watchdog := time.AfterFunc(math.MaxInt64, func() { once.Do(func() { close(cancel) }) })
+	defer watchdog.Stop()
+	if timeout > 0 {
+		watchdog.Reset(timeout)
+	}
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-stop:
+			once.Do(func() { close(cancel) })
+		case <-finished:
+		}
+	}()
+	stopped := func() bool {
+		select {
+		case <-stop:
+			return true
+		default:
+			return false
+		}
+	}
+
+	resp, leader, err := subscribe(&client, rg.scheme, cancel, masters...)
+	if err != nil {
+		if stopped() {
+			return ErrStreamStopped
+		}
+		return err
+	}
+	defer errorutil.Ignore(resp.Body.Close)
+	logger.Info("subscribed to the event stream", "master", leader)
+
+	sj := state.State{Leader: "master@" + leader}
+	r := bufio.NewReader(resp.Body)
+	pending := false // events applied since the last update
+	for subscribed := false; ; subscribed = true {
+		record, err := readRecord(r)
+		if err != nil {
+			if stopped() {
+				return ErrStreamStopped
+			}
+			select {
+			case <-cancel:
+				return errors.New("event stream timed out")
+			default:
+				return err
+			}
+		}
+
+		var e state.Event
+		if err = json.Unmarshal(record, &e); err != nil {
+			return fmt.Errorf("failed to unmarshal event: %v", err)
+		}
+		logging.CurLog.StreamEvents.Inc()
+		logger.Debug("event received", "type", e.Type)
+		if !subscribed && e.Type != "SUBSCRIBED" {
+			return fmt.Errorf("expected a SUBSCRIBED event, got %s", e.Type)
+		}
+		if err = sj.Apply(e); err != nil {
+			return err
+		}
+		pending = pending || e.Type != "HEARTBEAT"
+
+		if interval := e.HeartbeatInterval(); interval > 0 {
+			timeout = time.Duration(interval * missedHeartbeats * float64(time.Second))
+		}
+		if timeout > 0 {
+			watchdog.Reset(timeout)
+		}
+
+		if e.Type == "HEARTBEAT" && heartbeat != nil {
+			heartbeat()
+		}
+		// events already buffered are applied before updating the records
+		if !pending || r.Buffered() > 0 {
+			continue
+		}
+		if err = update(sj); err != nil {
+			return err
+		}
+		pending = false
+	}
+}
+
+// subscribe sends a SUBSCRIBE call to the operator API of the first of the
+// given masters accepting it, following the redirection of non leading
+// masters to the leading one. It returns the streaming response along with
+// the host:port address of the leading master.
+func subscribe(client *http.Client, scheme string, cancel <-chan struct{}, masters ...string) (*http.Response, string, error) {
+	if len(masters) > 0 && masters[0] == "" {
+		masters = masters[1:]
+	}
+
+	errs := []string{}
+	for _, master := range masters {
+		resp, err := subscribeTo(client, scheme, cancel, master)
+		if err == nil && resp.StatusCode == http.StatusTemporaryRedirect {
+			// non leading masters redirect to the leading one, e.g. with
+			// Location: //10.0.0.1:5050/api/v1
+			errorutil.Ignore(resp.Body.Close)
+			var u *url.URL
+			if u, err = url.Parse(resp.Header.Get("Location")); err == nil {
+				master = u.Host
+				resp, err = subscribeTo(client, scheme, cancel, master)
+			}
+		}
+		if err == nil && resp.StatusCode != http.StatusOK {
+			errorutil.Ignore(resp.Body.Close)
+			err = fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		if err == nil {
+			return resp, master, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", master, err))
+	}
+	return nil, "", fmt.Errorf("failed to subscribe to any master: %s", strings.Join(errs, "; "))
+}
+
+func subscribeTo(client *http.Client, scheme string, cancel <-chan struct{}, master string) (*http.Response, error) {
+	if _, _, err := net.SplitHostPort(master); err != nil {
+		return nil, err
+	}
+	u := url.URL{Scheme: scheme, Host: master, Path: "/api/v1"}
+	req, err := http.NewRequest("POST", u.String(), bytes.NewBufferString(`{"type":"SUBSCRIBE"}`))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Cancel = cancel
+	return client.Do(req)
+}
+
+// readRecord reads a record of a RecordIO stream, in which each record is
+// prefixed by its size in bytes and a newline.
+func readRecord(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	size, err := strconv.ParseUint(strings.TrimSpace(line), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed record size %q", line)
+	} else if size > maxEventSize {
+		return nil, fmt.Errorf("record of %d bytes is too large", size)
+	}
+	record := make([]byte, size)
+	_, err = io.ReadFull(r, record)
+	return record, err
+}
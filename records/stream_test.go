@@ -0,0 +1,143 @@
+package records
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+// fakeMaster returns a fake Mesos master serving the given events on the
+// SUBSCRIBE stream of its operator API, then hanging until done is closed.
+func fakeMaster(events []json.RawMessage, done <-chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var call struct{ Type string }
+		if r.Method != "POST" || r.URL.Path != "/api/v1" {
+			http.NotFound(w, r)
+			return
+		} else if err := json.NewDecoder(r.Body).Decode(&call); err != nil || call.Type != "SUBSCRIBE" {
+			http.Error(w, "expected a SUBSCRIBE call", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		for _, e := range events {
+			fmt.Fprintf(w, "%d\n%s", len(e), e)
+			w.(http.Flusher).Flush()
+		}
+		<-done
+	}))
+}
+
+// recordedEvents returns the recorded event stream of factories/events.json.
+func recordedEvents(t *testing.T) []json.RawMessage {
+	b, err := ioutil.ReadFile("../factories/events.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []json.RawMessage
+	if err = json.Unmarshal(b, &events); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestStream(t *testing.T) {
+	done := make(chan struct{})
+	master := fakeMaster(recordedEvents(t), done)
+	defer master.Close()
+	defer close(done)
+
+	// a non leading master redirecting to the leading one
+	follower := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "//"+master.Listener.Addr().String()+"/api/v1")
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	}))
+	defer follower.Close()
+
+	var rg *RecordGenerator
+	update := func(sj state.State) error {
+		rg = NewRecordGenerator(0)
+		if err := rg.ConvertState(sj, NewConfig()); err != nil {
+			t.Fatal(err)
+		}
+		// done once the whole stream is applied
+		_, api := rg.As["api.marathon.mesos."]
+		_, web := rg.As["web.marathon.mesos."]
+		if api && !web {
+			return io.EOF
+		}
+		return nil
+	}
+	masters := []string{"", follower.Listener.Addr().String()}
+	if err := NewRecordGenerator(time.Second).Stream(update, nil, nil, masters...); err != io.EOF {
+		t.Fatalf("got error %v, want the stream to be stopped by update", err)
+	}
+
+	if !rg.exists("api.marathon.mesos.", "10.0.1.2", A) {
+		t.Errorf("missing the A record of the api task added to the stream")
+	}
+	if !rg.exists("leader.mesos.", master.Listener.Addr().(*net.TCPAddr).IP.String(), A) {
+		t.Errorf("missing the A record of the leading master")
+	}
+	if rg.exists("web.marathon.mesos.", "10.0.1.1", A) {
+		t.Errorf("unexpected A record of the killed web task")
+	}
+}
+
+func TestStream_Timeout(t *testing.T) {
+	done := make(chan struct{})
+	subscribed := json.RawMessage(`{"type":"SUBSCRIBED","subscribed":{"get_state":{},"heartbeat_interval_seconds":0.01}}`)
+	heartbeat := json.RawMessage(`{"type":"HEARTBEAT"}`)
+	master := fakeMaster([]json.RawMessage{subscribed, heartbeat}, done)
+	defer master.Close()
+	defer close(done)
+
+	updates, heartbeats := 0, 0
+	update := func(state.State) error { updates++; return nil }
+	err := NewRecordGenerator(time.Second).Stream(update, func() { heartbeats++ }, nil, master.Listener.Addr().String())
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("got error %v, want a timeout after missed heartbeats", err)
+	}
+	if updates != 1 {
+		t.Errorf("got %d updates, want one with the snapshot", updates)
+	}
+	if heartbeats != 1 {
+		t.Errorf("got %d heartbeats, want 1", heartbeats)
+	}
+}
+
+func TestStream_Stop(t *testing.T) {
+	done := make(chan struct{})
+	subscribed := json.RawMessage(`{"type":"SUBSCRIBED","subscribed":{"get_state":{}}}`)
+	master := fakeMaster([]json.RawMessage{subscribed}, done)
+	defer master.Close()
+	defer close(done)
+
+	stop := make(chan struct{})
+	update := func(state.State) error { close(stop); return nil }
+	err := NewRecordGenerator(time.Second).Stream(update, nil, stop, master.Listener.Addr().String())
+	if err != ErrStreamStopped {
+		t.Errorf("got error %v, want the stream stopped", err)
+	}
+}
+
+func TestReadRecord(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("5\nhello3\nfoobar"))
+	for _, want := range []string{"hello", "foo"} {
+		if got, err := readRecord(r); err != nil || string(got) != want {
+			t.Errorf("got %q, %v, want %q", got, err, want)
+		}
+	}
+	if _, err := readRecord(r); err == nil {
+		t.Error("expected an error reading a malformed record")
+	}
+}
@@ -0,0 +1,296 @@
+package records
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tempFiles writes the given files to a new temporary directory, returning
+// its path.
+func tempFiles(t *testing.T, files map[string][]byte) string {
+	dir, err := ioutil.TempDir("", "mesos-dns-transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// clientCert returns the PEM encoded key and self-signed certificate of a
+// freshly generated client certificate.
+func clientCert(t *testing.T, key *rsa.PrivateKey) (keyPEM, certPEM []byte) {
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return keyPEM, certPEM
+}
+
+// fakeTLSMaster returns a fake Mesos master serving its state over HTTPS to
+// clients presenting a certificate and authorized by authorized.
+func fakeTLSMaster(authorized func(*http.Request) bool) *httptest.Server {
+	master := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/master/state.json" {
+			http.NotFound(w, r)
+		} else if !authorized(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"leader":"master@127.0.0.1:5050"}`))
+		}
+	}))
+	master.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	master.StartTLS()
+	return master
+}
+
+func TestNewTransport(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, certPEM := clientCert(t, key)
+
+	master := fakeTLSMaster(func(r *http.Request) bool {
+		user, pass, ok := r.BasicAuth()
+		return ok && user == "mesos-dns" && pass == "secret" ||
+			r.Header.Get("Authorization") == "Bearer s3cr3t" ||
+			r.Header.Get("Authorization") == ""
+	})
+	defer master.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: master.TLS.Certificates[0].Certificate[0]})
+
+	dir := tempFiles(t, map[string][]byte{
+		"ca.pem":   ca,
+		"cert.pem": certPEM,
+		"key.pem":  keyPEM,
+		"token":    []byte("s3cr3t\n"),
+	})
+	defer os.RemoveAll(dir)
+
+	host, port, err := net.SplitHostPort(master.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, tt := range []struct {
+		config func(*Config)
+		ok     bool
+	}{
+		{func(*Config) {}, true},
+		{func(c *Config) { c.MesosCredentials = Credentials{"mesos-dns", "secret"} }, true},
+		{func(c *Config) { c.MesosCredentials = Credentials{"mesos-dns", "wrong"} }, false},
+		{func(c *Config) { c.MesosAuthTokenFile = filepath.Join(dir, "token") }, true},
+		{func(c *Config) { c.MesosAuthTokenFile = filepath.Join(dir, "missing") }, false},
+		{func(c *Config) { c.CACertFile = "" }, false},
+		{func(c *Config) { c.CertFile, c.KeyFile = "", "" }, false},
+	} {
+		c := NewConfig()
+		c.CACertFile = filepath.Join(dir, "ca.pem")
+		c.CertFile, c.KeyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+		tt.config(&c)
+
+		rt, err := NewTransport(c)
+		if err != nil {
+			t.Fatalf("test #%d: %v", i, err)
+		}
+		rg := NewRecordGenerator(time.Second, WithTransport(rt), WithHTTPS(true))
+		sj, err := rg.loadFromMaster(host, port)
+		if tt.ok && (err != nil || sj.Leader != "master@127.0.0.1:5050") {
+			t.Errorf("test #%d: got state %+v, error %v, want the state of the master", i, sj, err)
+		} else if !tt.ok && err == nil {
+			t.Errorf("test #%d: got state %+v, want an error", i, sj)
+		}
+	}
+}
+
+func TestNewTransport_IAM(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, certPEM := clientCert(t, key)
+
+	// the IAM service hands out a new token on each login, while the master
+	// only accepts the latest one
+	var mu sync.Mutex
+	logins, current := 0, ""
+	iam := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var login struct{ UID, Token string }
+		if err := json.NewDecoder(r.Body).Decode(&login); err != nil || login.UID != "mesos-dns" {
+			http.Error(w, "bad login", http.StatusBadRequest)
+			return
+		}
+		parts := strings.Split(login.Token, ".")
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[len(parts)-1])
+		hash := sha256.Sum256([]byte(strings.Join(parts[:len(parts)-1], ".")))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], sig); err != nil {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		logins++
+		current = fmt.Sprintf("token%d", logins)
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": current})
+	}))
+	defer iam.Close()
+
+	master := fakeTLSMaster(func(r *http.Request) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return r.Header.Get("Authorization") == "token="+current
+	})
+	defer master.Close()
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: master.TLS.Certificates[0].Certificate[0]})
+
+	account, err := json.Marshal(map[string]string{
+		"uid":            "mesos-dns",
+		"private_key":    string(keyPEM),
+		"login_endpoint": iam.URL + "/acs/api/v1/auth/login",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := tempFiles(t, map[string][]byte{
+		"ca.pem":   ca,
+		"cert.pem": certPEM,
+		"key.pem":  keyPEM,
+		"iam.json": account,
+	})
+	defer os.RemoveAll(dir)
+
+	c := NewConfig()
+	c.CACertFile = filepath.Join(dir, "ca.pem")
+	c.CertFile, c.KeyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	c.IAMConfigFile = filepath.Join(dir, "iam.json")
+	rt, err := NewTransport(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rg := NewRecordGenerator(time.Second, WithTransport(rt), WithHTTPS(true))
+	host, port, err := net.SplitHostPort(master.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []int{1, 1, 2} {
+		if i == 2 {
+			// the token is then renewed on the next request
+			mu.Lock()
+			current = "revoked"
+			mu.Unlock()
+		}
+		if _, err := rg.loadFromMaster(host, port); err != nil {
+			t.Fatalf("request #%d: %v", i, err)
+		}
+		mu.Lock()
+		if logins != want {
+			t.Errorf("request #%d: got %d logins, want %d", i, logins, want)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestAuthTransport_Redirects(t *testing.T) {
+	var mu sync.Mutex
+	auths := map[string]string{}
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		auths["other"+r.URL.Path] = r.Header.Get("Authorization")
+	}))
+	defer other.Close()
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		auths[r.URL.Path] = r.Header.Get("Authorization") + r.Header.Get(noAuthHeader)
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/same":
+			http.Redirect(w, r, "/target", http.StatusFound)
+		case "/other":
+			http.Redirect(w, r, other.URL+"/target", http.StatusFound)
+		}
+	}))
+	defer master.Close()
+
+	rt := &authTransport{rt: http.DefaultTransport, auth: func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer s3cr3t")
+		return nil
+	}}
+	rg := NewRecordGenerator(time.Second, WithTransport(rt))
+	for _, path := range []string{"/same", "/other"} {
+		resp, err := rg.httpClient.Get(master.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+	}
+
+	want := map[string]string{
+		"/same":        "Bearer s3cr3t",
+		"/target":      "Bearer s3cr3t",
+		"/other":       "Bearer s3cr3t",
+		"other/target": "",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(auths, want) {
+		t.Errorf("got Authorization headers %v, want %v", auths, want)
+	}
+
+	// downgrades from HTTPS to HTTP on the same host aren't authenticated
+	req, _ := http.NewRequest("GET", "http://master:5050/target", nil)
+	via, _ := http.NewRequest("GET", "https://master:5050/", nil)
+	if err := rt.checkRedirect(req, []*http.Request{via}); err != nil || req.Header.Get(noAuthHeader) == "" {
+		t.Errorf("got error %v, headers %v, want the redirect to be unauthenticated", err, req.Header)
+	}
+}
+
+func TestJWTExpiry(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwt, err := signJWT(key, map[string]interface{}{"uid": "mesos-dns", "exp": 1500000000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, ok := jwtExpiry(jwt); !ok || exp.Unix() != 1500000000 {
+		t.Errorf("got expiry %v, %t, want %v", exp, ok, time.Unix(1500000000, 0))
+	}
+	if _, ok := jwtExpiry("opaque"); ok {
+		t.Error("unexpected expiry of an opaque token")
+	}
+}
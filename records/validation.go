@@ -3,6 +3,13 @@ package records
 import (
 	"fmt"
 	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mesosphere/mesos-dns/exchanger"
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/miekg/dns"
 )
 
 func validateEnabledServices(c *Config) error {
@@ -42,7 +49,8 @@ func validateMasters(ms []string) error {
 	return nil
 }
 
-// validateResolvers checks that each resolver in the list is a properly formatted IP address.
+// validateResolvers checks that each resolver in the list is a properly formatted IP address,
+// or a valid tls:// or https:// URL.
 // duplicate resolvers in the list are not allowed.
 // returns nil if the resolver list is empty, or else all resolvers in the list are valid.
 func validateResolvers(rs []string) error {
@@ -51,6 +59,17 @@ func validateResolvers(rs []string) error {
 	}
 	ips := make(map[string]struct{}, len(rs))
 	for _, r := range rs {
+		if strings.Contains(r, "://") {
+			addr, err := ResolverAddr(r)
+			if err != nil {
+				return err
+			}
+			if _, found := ips[addr]; found {
+				return fmt.Errorf("duplicate resolver specified: %v", r)
+			}
+			ips[addr] = struct{}{}
+			continue
+		}
 		ip := net.ParseIP(r)
 		if ip == nil {
 			return fmt.Errorf("illegal IP specified for resolver %q", r)
@@ -82,3 +101,343 @@ func validateIPSources(srcs []string) error {
 
 	return nil
 }
+
+// validateCIDRs checks that each network in the list is in valid CIDR notation.
+// returns nil if the list is empty, or else all networks in the list are valid.
+func validateCIDRs(cidrs []string) error {
+	for _, cidr := range cidrs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("illegal CIDR specified %q", cidr)
+		}
+	}
+	return nil
+}
+
+// validateSecondaries checks that each secondary in the list is a properly
+// formatted IP address, with an optional port, i.e. 1.2.3.4 or [::1]:5353.
+// duplicate secondaries in the list are not allowed.
+func validateSecondaries(ss []string) error {
+	valid := make(map[string]struct{}, len(ss))
+	for _, s := range ss {
+		addr, err := SecondaryAddr(s)
+		if err != nil {
+			return err
+		}
+		if _, found := valid[addr]; found {
+			return fmt.Errorf("duplicate secondary specified: %v", s)
+		}
+		valid[addr] = struct{}{}
+	}
+	return nil
+}
+
+// SecondaryAddr returns the normalized host:port address of the given
+// secondary DNS server, defaulting to port 53.
+func SecondaryAddr(s string) (string, error) {
+	return serverAddr(s, "secondary")
+}
+
+// ResolverAddr returns the normalized host:port address of the given
+// upstream resolver, defaulting to port 53, or the normalized URL of an
+// encrypted one: tls://host[:port][#name] or https://host[:port]/path[#name],
+// whose certificate is verified for name, or host if not given.
+func ResolverAddr(s string) (string, error) {
+	if strings.Contains(s, "://") {
+		return encryptedResolverAddr(s)
+	}
+	return serverAddr(s, "resolver")
+}
+
+// encryptedResolverAddr returns the normalized URL of the given encrypted
+// upstream resolver, defaulting to port 853 for DNS-over-TLS.
+func encryptedResolverAddr(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("illegal URL specified for resolver %q: %v", s, err)
+	}
+	switch {
+	case u.Scheme != "tls" && u.Scheme != "https":
+		return "", fmt.Errorf("unsupported scheme specified for resolver %q", s)
+	case u.User != nil || u.RawQuery != "" || (u.Scheme == "tls" && u.Path != ""):
+		return "", fmt.Errorf("illegal URL specified for resolver %q", s)
+	}
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		host, port = strings.Trim(u.Host, "[]"), ""
+	}
+	if _, ok := dns.IsDomainName(host); host == "" || (!ok && net.ParseIP(host) == nil) {
+		return "", fmt.Errorf("illegal host specified for resolver %q", s)
+	}
+	if port == "" && u.Scheme == "tls" {
+		port = "853"
+	}
+	if port != "" {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return "", fmt.Errorf("illegal port specified for resolver %q", s)
+		}
+		u.Host = net.JoinHostPort(strings.ToLower(host), port)
+	} else {
+		u.Host = strings.ToLower(u.Host)
+	}
+	return u.String(), nil
+}
+
+// serverAddr returns the normalized host:port address of the given DNS
+// server, given as an IP address with an optional port, defaulting to 53.
+func serverAddr(s, kind string) (string, error) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		host, port = s, "53"
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", fmt.Errorf("illegal IP specified for %s %q", kind, s)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", fmt.Errorf("illegal port specified for %s %q", kind, s)
+	}
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+// validateForwardZones checks that each forwarded zone is a unique domain outside of the Mesos domain with valid, unique resolvers and settings.
+func validateForwardZones(zones map[string]ForwardZone, domain string) error {
+	valid := make(map[string]struct{}, len(zones))
+	for zone, z := range zones {
+		name := strings.ToLower(dns.Fqdn(zone))
+		if _, ok := dns.IsDomainName(name); !ok || name == "." {
+			return fmt.Errorf("illegal zone specified %q", zone)
+		}
+		if dns.IsSubDomain(strings.ToLower(dns.Fqdn(domain)), name) {
+			return fmt.Errorf("zone %q is in the Mesos domain", zone)
+		}
+		if _, found := valid[name]; found {
+			return fmt.Errorf("duplicate zone specified: %v", zone)
+		}
+		valid[name] = struct{}{}
+
+		if len(z.Resolvers) == 0 {
+			return fmt.Errorf("no resolvers specified for zone %q", zone)
+		}
+		addrs := make(map[string]struct{}, len(z.Resolvers))
+		for _, r := range z.Resolvers {
+			addr, err := ResolverAddr(r)
+			if err != nil {
+				return err
+			}
+			if _, found := addrs[addr]; found {
+				return fmt.Errorf("duplicate resolver specified for zone %q: %v", zone, r)
+			}
+			addrs[addr] = struct{}{}
+		}
+		switch z.Protocol {
+		case "", "udp", "tcp":
+		default:
+			return fmt.Errorf("invalid protocol %q specified for zone %q", z.Protocol, zone)
+		}
+		if z.Timeout < 0 {
+			return fmt.Errorf("negative timeout specified for zone %q", zone)
+		}
+		if z.Strategy != "" {
+			if _, err := exchanger.ParseStrategy(z.Strategy); err != nil {
+				return fmt.Errorf("%v specified for zone %q", err, zone)
+			}
+		}
+	}
+	return nil
+}
+
+// validateForwarding checks that the forwarding strategy is known and that
+// the circuit breaker of the upstream resolvers is given non-negative values.
+func validateForwarding(c *Config) error {
+	if _, err := exchanger.ParseStrategy(c.ForwardStrategy); err != nil {
+		return err
+	}
+	if c.ForwardBreakerFailures < 0 {
+		return fmt.Errorf("negative ForwardBreakerFailures specified: %d", c.ForwardBreakerFailures)
+	}
+	if c.ForwardBreakerCooldownSeconds < 0 {
+		return fmt.Errorf("negative ForwardBreakerCooldownSeconds specified: %d", c.ForwardBreakerCooldownSeconds)
+	}
+	return nil
+}
+
+// validateMesosAuth checks that at most one way to authenticate to the Mesos
+// masters is configured, only over HTTPS, and that client certificates come
+// with their key.
+func validateMesosAuth(c *Config) error {
+	var auths []string
+	if c.MesosCredentials != (Credentials{}) {
+		auths = append(auths, "MesosCredentials")
+	}
+	if c.MesosAuthTokenFile != "" {
+		auths = append(auths, "MesosAuthTokenFile")
+	}
+	if c.IAMConfigFile != "" {
+		auths = append(auths, "IAMConfigFile")
+	}
+	if len(auths) > 1 {
+		return fmt.Errorf("%s are mutually exclusive", strings.Join(auths, " and "))
+	}
+	if len(auths) == 1 && !c.MesosHTTPSOn {
+		return fmt.Errorf("%s requires MesosHTTPSOn", auths[0])
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return fmt.Errorf("CertFile and KeyFile must be specified together")
+	}
+	return nil
+}
+
+// validateEncryptedListeners checks that the DNS-over-TLS listener is given a
+// certificate and a valid port, that DNS-over-HTTPS is served by the HTTP
+// server over HTTPS, and that certificates come with their key.
+func validateEncryptedListeners(c *Config) error {
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("TLSCertFile and TLSKeyFile must be specified together")
+	}
+	if (c.HTTPCertFile == "") != (c.HTTPKeyFile == "") {
+		return fmt.Errorf("HTTPCertFile and HTTPKeyFile must be specified together")
+	}
+	if c.DoTOn {
+		if !c.DNSOn {
+			return fmt.Errorf("DoTOn requires DnsOn")
+		}
+		if c.TLSCertFile == "" {
+			return fmt.Errorf("DoTOn requires TLSCertFile and TLSKeyFile")
+		}
+		if c.DoTPort <= 0 || c.DoTPort > 65535 {
+			return fmt.Errorf("illegal DoTPort specified: %d", c.DoTPort)
+		}
+	}
+	if c.DoHOn {
+		if !c.HTTPOn {
+			return fmt.Errorf("DoHOn requires HttpOn")
+		}
+		if c.HTTPCertFile == "" {
+			return fmt.Errorf("DoHOn requires HTTPCertFile and HTTPKeyFile")
+		}
+	}
+	return nil
+}
+
+// validateRateLimits checks that rate limits are non-negative, that the
+// prefix lengths of client networks are valid, and that the exempt networks
+// are valid CIDRs.
+func validateRateLimits(c *Config) error {
+	for _, f := range []struct {
+		name  string
+		value int
+	}{
+		{"RateLimitQPS", c.RateLimitQPS},
+		{"RateLimitBurst", c.RateLimitBurst},
+		{"RRLResponsesPerSecond", c.RRLResponsesPerSecond},
+		{"RRLSlip", c.RRLSlip},
+	} {
+		if f.value < 0 {
+			return fmt.Errorf("negative %s specified: %d", f.name, f.value)
+		}
+	}
+	if c.RateLimitIPv4Prefix < 0 || c.RateLimitIPv4Prefix > 32 {
+		return fmt.Errorf("illegal RateLimitIPv4Prefix specified: %d", c.RateLimitIPv4Prefix)
+	}
+	if c.RateLimitIPv6Prefix < 0 || c.RateLimitIPv6Prefix > 128 {
+		return fmt.Errorf("illegal RateLimitIPv6Prefix specified: %d", c.RateLimitIPv6Prefix)
+	}
+	return validateCIDRs(c.RateLimitExemptCIDRs)
+}
+
+// validateViews checks that each view has a unique name, at least one client
+// network, all of them valid CIDRs, valid subdomains and valid IP sources, if
+// any.
+func validateViews(views []View) error {
+	names := make(map[string]struct{}, len(views))
+	for _, v := range views {
+		if v.Name == "" {
+			return fmt.Errorf("unnamed view specified")
+		}
+		if _, found := names[v.Name]; found {
+			return fmt.Errorf("duplicate view specified: %v", v.Name)
+		}
+		names[v.Name] = struct{}{}
+
+		if len(v.CIDRs) == 0 {
+			return fmt.Errorf("no CIDRs specified for view %q", v.Name)
+		}
+		if err := validateCIDRs(v.CIDRs); err != nil {
+			return fmt.Errorf("%v for view %q", err, v.Name)
+		}
+		for _, s := range v.Subdomains {
+			if _, ok := dns.IsDomainName(s); !ok || s == "" || strings.HasPrefix(s, ".") || strings.HasSuffix(s, ".") {
+				return fmt.Errorf("illegal subdomain %q specified for view %q", s, v.Name)
+			}
+		}
+		if len(v.IPSources) > 0 {
+			if err := validateIPSources(v.IPSources); err != nil {
+				return fmt.Errorf("%v for view %q", err, v.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// validateQueryLog checks that the sample rate of the query log is a fraction
+// and that its rotation is given non-negative values.
+func validateQueryLog(c *Config) error {
+	if c.QueryLogSampleRate < 0 || c.QueryLogSampleRate > 1 {
+		return fmt.Errorf("illegal QueryLogSampleRate specified: %v", c.QueryLogSampleRate)
+	}
+	if c.QueryLogMaxSizeMB < 0 {
+		return fmt.Errorf("negative QueryLogMaxSizeMB specified: %d", c.QueryLogMaxSizeMB)
+	}
+	if c.QueryLogMaxBackups < 0 {
+		return fmt.Errorf("negative QueryLogMaxBackups specified: %d", c.QueryLogMaxBackups)
+	}
+	return nil
+}
+
+// validateLogging checks that the log level and format are known ones.
+func validateLogging(c *Config) error {
+	if c.LogLevel != "" {
+		if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+			return fmt.Errorf("illegal LogLevel specified: %q", c.LogLevel)
+		}
+	}
+	switch c.LogFormat {
+	case logging.TextFormat, logging.JSONFormat:
+		return nil
+	}
+	return fmt.Errorf("illegal LogFormat specified: %q", c.LogFormat)
+}
+
+// ValidateReload checks that reloading the configuration prev with next
+// doesn't change any field which can't be changed without a restart: the
+// listening addresses, the enabled services, whether the HTTP server serves
+// HTTPS, the served domain and the initial master detection timeout.
+func ValidateReload(prev, next Config) error {
+	var changed []string
+	for _, f := range []struct {
+		name       string
+		prev, next interface{}
+	}{
+		{"Listener", prev.Listener, next.Listener},
+		{"Port", prev.Port, next.Port},
+		{"HttpPort", prev.HTTPPort, next.HTTPPort},
+		{"DnsOn", prev.DNSOn, next.DNSOn},
+		{"HttpOn", prev.HTTPOn, next.HTTPOn},
+		{"DoTOn", prev.DoTOn, next.DoTOn},
+		{"DoTPort", prev.DoTPort, next.DoTPort},
+		{"DoHOn", prev.DoHOn, next.DoHOn},
+		{"TLSCertFile presence", prev.TLSCertFile != "", next.TLSCertFile != ""},
+		{"HTTPCertFile presence", prev.HTTPCertFile != "", next.HTTPCertFile != ""},
+		{"EnumerationOn", prev.EnumerationOn, next.EnumerationOn},
+		{"Domain", prev.Domain, next.Domain},
+		{"ZkDetectionTimeout", prev.ZkDetectionTimeout, next.ZkDetectionTimeout},
+	} {
+		if f.prev != f.next {
+			changed = append(changed, fmt.Sprintf("%s from %v to %v", f.name, f.prev, f.next))
+		}
+	}
+	if len(changed) > 0 {
+		return fmt.Errorf("can't change %s without a restart", strings.Join(changed, ", "))
+	}
+	return nil
+}
@@ -44,11 +44,318 @@ func TestValidateResolvers(t *testing.T) {
 		{[]string{"2001:0db8:3c4d:0015:0000:0000:1a2f:1a2b"}, true},
 		{[]string{"2001:db8:3c4d:15::1a2f:1a2b"}, true},
 		{[]string{"2001:0db8:3c4d:0015:0000:0000:1a2f:1a2b", "2001:db8:3c4d:15::1a2f:1a2b"}, false},
+		{[]string{"tls://1.1.1.1#cloudflare-dns.com", "https://dns.google/dns-query"}, true},
+		{[]string{"1.1.1.1", "tls://1.1.1.1", "tls://[2606:4700::1111]:853"}, true},
+		{[]string{"tls://1.1.1.1", "TLS://1.1.1.1:853"}, false},
+		{[]string{"https://dns.google/dns-query", "https://DNS.google/dns-query"}, false},
+		{[]string{"tls://1.1.1.1/dns-query"}, false},
+		{[]string{"tls://1.1.1.1:dot"}, false},
+		{[]string{"https://user@dns.google/dns-query"}, false},
+		{[]string{"http://dns.google/dns-query"}, false},
+		{[]string{"tls://"}, false},
 	} {
 		validate(t, i+1, tc, validateResolvers)
 	}
 }
 
+func TestValidateCIDRs(t *testing.T) {
+	for i, tc := range []validationTest{
+		{nil, true},
+		{[]string{}, true},
+		{[]string{""}, false},
+		{[]string{"1.2.3.4"}, false},
+		{[]string{"1.2.3.0/24"}, true},
+		{[]string{"1.2.3.0/33"}, false},
+		{[]string{"10.0.0.0/8", "fd00::/8"}, true},
+		{[]string{"10.0.0.0/8", "a"}, false},
+	} {
+		validate(t, i+1, tc, validateCIDRs)
+	}
+}
+
+func TestValidateSecondaries(t *testing.T) {
+	for i, tc := range []validationTest{
+		{nil, true},
+		{[]string{}, true},
+		{[]string{""}, false},
+		{[]string{"a"}, false},
+		{[]string{"1.2.3.4"}, true},
+		{[]string{"1.2.3.4:5353"}, true},
+		{[]string{"1.2.3.4:port"}, false},
+		{[]string{"1.2.3.4", "1.2.3.4:53"}, false},
+		{[]string{"1.2.3.4", "1.2.3.4:5353"}, true},
+		{[]string{"2001:db8::1", "[2001:db8::1]:5353"}, true},
+	} {
+		validate(t, i+1, tc, validateSecondaries)
+	}
+}
+
+func TestValidateForwardZones(t *testing.T) {
+	for i, tt := range []struct {
+		zones map[string]ForwardZone
+		valid bool
+	}{
+		{nil, true},
+		{map[string]ForwardZone{}, true},
+		{map[string]ForwardZone{"consul": {Resolvers: []string{"127.0.0.1:8600"}}}, true},
+		{map[string]ForwardZone{"corp.example.com.": {
+			Resolvers: []string{"10.0.0.53", "[fd00::53]:53"},
+			Protocol:  "tcp",
+			Timeout:   2,
+		}}, true},
+		{map[string]ForwardZone{"10.in-addr.arpa": {Resolvers: []string{"10.0.0.53"}}}, true},
+		{map[string]ForwardZone{"consul": {}}, false},
+		{map[string]ForwardZone{"consul": {Resolvers: []string{"consul.service"}}}, false},
+		{map[string]ForwardZone{"consul": {Resolvers: []string{"127.0.0.1", "127.0.0.1:53"}}}, false},
+		{map[string]ForwardZone{"consul": {Resolvers: []string{"127.0.0.1"}, Protocol: "tls"}}, false},
+		{map[string]ForwardZone{"consul": {Resolvers: []string{"127.0.0.1"}, Timeout: -1}}, false},
+		{map[string]ForwardZone{"consul": {Resolvers: []string{"127.0.0.1"}, Strategy: "fastest"}}, true},
+		{map[string]ForwardZone{"consul": {Resolvers: []string{"127.0.0.1"}, Strategy: "fast"}}, false},
+		{map[string]ForwardZone{"corp.example.com": {Resolvers: []string{"tls://10.0.0.53#dns.corp.example.com"}}}, true},
+		{map[string]ForwardZone{"corp.example.com": {Resolvers: []string{"tls://10.0.0.53", "tls://10.0.0.53:853"}}}, false},
+		{map[string]ForwardZone{".": {Resolvers: []string{"127.0.0.1"}}}, false},
+		{map[string]ForwardZone{"": {Resolvers: []string{"127.0.0.1"}}}, false},
+		{map[string]ForwardZone{"mesos": {Resolvers: []string{"127.0.0.1"}}}, false},
+		{map[string]ForwardZone{"marathon.Mesos.": {Resolvers: []string{"127.0.0.1"}}}, false},
+		{map[string]ForwardZone{
+			"consul":  {Resolvers: []string{"127.0.0.1"}},
+			"Consul.": {Resolvers: []string{"127.0.0.1"}},
+		}, false},
+	} {
+		if err := validateForwardZones(tt.zones, "mesos"); (err == nil) != tt.valid {
+			t.Errorf("test #%d: got error %v, want valid %t", i, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateForwarding(t *testing.T) {
+	for i, tt := range []struct {
+		change func(*Config)
+		valid  bool
+	}{
+		{func(*Config) {}, true},
+		{func(c *Config) { c.ForwardStrategy = "round_robin" }, true},
+		{func(c *Config) { c.ForwardStrategy = "parallel" }, true},
+		{func(c *Config) { c.ForwardBreakerFailures = 0 }, true},
+		{func(c *Config) { c.ForwardStrategy = "" }, false},
+		{func(c *Config) { c.ForwardStrategy = "Random" }, false},
+		{func(c *Config) { c.ForwardBreakerFailures = -1 }, false},
+		{func(c *Config) { c.ForwardBreakerCooldownSeconds = -1 }, false},
+	} {
+		c := NewConfig()
+		tt.change(&c)
+		if err := validateForwarding(&c); (err == nil) != tt.valid {
+			t.Errorf("test #%d: got error %v, want valid %t", i, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateMesosAuth(t *testing.T) {
+	for i, tt := range []struct {
+		change func(*Config)
+		valid  bool
+	}{
+		{func(*Config) {}, true},
+		{func(c *Config) {
+			c.MesosHTTPSOn = true
+			c.MesosCredentials = Credentials{"mesos-dns", "secret"}
+		}, true},
+		{func(c *Config) {
+			c.MesosHTTPSOn = true
+			c.MesosAuthTokenFile = "/etc/mesos-dns/token"
+		}, true},
+		{func(c *Config) {
+			c.MesosHTTPSOn = true
+			c.IAMConfigFile = "/etc/mesos-dns/iam.json"
+		}, true},
+		{func(c *Config) { c.MesosCredentials = Credentials{"mesos-dns", "secret"} }, false},
+		{func(c *Config) { c.MesosAuthTokenFile = "/etc/mesos-dns/token" }, false},
+		{func(c *Config) { c.IAMConfigFile = "/etc/mesos-dns/iam.json" }, false},
+		{func(c *Config) { c.CertFile, c.KeyFile = "cert.pem", "key.pem" }, true},
+		{func(c *Config) { c.CertFile = "cert.pem" }, false},
+		{func(c *Config) { c.KeyFile = "key.pem" }, false},
+		{func(c *Config) {
+			c.MesosCredentials = Credentials{"mesos-dns", "secret"}
+			c.MesosAuthTokenFile = "/etc/mesos-dns/token"
+		}, false},
+		{func(c *Config) {
+			c.MesosAuthTokenFile = "/etc/mesos-dns/token"
+			c.IAMConfigFile = "/etc/mesos-dns/iam.json"
+		}, false},
+	} {
+		c := NewConfig()
+		tt.change(&c)
+		if err := validateMesosAuth(&c); (err == nil) != tt.valid {
+			t.Errorf("test #%d: got error %v, want valid %t", i, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateEncryptedListeners(t *testing.T) {
+	for i, tt := range []struct {
+		change func(*Config)
+		valid  bool
+	}{
+		{func(*Config) {}, true},
+		{func(c *Config) { c.DoHOn, c.HTTPCertFile, c.HTTPKeyFile = true, "cert.pem", "key.pem" }, true},
+		{func(c *Config) { c.DoHOn = true }, false},
+		{func(c *Config) { c.DoHOn, c.TLSCertFile, c.TLSKeyFile = true, "cert.pem", "key.pem" }, false},
+		{func(c *Config) { c.HTTPCertFile, c.HTTPKeyFile = "cert.pem", "key.pem" }, true},
+		{func(c *Config) { c.HTTPCertFile = "cert.pem" }, false},
+		{func(c *Config) { c.TLSCertFile, c.TLSKeyFile = "cert.pem", "key.pem" }, true},
+		{func(c *Config) { c.DoTOn, c.TLSCertFile, c.TLSKeyFile = true, "cert.pem", "key.pem" }, true},
+		{func(c *Config) { c.TLSCertFile = "cert.pem" }, false},
+		{func(c *Config) { c.TLSKeyFile = "key.pem" }, false},
+		{func(c *Config) { c.DoTOn = true }, false},
+		{func(c *Config) {
+			c.DoTOn, c.TLSCertFile, c.TLSKeyFile = true, "cert.pem", "key.pem"
+			c.DoTPort = 0
+		}, false},
+		{func(c *Config) {
+			c.DoTOn, c.TLSCertFile, c.TLSKeyFile = true, "cert.pem", "key.pem"
+			c.DNSOn = false
+		}, false},
+		{func(c *Config) {
+			c.DoHOn, c.HTTPCertFile, c.HTTPKeyFile = true, "cert.pem", "key.pem"
+			c.HTTPOn = false
+		}, false},
+	} {
+		c := NewConfig()
+		tt.change(&c)
+		if err := validateEncryptedListeners(&c); (err == nil) != tt.valid {
+			t.Errorf("test #%d: got error %v, want valid %t", i, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateRateLimits(t *testing.T) {
+	for i, tt := range []struct {
+		change func(*Config)
+		valid  bool
+	}{
+		{func(*Config) {}, true},
+		{func(c *Config) { c.RateLimitQPS, c.RateLimitBurst = 100, 200 }, true},
+		{func(c *Config) { c.RRLResponsesPerSecond, c.RRLSlip = 5, 0 }, true},
+		{func(c *Config) { c.RateLimitIPv4Prefix, c.RateLimitIPv6Prefix = 32, 128 }, true},
+		{func(c *Config) { c.RateLimitExemptCIDRs = []string{"10.0.0.0/8", "fd00::/8"} }, true},
+		{func(c *Config) { c.RateLimitQPS = -1 }, false},
+		{func(c *Config) { c.RateLimitBurst = -1 }, false},
+		{func(c *Config) { c.RRLResponsesPerSecond = -1 }, false},
+		{func(c *Config) { c.RRLSlip = -1 }, false},
+		{func(c *Config) { c.RateLimitIPv4Prefix = 33 }, false},
+		{func(c *Config) { c.RateLimitIPv6Prefix = -1 }, false},
+		{func(c *Config) { c.RateLimitExemptCIDRs = []string{"10.0.0.1"} }, false},
+	} {
+		c := NewConfig()
+		tt.change(&c)
+		if err := validateRateLimits(&c); (err == nil) != tt.valid {
+			t.Errorf("test #%d: got error %v, want valid %t", i, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateViews(t *testing.T) {
+	view := func(change func(*View)) []View {
+		v := View{Name: "tenant", CIDRs: []string{"10.1.0.0/16"}}
+		change(&v)
+		return []View{v}
+	}
+	for i, tt := range []struct {
+		views []View
+		valid bool
+	}{
+		{nil, true},
+		{view(func(*View) {}), true},
+		{view(func(v *View) { v.Subdomains = []string{"marathon", "app.marathon"} }), true},
+		{view(func(v *View) { v.IPSources = []string{"netinfo", "host"} }), true},
+		{append(view(func(*View) {}), View{Name: "ops", CIDRs: []string{"0.0.0.0/0", "::/0"}}), true},
+		{view(func(v *View) { v.Name = "" }), false},
+		{append(view(func(*View) {}), view(func(*View) {})...), false},
+		{view(func(v *View) { v.CIDRs = nil }), false},
+		{view(func(v *View) { v.CIDRs = []string{"10.1.0.1"} }), false},
+		{view(func(v *View) { v.Subdomains = []string{""} }), false},
+		{view(func(v *View) { v.Subdomains = []string{"marathon."} }), false},
+		{view(func(v *View) { v.IPSources = []string{"bogus"} }), false},
+		{view(func(v *View) { v.IPSources = []string{"host", "host"} }), false},
+	} {
+		if err := validateViews(tt.views); (err == nil) != tt.valid {
+			t.Errorf("test #%d: got error %v, want valid %t", i, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateQueryLog(t *testing.T) {
+	for i, tt := range []struct {
+		change func(*Config)
+		valid  bool
+	}{
+		{func(*Config) {}, true},
+		{func(c *Config) { c.QueryLogOn, c.QueryLogFile = true, "/var/log/mesos-dns/queries.log" }, true},
+		{func(c *Config) { c.QueryLogSampleRate = 0 }, true},
+		{func(c *Config) { c.QueryLogSampleRate = 0.01 }, true},
+		{func(c *Config) { c.QueryLogMaxSizeMB, c.QueryLogMaxBackups = 0, 0 }, true},
+		{func(c *Config) { c.QueryLogSampleRate = -0.5 }, false},
+		{func(c *Config) { c.QueryLogSampleRate = 1.5 }, false},
+		{func(c *Config) { c.QueryLogMaxSizeMB = -1 }, false},
+		{func(c *Config) { c.QueryLogMaxBackups = -1 }, false},
+	} {
+		c := NewConfig()
+		tt.change(&c)
+		if err := validateQueryLog(&c); (err == nil) != tt.valid {
+			t.Errorf("test #%d: got error %v, want valid %t", i, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateLogging(t *testing.T) {
+	for i, tt := range []struct {
+		change func(*Config)
+		valid  bool
+	}{
+		{func(*Config) {}, true},
+		{func(c *Config) { c.LogLevel, c.LogFormat = "debug", "json" }, true},
+		{func(c *Config) { c.LogLevel = "WARN" }, true},
+		{func(c *Config) { c.LogLevel = "verbose" }, false},
+		{func(c *Config) { c.LogFormat = "" }, false},
+		{func(c *Config) { c.LogFormat = "logfmt" }, false},
+	} {
+		c := NewConfig()
+		tt.change(&c)
+		if err := validateLogging(&c); (err == nil) != tt.valid {
+			t.Errorf("test #%d: got error %v, want valid %t", i, err, tt.valid)
+		}
+	}
+}
+
+func TestValidateReload(t *testing.T) {
+	prev := NewConfig()
+	for i, tt := range []struct {
+		change func(*Config)
+		valid  bool
+	}{
+		{func(*Config) {}, true},
+		{func(c *Config) { c.RefreshSeconds, c.TTL, c.Resolvers = 5, 10, []string{"1.1.1.1"} }, true},
+		{func(c *Config) { c.Zk, c.Masters = "zk://1.2.3.4:2181/mesos", nil }, true},
+		{func(c *Config) { c.Port = 5353 }, false},
+		{func(c *Config) { c.Listener = "127.0.0.1" }, false},
+		{func(c *Config) { c.HTTPPort = 8080 }, false},
+		{func(c *Config) { c.DNSOn = false }, false},
+		{func(c *Config) { c.EnumerationOn = false }, false},
+		{func(c *Config) { c.Domain = "dcos" }, false},
+		{func(c *Config) { c.ZkDetectionTimeout = 0 }, false},
+		{func(c *Config) { c.DoTOn = true }, false},
+		{func(c *Config) { c.DoHOn = true }, false},
+		{func(c *Config) { c.TLSCertFile, c.TLSKeyFile = "cert.pem", "key.pem" }, false},
+		{func(c *Config) { c.HTTPCertFile, c.HTTPKeyFile = "cert.pem", "key.pem" }, false},
+	} {
+		next := NewConfig()
+		tt.change(&next)
+		if err := ValidateReload(prev, next); (err == nil) != tt.valid {
+			t.Errorf("test #%d: got error %v, want valid %t", i, err, tt.valid)
+		}
+	}
+}
+
 type validationTest struct {
 	in    []string
 	valid bool
@@ -0,0 +1,121 @@
+package records
+
+import (
+	"strings"
+
+	"github.com/mesosphere/mesos-dns/records/labels"
+	"github.com/mesosphere/mesos-dns/records/state"
+)
+
+// viewRecords returns the records of the given view of sj, generated as by
+// InsertState with the IPSources of the view, if any, and restricted to its
+// Subdomains, if any. It returns nil if the view is served the records of rg.
+func (rg *RecordGenerator) viewRecords(sj state.State, c Config, v View, masters []string, spec labels.Func) *RecordGenerator {
+	if len(v.Subdomains) == 0 && len(v.IPSources) == 0 {
+		return nil
+	}
+	ipSources := c.IPSources
+	if len(v.IPSources) > 0 {
+		ipSources = v.IPSources
+	}
+	vrg := &RecordGenerator{static: rg.static}
+	_ = vrg.InsertState(sj, c.Domain, c.SOARname, c.Listener, masters, ipSources, c.TXTLabels, spec)
+	if len(v.Subdomains) > 0 {
+		vrg.restrict(visibleIn(v, c.Domain), c.Domain, spec)
+	}
+	return vrg
+}
+
+// visibleIn returns a function reporting whether a qualified name is visible in
+// the given view of the given Mesos domain: whether it's in one of the
+// Subdomains of the view, or in the slave subdomain of one of them. All the
+// names are visible in a view without Subdomains.
+func visibleIn(v View, domain string) func(name string) bool {
+	if len(v.Subdomains) == 0 {
+		return func(string) bool { return true }
+	}
+	tail := "." + strings.ToLower(domain) + "."
+	suffixes := make([]string, 0, 2*len(v.Subdomains))
+	for _, s := range v.Subdomains {
+		s = "." + strings.ToLower(s)
+		suffixes = append(suffixes, s+tail, s+".slave"+tail)
+	}
+	return func(name string) bool {
+		name = "." + strings.ToLower(name)
+		for _, suffix := range suffixes {
+			if strings.HasSuffix(name, suffix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// restrict removes the records of rg whose names aren't visible, the PTR
+// records pointing at them, and their enumeration. Frameworks are enumerated
+// as long as their own name or any of their tasks is visible.
+func (rg *RecordGenerator) restrict(visible func(string) bool, domain string, spec labels.Func) {
+	for _, kind := range []rrsKind{A, AAAA, CNAME, SRV, TXT} {
+		for name := range kind.rrs(rg) {
+			if !visible(name) {
+				delete(kind.rrs(rg), name)
+			}
+		}
+	}
+	for name := range rg.SRVPreferences {
+		if !visible(name) {
+			delete(rg.SRVPreferences, name)
+		}
+	}
+	for name, targets := range rg.PTRs {
+		for target := range targets {
+			if !visible(target) {
+				delete(targets, target)
+			}
+		}
+		if len(targets) == 0 {
+			delete(rg.PTRs, name)
+		}
+	}
+	for host := range rg.HostPorts {
+		if !visible(host) {
+			delete(rg.HostPorts, host)
+		}
+	}
+
+	frameworks := rg.EnumData.Frameworks[:0]
+	for _, f := range rg.EnumData.Frameworks {
+		tasks := f.Tasks[:0]
+		for _, t := range f.Tasks {
+			if t.Records = visibleRecords(t.Records, visible); len(t.Records) > 0 {
+				tasks = append(tasks, t)
+			}
+		}
+		f.Tasks = tasks
+		if len(tasks) > 0 || visible(labels.DomainFrag(f.Name, labels.Sep, spec)+"."+domain+".") {
+			frameworks = append(frameworks, f)
+		}
+	}
+	rg.EnumData.Frameworks = frameworks
+	rg.EnumData.Static = visibleRecords(rg.EnumData.Static, visible)
+
+	conflicts := rg.EnumData.Conflicts[:0]
+	for _, c := range rg.EnumData.Conflicts {
+		if visible(c.Name) {
+			conflicts = append(conflicts, c)
+		}
+	}
+	rg.EnumData.Conflicts = conflicts
+}
+
+// visibleRecords filters the given enumerated records in place, keeping the
+// visible ones.
+func visibleRecords(records []EnumerableRecord, visible func(string) bool) []EnumerableRecord {
+	kept := records[:0]
+	for _, r := range records {
+		if visible(r.Name) {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
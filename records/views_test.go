@@ -0,0 +1,114 @@
+package records
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestConvertState_views(t *testing.T) {
+	sj := fakeState(t)
+	sj.Leader = "master@144.76.157.37:5050"
+
+	c := NewConfig()
+	c.Listener = "127.0.0.1"
+	c.EnforceRFC952 = true
+	c.IPSources = []string{"docker", "mesos", "host"}
+	c.Views = []View{
+		{Name: "tenant", CIDRs: []string{"10.1.0.0/16"}, Subdomains: []string{"Marathon"}, IPSources: []string{"host"}},
+		{Name: "external", CIDRs: []string{"0.0.0.0/0"}, IPSources: []string{"host"}},
+		{Name: "ops", CIDRs: []string{"10.0.0.0/16"}},
+	}
+
+	var rg RecordGenerator
+	if err := rg.ConvertState(sj, c, "144.76.157.37:5050"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rg.Views["ops"]; ok {
+		t.Error("got records for a view served the default ones")
+	}
+	tenant, external := rg.Views["tenant"], rg.Views["external"]
+	if tenant == nil || external == nil {
+		t.Fatalf("got views %v, want tenant and external", rg.Views)
+	}
+
+	for i, tt := range []struct {
+		rrs  rrs
+		name string
+		want []string
+	}{
+		{rg.As, "liquor-store.marathon.mesos.", []string{"10.3.0.1", "10.3.0.2"}},
+		{rg.As, "leader.mesos.", []string{"144.76.157.37"}},
+		{external.As, "liquor-store.marathon.mesos.", []string{"1.2.3.11", "1.2.3.12"}},
+		{external.As, "leader.mesos.", []string{"144.76.157.37"}},
+		{tenant.As, "liquor-store.marathon.mesos.", []string{"1.2.3.11", "1.2.3.12"}},
+		{tenant.As, "liquor-store.marathon.slave.mesos.", []string{"1.2.3.11", "1.2.3.12"}},
+		{tenant.As, "marathon.mesos.", []string{"1.2.3.11"}},
+		{tenant.As, "leader.mesos.", nil},
+		{tenant.As, "slave.mesos.", nil},
+		{tenant.As, "some-box.chronoswithaspaceandmixe.mesos.", nil},
+		{tenant.SRVs, "_liquor-store._tcp.marathon.mesos.", []string{
+			"liquor-store-4dfjd-0.marathon.mesos.:443",
+			"liquor-store-4dfjd-0.marathon.mesos.:80",
+			"liquor-store-zasmd-1.marathon.mesos.:443",
+			"liquor-store-zasmd-1.marathon.mesos.:80",
+		}},
+		{tenant.SRVs, "_leader._tcp.mesos.", nil},
+		{tenant.PTRs, "11.3.2.1.in-addr.arpa.", nil},
+		{tenant.PTRs, "37.157.76.144.in-addr.arpa.", nil},
+	} {
+		var got []string
+		for host := range tt.rrs[tt.name] {
+			got = append(got, host)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("test #%d: %q: got %q, want %q", i, tt.name, got, tt.want)
+		}
+	}
+
+	for _, f := range tenant.EnumData.Frameworks {
+		if f.Name != "marathon" {
+			t.Errorf("got framework %q enumerated in the tenant view", f.Name)
+		}
+		for _, task := range f.Tasks {
+			for _, r := range task.Records {
+				if !visibleIn(c.Views[0], c.Domain)(r.Name) {
+					t.Errorf("got record %q of task %q enumerated in the tenant view", r.Name, task.ID)
+				}
+			}
+		}
+	}
+	if len(tenant.EnumData.Frameworks) != 1 || len(rg.EnumData.Frameworks) <= 1 {
+		t.Errorf("got %d frameworks enumerated in the tenant view out of %d, want only marathon",
+			len(tenant.EnumData.Frameworks), len(rg.EnumData.Frameworks))
+	}
+}
+
+func TestVisibleIn(t *testing.T) {
+	v := View{Subdomains: []string{"marathon", "api.payments"}}
+	for i, tt := range []struct {
+		name    string
+		visible bool
+	}{
+		{"marathon.mesos.", true},
+		{"web.marathon.mesos.", true},
+		{"WEB.Marathon.mesos.", true},
+		{"web-a1b2c-s1.marathon.slave.mesos.", true},
+		{"_web._tcp.marathon.mesos.", true},
+		{"api.payments.mesos.", true},
+		{"v1.api.payments.mesos.", true},
+		{"payments.mesos.", false},
+		{"xmarathon.mesos.", false},
+		{"marathon.other.", false},
+		{"leader.mesos.", false},
+		{"mesos.", false},
+	} {
+		if got := visibleIn(v, "mesos")(tt.name); got != tt.visible {
+			t.Errorf("test #%d: %q: got visible %t, want %t", i, tt.name, got, tt.visible)
+		}
+	}
+	if !visibleIn(View{}, "mesos")("leader.mesos.") {
+		t.Error("got a name hidden in a view without subdomains")
+	}
+}
@@ -0,0 +1,127 @@
+package resolver
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/emicklei/go-restful"
+	"github.com/mesosphere/mesos-dns/records"
+)
+
+// cidrs is a list of IP networks.
+type cidrs []*net.IPNet
+
+// parseCIDRs returns the cidrs parsed from the given CIDR notation strings.
+// Invalid networks are skipped since they're rejected by config validation.
+func parseCIDRs(ss []string) cidrs {
+	nets := make(cidrs, 0, len(ss))
+	for _, s := range ss {
+		if _, n, err := net.ParseCIDR(s); err == nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}
+
+// contains returns true if any of the networks contains the given IP.
+func (cs cidrs) contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range cs {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// addrIP returns the IP of the given net.Addr, or nil if it has none.
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.TCPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+	if addr == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	return net.ParseIP(host)
+}
+
+// view is a records.View along with its client networks.
+type view struct {
+	records.View
+	nets cidrs
+}
+
+// parseViews returns the views of the given configured ones.
+func parseViews(vs []records.View) []view {
+	views := make([]view, len(vs))
+	for i, v := range vs {
+		views[i] = view{View: v, nets: parseCIDRs(v.CIDRs)}
+	}
+	return views
+}
+
+// view returns the first view whose networks contain the given client IP, or
+// nil if there's none, in which case the client is served all the records.
+func (l *liveConfig) view(ip net.IP) *view {
+	for i := range l.views {
+		if l.views[i].nets.contains(ip) {
+			return &l.views[i]
+		}
+	}
+	return nil
+}
+
+// forwarding returns true if the non-Mesos queries of the given client IP
+// may be forwarded.
+func (l *liveConfig) forwarding(ip net.IP) bool {
+	v := l.view(ip)
+	return v == nil || v.ExternalOn
+}
+
+// admin returns true if the given client IP may use the admin HTTP endpoints:
+// if it's in AdminCIDRs or, if there are none, in no view.
+func (l *liveConfig) admin(ip net.IP) bool {
+	if len(l.adminNets) > 0 {
+		return l.adminNets.contains(ip)
+	}
+	return len(l.views) == 0 || ip != nil && l.view(ip) == nil
+}
+
+// adminOnly is a restful.FilterFunction rejecting the HTTP requests of the
+// clients which may not use the admin endpoints with 403 Forbidden.
+func (res *Resolver) adminOnly(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	if !res.cfg().admin(httpAddr(req.Request.RemoteAddr).IP) {
+		_ = resp.WriteErrorString(http.StatusForbidden, "forbidden")
+		return
+	}
+	chain.ProcessFilter(req, resp)
+}
+
+// clientRecords returns the records served to the given client IP: those of
+// its view, if any. Until the records of a view restricted to some subdomains
+// are generated, e.g. right after a config reload, none are served.
+func (res *Resolver) clientRecords(ip net.IP) *records.RecordGenerator {
+	rs := res.records()
+	v := res.cfg().view(ip)
+	if v == nil {
+		return rs
+	}
+	if vrs, ok := rs.Views[v.Name]; ok {
+		return vrs
+	}
+	if len(v.Subdomains) > 0 {
+		return &records.RecordGenerator{}
+	}
+	return rs
+}
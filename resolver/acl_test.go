@@ -0,0 +1,112 @@
+package resolver
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/mesosphere/mesos-dns/dnstest"
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+func TestViews(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := res.cfg()
+	cfg.RecurseOn = true
+	cfg.views = parseViews([]records.View{
+		{Name: "tenant", CIDRs: []string{"10.1.0.0/16"}, Subdomains: []string{"marathon"}},
+		{Name: "ops", CIDRs: []string{"10.2.0.0/16"}, ExternalOn: true},
+	})
+	query := func(remote string, h dns.HandlerFunc) *dns.Msg {
+		rw := ResponseRecorder{Remote: net.IPAddr{IP: net.ParseIP(remote)}}
+		h(&rw, Message(Question("chronos.marathon.mesos.", dns.TypeA)))
+		return rw.Msg
+	}
+
+	// nothing is visible until the records of the view are generated
+	if m := query("10.1.2.3", res.HandleMesos); m.Rcode != dns.RcodeNameError {
+		t.Errorf("got %v, want NXDOMAIN before the view records are generated", m)
+	}
+
+	res.records().Views = map[string]*records.RecordGenerator{
+		"tenant": {
+			As:       map[string]map[string]struct{}{"chronos.marathon.mesos.": {"10.9.9.9": {}}},
+			EnumData: records.EnumerationData{Frameworks: []*records.EnumerableFramework{{Name: "marathon"}}},
+		},
+	}
+	for i, tt := range []struct {
+		remote string
+		want   string
+		ra     bool
+	}{
+		{"10.1.2.3", "10.9.9.9", false},
+		{"10.2.0.1", "1.2.3.11", true},
+		{"10.3.0.1", "1.2.3.11", true},
+	} {
+		m := query(tt.remote, res.HandleMesos)
+		if len(m.Answer) != 1 || m.Answer[0].(*dns.A).A.String() != tt.want {
+			t.Errorf("test #%d: got answers %v, want %s", i, m.Answer, tt.want)
+		}
+		if m.RecursionAvailable != tt.ra {
+			t.Errorf("test #%d: got recursion available %t, want %t", i, m.RecursionAvailable, tt.ra)
+		}
+	}
+
+	if m := query("10.1.2.3", res.HandleNonMesos); m.Rcode != dns.RcodeRefused {
+		t.Errorf("got %v, want forwarding refused to the tenant view", m)
+	}
+
+	req, _ := http.NewRequest("GET", "/v1/enumerate", nil)
+	req.RemoteAddr = "10.1.2.3:1234"
+	rw := httptest.NewRecorder()
+	res.RestEnumerate(restful.NewRequest(req), restful.NewResponse(rw))
+	var got records.EnumerationData
+	if err := json.Unmarshal(rw.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Frameworks) != 1 || got.Frameworks[0].Name != "marathon" {
+		t.Errorf("got frameworks %v enumerated to the tenant view, want marathon only", got.Frameworks)
+	}
+}
+
+func TestAdminOnly(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := res.cfg()
+	for i, tt := range []struct {
+		admin  []string
+		views  []records.View
+		remote string
+		want   int
+	}{
+		{nil, nil, "10.1.2.3:1234", http.StatusOK},
+		{nil, []records.View{{Name: "tenant", CIDRs: []string{"10.1.0.0/16"}}}, "10.1.2.3:1234", http.StatusForbidden},
+		{nil, []records.View{{Name: "tenant", CIDRs: []string{"10.1.0.0/16"}}}, "10.2.0.1:1234", http.StatusOK},
+		{[]string{"10.2.0.0/16"}, nil, "10.1.2.3:1234", http.StatusForbidden},
+		{[]string{"10.2.0.0/16"}, nil, "10.2.0.1:1234", http.StatusOK},
+		{[]string{"10.2.0.0/16"}, []records.View{{Name: "ops", CIDRs: []string{"10.2.0.0/16"}}}, "10.2.0.1:1234", http.StatusOK},
+	} {
+		cfg.adminNets = parseCIDRs(tt.admin)
+		cfg.views = parseViews(tt.views)
+
+		req, _ := http.NewRequest("POST", "/v1/cache/flush", nil)
+		req.RemoteAddr = tt.remote
+		rw := httptest.NewRecorder()
+		chain := restful.FilterChain{Target: func(req *restful.Request, resp *restful.Response) {
+			resp.WriteHeader(http.StatusOK)
+		}}
+		res.adminOnly(restful.NewRequest(req), restful.NewResponse(rw), &chain)
+		if rw.Code != tt.want {
+			t.Errorf("test #%d: got status %d, want %d", i, rw.Code, tt.want)
+		}
+	}
+}
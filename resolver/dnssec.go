@@ -0,0 +1,495 @@
+package resolver
+
+import (
+	"container/list"
+	"crypto"
+	"encoding/base32"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+const (
+	// sigValidity is the validity period of the RRSIGs made by a signer.
+	// Cached signatures are renewed once half of it has elapsed.
+	sigValidity = 7 * 24 * time.Hour
+	// sigSkew is how far back in time the inception of RRSIGs is set, to
+	// tolerate validators with lagging clocks.
+	sigSkew = time.Hour
+	// maxEDNSSize is the largest UDP payload size advertised in signed
+	// responses.
+	maxEDNSSize = 4096
+	// maxCachedSigs is the number of RRsets whose signatures are cached per
+	// record set, the least recently used ones being evicted first.
+	maxCachedSigs = 10000
+)
+
+// dnssecKey is a DNSKEY along with its private key.
+type dnssecKey struct {
+	*dns.DNSKEY
+	priv crypto.Signer
+}
+
+// signer signs the responses of the Mesos domain online, as described in
+// RFC 4470. Denial of existence is proven with minimally covering NSEC or
+// NSEC3 records ("white lies"), which don't allow to walk the zone.
+type signer struct {
+	zone  string
+	ttl   uint32
+	ksks  []dnssecKey
+	zsks  []dnssecKey
+	nsec3 bool
+
+	// maxCaches and maxSigs bound the number of sigCaches, the oldest ones
+	// being evicted first, and the size of each of them
+	maxCaches, maxSigs int
+
+	mu     sync.Mutex
+	caches map[*records.RecordGenerator]*sigCache
+	order  []*records.RecordGenerator // of caches, the oldest first
+}
+
+// sigCache holds the signatures made for the RRsets of a single record set,
+// keyed by the RRsets' text representation.
+type sigCache struct {
+	rs    *records.RecordGenerator
+	types map[string][]uint16 // names of the zone to their RR types
+	lru   *list.List          // of *sigEntry, the most recently used first
+	sigs  map[string]*list.Element
+}
+
+// sigEntry holds the signatures of an RRset.
+type sigEntry struct {
+	key  string
+	sigs []dns.RR
+}
+
+// newSigner returns a signer of the given zone using the keys stored in the
+// BIND-style key files with the given paths, without their .key and
+// .private extensions. Keys with the SEP flag are KSKs and only sign the
+// DNSKEY RRset, unless there are no ZSKs.
+func newSigner(zone string, ttl uint32, paths []string, nsec3 bool) (*signer, error) {
+	s := &signer{
+		zone:      dns.Fqdn(strings.ToLower(zone)),
+		ttl:       ttl,
+		nsec3:     nsec3,
+		maxCaches: 2,
+		maxSigs:   maxCachedSigs,
+		caches:    map[*records.RecordGenerator]*sigCache{},
+	}
+	for _, path := range paths {
+		k, err := loadKey(path)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(k.Hdr.Name, s.zone) {
+			return nil, fmt.Errorf("DNSSEC key %s is for zone %q, not %q", path, k.Hdr.Name, s.zone)
+		}
+		if k.Flags&dns.SEP != 0 {
+			s.ksks = append(s.ksks, k)
+		} else {
+			s.zsks = append(s.zsks, k)
+		}
+	}
+	if len(s.zsks) == 0 {
+		s.zsks = s.ksks
+	} else if len(s.ksks) == 0 {
+		s.ksks = s.zsks
+	}
+	return s, nil
+}
+
+// loadKey reads the DNSKEY and private key at path.key and path.private.
+func loadKey(path string) (dnssecKey, error) {
+	var k dnssecKey
+	f, err := os.Open(path + ".key")
+	if err != nil {
+		return k, err
+	}
+	defer f.Close()
+	rr, err := dns.ReadRR(f, f.Name())
+	if err != nil {
+		return k, err
+	}
+	var ok bool
+	if k.DNSKEY, ok = rr.(*dns.DNSKEY); !ok {
+		return k, fmt.Errorf("%s: not a DNSKEY record", f.Name())
+	}
+
+	p, err := os.Open(path + ".private")
+	if err != nil {
+		return k, err
+	}
+	defer p.Close()
+	priv, err := k.ReadPrivateKey(p, p.Name())
+	if err != nil {
+		return k, err
+	}
+	if k.priv, ok = priv.(crypto.Signer); !ok {
+		return k, fmt.Errorf("%s: unsupported private key", p.Name())
+	}
+	return k, nil
+}
+
+// signing returns the signer of the response to r, or nil if it mustn't be
+// signed, i.e. unless signing is enabled and r has the DO bit set.
+func (res *Resolver) signing(r *dns.Msg) *signer {
+	s := res.cfg().signer
+	if opt := r.IsEdns0(); s == nil || opt == nil || !opt.Do() {
+		return nil
+	}
+	return s
+}
+
+// handleDNSKEY answers with the DNSKEY RRset if name is the apex of the
+// signed zone.
+func (res *Resolver) handleDNSKEY(name string, m *dns.Msg) error {
+	if s := res.cfg().signer; s != nil && name == s.zone {
+		m.Answer = append(m.Answer, s.dnskeys()...)
+	}
+	return nil
+}
+
+// dnskeys returns the DNSKEY RRset of the zone.
+func (s *signer) dnskeys() []dns.RR {
+	keys := make([]dns.RR, 0, len(s.ksks)+len(s.zsks))
+	seen := map[*dns.DNSKEY]bool{}
+	for _, k := range append(s.ksks, s.zsks...) {
+		if seen[k.DNSKEY] {
+			continue
+		}
+		seen[k.DNSKEY] = true
+		key := *k.DNSKEY
+		key.Hdr.Ttl = s.ttl
+		keys = append(keys, &key)
+	}
+	return keys
+}
+
+// sign appends the RRSIGs of every RRset in m to the section holding it and
+// advertises DNSSEC support in the OPT record of m. r is the request m
+// answers.
+func (s *signer) sign(rs *records.RecordGenerator, m, r *dns.Msg) {
+	c := s.sigCache(rs)
+	m.Answer = s.signSection(c, m.Answer)
+	m.Ns = s.signSection(c, m.Ns)
+	m.Extra = s.signSection(c, m.Extra)
+
+	size := r.IsEdns0().UDPSize()
+	if size < dns.MinMsgSize {
+		size = dns.MinMsgSize
+	} else if size > maxEDNSSize {
+		size = maxEDNSSize
+	}
+	m.SetEdns0(size, true)
+}
+
+// sigCache returns the signature cache of the given record set, evicting
+// the oldest cache if there are too many of them.
+func (s *signer) sigCache(rs *records.RecordGenerator) *sigCache {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.caches[rs]; ok {
+		return c
+	}
+	if len(s.order) >= s.maxCaches {
+		delete(s.caches, s.order[0])
+		s.order = s.order[1:]
+	}
+	c := &sigCache{rs: rs, lru: list.New(), sigs: map[string]*list.Element{}}
+	s.caches[rs] = c
+	s.order = append(s.order, rs)
+	return c
+}
+
+// signSection returns the given RRs followed by the RRSIGs of their RRsets.
+func (s *signer) signSection(c *sigCache, rrs []dns.RR) []dns.RR {
+	type setKey struct {
+		name  string
+		rtype uint16
+	}
+	var keys []setKey
+	sets := map[setKey][]dns.RR{}
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		if hdr.Rrtype == dns.TypeOPT || hdr.Rrtype == dns.TypeRRSIG {
+			continue
+		}
+		k := setKey{strings.ToLower(hdr.Name), hdr.Rrtype}
+		if _, ok := sets[k]; !ok {
+			keys = append(keys, k)
+		}
+		sets[k] = append(sets[k], rr)
+	}
+	for _, k := range keys {
+		rrs = append(rrs, s.signatures(c, sets[k])...)
+	}
+	return rrs
+}
+
+// signatures returns the RRSIGs of the given RRset, made by the KSKs for the
+// DNSKEY RRset and by the ZSKs otherwise. They're cached in c until half of
+// their validity period has elapsed.
+func (s *signer) signatures(c *sigCache, rrset []dns.RR) []dns.RR {
+	lines := make([]string, len(rrset))
+	for i, rr := range rrset {
+		lines[i] = rr.String()
+	}
+	sort.Strings(lines)
+	key := strings.Join(lines, "\n")
+
+	now := time.Now()
+	s.mu.Lock()
+	var sigs []dns.RR
+	if e, ok := c.sigs[key]; ok {
+		c.lru.MoveToFront(e)
+		sigs = e.Value.(*sigEntry).sigs
+	}
+	s.mu.Unlock()
+	if len(sigs) > 0 && sigs[0].(*dns.RRSIG).Expiration > uint32(now.Add(sigValidity/2).Unix()) {
+		return sigs
+	}
+
+	keys := s.zsks
+	if rrset[0].Header().Rrtype == dns.TypeDNSKEY {
+		keys = s.ksks
+	}
+	sigs = make([]dns.RR, 0, len(keys))
+	for _, k := range keys {
+		sig := &dns.RRSIG{
+			Hdr:        dns.RR_Header{Ttl: rrset[0].Header().Ttl},
+			Algorithm:  k.Algorithm,
+			KeyTag:     k.KeyTag(),
+			SignerName: s.zone,
+			Inception:  uint32(now.Add(-sigSkew).Unix()),
+			Expiration: uint32(now.Add(sigValidity).Unix()),
+		}
+		if err := sig.Sign(k.priv, rrset); err != nil {
+			logger.Error("failed to sign RRset", "type", dns.TypeToString[rrset[0].Header().Rrtype], "name", rrset[0].Header().Name, "error", err)
+			continue
+		}
+		sigs = append(sigs, sig)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := c.sigs[key]; ok {
+		e.Value.(*sigEntry).sigs = sigs
+		c.lru.MoveToFront(e)
+		return sigs
+	}
+	c.sigs[key] = c.lru.PushFront(&sigEntry{key: key, sigs: sigs})
+	for c.lru.Len() > s.maxSigs {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.sigs, e.Value.(*sigEntry).key)
+	}
+	return sigs
+}
+
+// deny returns the response code and the NSEC or NSEC3 records proving that
+// name has no RRs of the queried type: a NODATA proof if name exists, possibly as
+// an empty non-terminal, and a NXDOMAIN proof otherwise.
+func (s *signer) deny(rs *records.RecordGenerator, name string) (int, []dns.RR) {
+	types := s.zoneTypes(s.sigCache(rs))
+	name = dns.Fqdn(strings.ToLower(name))
+
+	if ts, ok := types[name]; ok {
+		if s.nsec3 {
+			return dns.RcodeSuccess, []dns.RR{s.nsec3Match(name, ts)}
+		}
+		return dns.RcodeSuccess, []dns.RR{s.nsecRR(name, "\\000."+name, ts, dns.TypeNSEC)}
+	}
+
+	// the closest encloser always exists as name is within the zone
+	ce, nc := name, name
+	for {
+		nc, ce = ce, parent(ce)
+		if _, ok := types[ce]; ok || ce == s.zone || ce == "." {
+			break
+		}
+	}
+	wildcard := "*." + ce
+
+	if s.nsec3 {
+		return dns.RcodeNameError, []dns.RR{
+			s.nsec3Match(ce, types[ce]),
+			s.nsec3Cover(nc),
+			s.nsec3Cover(wildcard),
+		}
+	}
+	return dns.RcodeNameError, []dns.RR{
+		s.nsecRR(predecessor(name), "\\000."+name, nil, dns.TypeNSEC),
+		s.nsecRR(predecessor(wildcard), "\\000."+wildcard, nil, dns.TypeNSEC),
+	}
+}
+
+// zoneTypes returns the names of the zone, including empty non-terminals,
+// mapped to the RR types they hold. It's computed once per record set.
+func (s *signer) zoneTypes(c *sigCache) map[string][]uint16 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.types != nil {
+		return c.types
+	}
+
+	types := map[string][]uint16{s.zone: {dns.TypeNS, dns.TypeSOA, dns.TypeDNSKEY}}
+	for _, set := range []struct {
+		rtype uint16
+		names map[string]map[string]struct{}
+	}{
+		{dns.TypeA, c.rs.As},
+		{dns.TypeAAAA, c.rs.AAAAs},
+		{dns.TypeSRV, c.rs.SRVs},
+		{dns.TypeTXT, c.rs.TXTs},
+		{dns.TypeCNAME, c.rs.CNAMEs},
+	} {
+		for name := range set.names {
+			name = strings.ToLower(name)
+			if !dns.IsSubDomain(s.zone, name) {
+				continue
+			}
+			types[name] = append(types[name], set.rtype)
+			for p := parent(name); p != s.zone && dns.IsSubDomain(s.zone, p); p = parent(p) {
+				if _, ok := types[p]; !ok {
+					types[p] = []uint16{}
+				}
+			}
+		}
+	}
+	c.types = types
+	return types
+}
+
+// nsecRR returns a NSEC record of the zone from owner to next with the given
+// RR types in its bitmap, besides RRSIG and the given denial type.
+func (s *signer) nsecRR(owner, next string, types []uint16, denial uint16) *dns.NSEC {
+	return &dns.NSEC{
+		Hdr: dns.RR_Header{
+			Name:   owner,
+			Rrtype: dns.TypeNSEC,
+			Class:  dns.ClassINET,
+			Ttl:    s.ttl,
+		},
+		NextDomain: next,
+		TypeBitMap: bitmap(types, dns.TypeRRSIG, denial),
+	}
+}
+
+// nsec3Match returns a NSEC3 record matching the given name, which holds
+// RRs of the given types.
+func (s *signer) nsec3Match(name string, types []uint16) *dns.NSEC3 {
+	h := nsec3Hash(name)
+	var bm []uint16
+	if len(types) > 0 {
+		bm = bitmap(types, dns.TypeRRSIG)
+	}
+	return s.nsec3RR(h, addHash(h, 1), bm)
+}
+
+// nsec3Cover returns a NSEC3 record covering the hash of the given name.
+func (s *signer) nsec3Cover(name string) *dns.NSEC3 {
+	h := nsec3Hash(name)
+	return s.nsec3RR(addHash(h, -1), addHash(h, 1), nil)
+}
+
+// nsec3RR returns a NSEC3 record of the zone from hash owner to next with the
+// given RR types in its bitmap. Names are hashed once, without salt, as
+// recommended by RFC 9276.
+func (s *signer) nsec3RR(owner, next []byte, types []uint16) *dns.NSEC3 {
+	return &dns.NSEC3{
+		Hdr: dns.RR_Header{
+			Name:   strings.ToLower(base32.HexEncoding.EncodeToString(owner)) + "." + s.zone,
+			Rrtype: dns.TypeNSEC3,
+			Class:  dns.ClassINET,
+			Ttl:    s.ttl,
+		},
+		Hash:       dns.SHA1,
+		HashLength: uint8(len(next)),
+		NextDomain: base32.HexEncoding.EncodeToString(next),
+		TypeBitMap: types,
+	}
+}
+
+// nsec3Hash returns the NSEC3 SHA1 hash of name, with no salt and no
+// additional iterations.
+func nsec3Hash(name string) []byte {
+	h, _ := base32.HexEncoding.DecodeString(dns.HashName(name, dns.SHA1, 0, ""))
+	return h
+}
+
+// addHash returns hash h plus delta, wrapping around the hash space.
+func addHash(h []byte, delta int64) []byte {
+	mod := new(big.Int).Lsh(big.NewInt(1), uint(8*len(h)))
+	n := new(big.Int).SetBytes(h)
+	n.Add(n, big.NewInt(delta)).Mod(n, mod)
+	b := n.Bytes()
+	out := make([]byte, len(h))
+	copy(out[len(out)-len(b):], b)
+	return out
+}
+
+// bitmap returns the sorted and deduplicated union of the given RR types.
+func bitmap(types []uint16, more ...uint16) []uint16 {
+	seen := map[uint16]bool{}
+	var bm []uint16
+	for _, t := range append(append([]uint16{}, types...), more...) {
+		if !seen[t] {
+			seen[t] = true
+			bm = append(bm, t)
+		}
+	}
+	sort.Sort(uint16s(bm))
+	return bm
+}
+
+type uint16s []uint16
+
+func (s uint16s) Len() int           { return len(s) }
+func (s uint16s) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint16s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// parent returns the name with its leftmost label removed.
+func parent(name string) string {
+	if i, end := dns.NextLabel(name, 0); !end {
+		return name[i:]
+	}
+	return "."
+}
+
+// predecessor returns a name immediately preceding the given one in
+// canonical DNS order (RFC 4034, section 6.1), as far as names made of
+// printable characters go: the last octet of its leftmost label is
+// decremented and followed by the largest possible octet.
+func predecessor(name string) string {
+	wire := make([]byte, 256)
+	if _, err := dns.PackDomainName(name, wire, 0, nil, false); err != nil || wire[0] == 0 {
+		return name
+	}
+	n := int(wire[0])
+	label := append([]byte{}, wire[1:1+n]...)
+	if label[n-1] == 0 {
+		if n == 1 {
+			return name
+		}
+		label = label[:n-1]
+	} else {
+		label[n-1]--
+		if n < 63 {
+			label = append(label, 0xff)
+		}
+	}
+	wire = append(append([]byte{byte(len(label))}, label...), wire[1+n:]...)
+	pred, _, err := dns.UnpackDomainName(wire, 0)
+	if err != nil {
+		return name
+	}
+	return pred
+}
@@ -0,0 +1,281 @@
+package resolver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	. "github.com/mesosphere/mesos-dns/dnstest"
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+// signedDNS returns a fake Resolver signing the Mesos domain with a freshly
+// generated KSK and ZSK, written to and loaded from key files.
+func signedDNS(t testing.TB, nsec3 bool) *Resolver {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as qualified by records.SetConfig
+	res.cfg().SOAMname, res.cfg().SOARname = "ns1.mesos.", "root.ns1.mesos."
+
+	dir, err := ioutil.TempDir("", "mesos-dns-dnssec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var paths []string
+	for _, flags := range []uint16{257, 256} {
+		k := &dns.DNSKEY{
+			Hdr:       RRHeader("mesos.", dns.TypeDNSKEY, 60),
+			Flags:     flags,
+			Protocol:  3,
+			Algorithm: dns.ECDSAP256SHA256,
+		}
+		priv, err := k.Generate(256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, fmt.Sprintf("Kmesos.+%03d+%05d", k.Algorithm, k.KeyTag()))
+		if err = ioutil.WriteFile(path+".key", []byte(k.String()+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path+".private", []byte(k.PrivateKeyString(priv)), 0600); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	if res.cfg().signer, err = newSigner("mesos", 60, paths, nsec3); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// signedQuery returns the response of res to a DNSSEC query of the given
+// name and type.
+func signedQuery(res *Resolver, name string, qtype uint16) *dns.Msg {
+	var rw ResponseRecorder
+	r := Message(Question(name, qtype))
+	r.SetEdns0(4096, true)
+	res.HandleMesos(&rw, r)
+	return rw.Msg
+}
+
+// verify checks that every RRset in rrs is signed by one of the given keys.
+func verify(t *testing.T, rrs []dns.RR, keys []dnssecKey) {
+	sets := map[string][]dns.RR{}
+	var sigs []*dns.RRSIG
+	for _, rr := range rrs {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			sigs = append(sigs, sig)
+		} else if hdr := rr.Header(); hdr.Rrtype != dns.TypeOPT {
+			key := hdr.Name + " " + dns.TypeToString[hdr.Rrtype]
+			sets[key] = append(sets[key], rr)
+		}
+	}
+	for _, set := range sets {
+		verifySet(t, set, sigs, keys)
+	}
+}
+
+func verifySet(t *testing.T, set []dns.RR, sigs []*dns.RRSIG, keys []dnssecKey) {
+	for _, sig := range sigs {
+		if sig.TypeCovered != set[0].Header().Rrtype || sig.Hdr.Name != set[0].Header().Name {
+			continue
+		}
+		for _, k := range keys {
+			if sig.KeyTag == k.KeyTag() && sig.Verify(k.DNSKEY, set) == nil && sig.ValidityPeriod(time.Now()) {
+				return
+			}
+		}
+	}
+	t.Errorf("no valid signature of %v", set)
+}
+
+func TestSigning(t *testing.T) {
+	res := signedDNS(t, false)
+
+	m := signedQuery(res, "liquor-store.marathon.mesos.", dns.TypeA)
+	if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 3 {
+		t.Fatalf("expected two A records and their RRSIG, got %v", m)
+	}
+	if opt := m.IsEdns0(); opt == nil || !opt.Do() {
+		t.Error("expected the DO bit in the response")
+	}
+	verify(t, m.Answer, res.cfg().signer.zsks)
+
+	// signatures are cached per record set
+	again := signedQuery(res, "liquor-store.marathon.mesos.", dns.TypeA)
+	if got, want := again.Answer[2].(*dns.RRSIG).Signature, m.Answer[2].(*dns.RRSIG).Signature; got != want {
+		t.Error("expected the cached signature to be reused")
+	}
+
+	m = signedQuery(res, "mesos.", dns.TypeDNSKEY)
+	if len(m.Answer) != 3 {
+		t.Fatalf("expected two DNSKEYs and their RRSIG, got %v", m.Answer)
+	}
+	verify(t, m.Answer, res.cfg().signer.ksks)
+
+	m = signedQuery(res, "_liquor-store._tcp.marathon.mesos.", dns.TypeSRV)
+	verify(t, m.Answer, res.cfg().signer.zsks)
+	verify(t, m.Extra, res.cfg().signer.zsks)
+
+	// unsigned queries get unsigned responses
+	var rw ResponseRecorder
+	res.HandleMesos(&rw, Message(Question("liquor-store.marathon.mesos.", dns.TypeA)))
+	if len(rw.Msg.Answer) != 2 || rw.Msg.IsEdns0() != nil {
+		t.Errorf("expected an unsigned response, got %v", rw.Msg)
+	}
+}
+
+func TestSigningDenialNSEC(t *testing.T) {
+	res := signedDNS(t, false)
+
+	for i, tt := range []struct {
+		name       string
+		qtype      uint16
+		rcode      int
+		owners     []string
+		nexts      []string
+		notInTypes uint16
+	}{
+		{
+			"missing.mesos.", dns.TypeA, dns.RcodeNameError,
+			[]string{`missinf\255.mesos.`, `\)\255.mesos.`},
+			[]string{`\000.missing.mesos.`, `\000.*.mesos.`},
+			0,
+		},
+		{ // NODATA
+			"liquor-store.marathon.mesos.", dns.TypeAAAA, dns.RcodeSuccess,
+			[]string{"liquor-store.marathon.mesos."},
+			[]string{`\000.liquor-store.marathon.mesos.`},
+			dns.TypeAAAA,
+		},
+		{ // empty non-terminal
+			"_tcp.marathon.mesos.", dns.TypeSRV, dns.RcodeSuccess,
+			[]string{"_tcp.marathon.mesos."},
+			[]string{`\000._tcp.marathon.mesos.`},
+			dns.TypeSRV,
+		},
+	} {
+		m := signedQuery(res, tt.name, tt.qtype)
+		if m.Rcode != tt.rcode {
+			t.Errorf("test #%d: got rcode %d, want %d", i, m.Rcode, tt.rcode)
+		}
+		if soa, ok := m.Ns[0].(*dns.SOA); !ok || soa.Hdr.Name != "mesos." {
+			t.Errorf("test #%d: expected the apex SOA first, got %v", i, m.Ns[0])
+		}
+		var owners, nexts []string
+		for _, rr := range m.Ns {
+			if nsec, ok := rr.(*dns.NSEC); ok {
+				owners = append(owners, nsec.Hdr.Name)
+				nexts = append(nexts, nsec.NextDomain)
+				for _, typ := range nsec.TypeBitMap {
+					if tt.notInTypes != 0 && typ == tt.notInTypes {
+						t.Errorf("test #%d: %s type in %v", i, dns.TypeToString[typ], nsec)
+					}
+				}
+			}
+		}
+		if !reflect.DeepEqual(owners, tt.owners) || !reflect.DeepEqual(nexts, tt.nexts) {
+			t.Errorf("test #%d: got NSECs %v -> %v, want %v -> %v", i, owners, nexts, tt.owners, tt.nexts)
+		}
+		verify(t, m.Ns, res.cfg().signer.zsks)
+	}
+}
+
+func TestSigningDenialNSEC3(t *testing.T) {
+	res := signedDNS(t, true)
+
+	m := signedQuery(res, "missing.mesos.", dns.TypeA)
+	if m.Rcode != dns.RcodeNameError {
+		t.Errorf("got rcode %d, want NXDOMAIN", m.Rcode)
+	}
+	var nsec3s []*dns.NSEC3
+	for _, rr := range m.Ns {
+		if nsec3, ok := rr.(*dns.NSEC3); ok {
+			nsec3s = append(nsec3s, nsec3)
+		}
+	}
+	if len(nsec3s) != 3 {
+		t.Fatalf("expected a closest encloser proof, got %v", m.Ns)
+	}
+	if !nsec3s[0].Match("mesos.") {
+		t.Errorf("%v doesn't match the closest encloser", nsec3s[0])
+	}
+	if !nsec3s[1].Cover("missing.mesos.") {
+		t.Errorf("%v doesn't cover the next closer name", nsec3s[1])
+	}
+	if !nsec3s[2].Cover("*.mesos.") {
+		t.Errorf("%v doesn't cover the wildcard", nsec3s[2])
+	}
+	verify(t, m.Ns, res.cfg().signer.zsks)
+
+	m = signedQuery(res, "liquor-store.marathon.mesos.", dns.TypeAAAA)
+	if m.Rcode != dns.RcodeSuccess {
+		t.Errorf("got rcode %d, want NOERROR", m.Rcode)
+	}
+	if nsec3, ok := m.Ns[1].(*dns.NSEC3); !ok || !nsec3.Match("liquor-store.marathon.mesos.") {
+		t.Errorf("expected a matching NSEC3, got %v", m.Ns[1])
+	}
+}
+
+func TestSigCacheBounds(t *testing.T) {
+	res := signedDNS(t, false)
+	s := res.cfg().signer
+	s.maxSigs = 4
+
+	// denials of random names don't grow the cache past its bound
+	for i := 0; i < 20; i++ {
+		m := signedQuery(res, fmt.Sprintf("missing%d.mesos.", i), dns.TypeA)
+		verify(t, m.Ns, s.zsks)
+	}
+	c := s.sigCache(res.records())
+	if n := c.lru.Len(); n != s.maxSigs || len(c.sigs) != n {
+		t.Errorf("got %d cached signatures (%d keys), want %d", n, len(c.sigs), s.maxSigs)
+	}
+
+	// the record sets of views have caches of their own
+	view := &records.RecordGenerator{}
+	if s.sigCache(view) == c || s.sigCache(res.records()) != c {
+		t.Error("record sets should have their own sig caches")
+	}
+	s.sigCache(&records.RecordGenerator{})
+	if s.sigCache(res.records()) == c {
+		t.Error("the oldest sig cache should have been evicted")
+	}
+}
+
+func TestPredecessor(t *testing.T) {
+	for i, tt := range []struct {
+		name, want string
+	}{
+		{"foo.mesos.", `fon\255.mesos.`},
+		{"a.b.mesos.", "`\\255.b.mesos."},
+		{`foo\000.mesos.`, "foo.mesos."},
+	} {
+		if got := predecessor(tt.name); got != tt.want {
+			t.Errorf("test #%d: got %q, want %q", i, got, tt.want)
+		}
+	}
+}
+
+func BenchmarkHandleMesosSigned(b *testing.B) {
+	res := signedDNS(b, false)
+	r := Message(Question("liquor-store.marathon.mesos.", dns.TypeA))
+	r.SetEdns0(4096, true)
+	var rw ResponseRecorder
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		res.HandleMesos(&rw, r)
+	}
+}
@@ -0,0 +1,239 @@
+package resolver
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	"github.com/miekg/dns"
+)
+
+const (
+	// dnsMessage is the media type of DNS-over-HTTPS messages.
+	dnsMessage = "application/dns-message"
+	// streamIdleTimeout is how long DNS-over-TLS connections are kept open
+	// without receiving any query.
+	streamIdleTimeout = 10 * time.Second
+)
+
+// getCertificate returns the certificate of the DNS-over-TLS listener, which
+// is replaced along with the configuration.
+func (res *Resolver) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert := res.cfg().cert; cert != nil {
+		return cert, nil
+	}
+	return nil, errors.New("no TLS certificate configured")
+}
+
+// getHTTPCertificate returns the certificate of the HTTP server, like
+// getCertificate.
+func (res *Resolver) getHTTPCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert := res.cfg().httpCert; cert != nil {
+		return cert, nil
+	}
+	return nil, errors.New("no HTTP certificate configured")
+}
+
+// listenAndServeTLS serves HTTPS on the given address with the default
+// HTTP handlers.
+func (res *Resolver) listenAndServeTLS(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return http.Serve(tls.NewListener(l, &tls.Config{GetCertificate: res.getHTTPCertificate}), nil)
+}
+
+// serveTLS starts a DNS-over-TLS server on the given address, like Serve.
+func (res *Resolver) serveTLS(addr string) (<-chan struct{}, <-chan error) {
+	ch := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(errCh)
+		l, err := tls.Listen("tcp", addr, &tls.Config{GetCertificate: res.getCertificate})
+		if err != nil {
+			errCh <- fmt.Errorf("Failed to setup %q server: %v", "tls", err)
+			return
+		}
+		close(ch)
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				errCh <- fmt.Errorf("Failed to accept %q connections: %v", "tls", err)
+				return
+			}
+			go serveStream(conn, dns.DefaultServeMux)
+		}
+	}()
+	return ch, errCh
+}
+
+// serveStream serves the queries received on the given connection, each
+// prefixed with its length as over TCP, with the given handler, until the
+// connection is idle for streamIdleTimeout or closed.
+func serveStream(conn net.Conn, h dns.Handler) {
+	defer conn.Close()
+	w := &streamWriter{conn: conn}
+	for !w.hijacked {
+		if err := conn.SetReadDeadline(time.Now().Add(streamIdleTimeout)); err != nil {
+			return
+		}
+		var l [2]byte
+		if _, err := io.ReadFull(conn, l[:]); err != nil {
+			return
+		}
+		buf := make([]byte, int(l[0])<<8|int(l[1]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+		r := new(dns.Msg)
+		if err := r.Unpack(buf); err != nil {
+			logger.Debug("invalid message", "client", conn.RemoteAddr().String(), "error", err)
+			return
+		}
+		h.ServeDNS(w, r)
+	}
+}
+
+// streamWriter is a dns.ResponseWriter writing messages to a stream
+// connection, each prefixed with its length as over TCP.
+type streamWriter struct {
+	conn     net.Conn
+	hijacked bool
+}
+
+func (w *streamWriter) LocalAddr() net.Addr  { return w.conn.LocalAddr() }
+func (w *streamWriter) RemoteAddr() net.Addr { return w.conn.RemoteAddr() }
+func (w *streamWriter) Close() error         { return w.conn.Close() }
+func (w *streamWriter) TsigStatus() error    { return nil }
+func (w *streamWriter) TsigTimersOnly(bool)  {}
+func (w *streamWriter) Hijack()              { w.hijacked = true }
+
+func (w *streamWriter) WriteMsg(m *dns.Msg) error {
+	buf, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf)
+	return err
+}
+
+func (w *streamWriter) Write(buf []byte) (int, error) {
+	if len(buf) > dns.MaxMsgSize {
+		return 0, dns.ErrBuf
+	}
+	if err := w.conn.SetWriteDeadline(time.Now().Add(streamIdleTimeout)); err != nil {
+		return 0, err
+	}
+	n, err := w.conn.Write(append([]byte{byte(len(buf) >> 8), byte(len(buf))}, buf...))
+	if n -= 2; n < 0 {
+		n = 0
+	}
+	return n, err
+}
+
+// RestDoH handles DNS-over-HTTPS queries, either POSTed or in the dns
+// parameter of a GET request, with the DNS request handlers.
+func (res *Resolver) RestDoH(req *restful.Request, resp *restful.Response) {
+	var (
+		buf []byte
+		err error
+	)
+	if req.Request.Method == "GET" {
+		buf, err = base64.RawURLEncoding.DecodeString(req.QueryParameter("dns"))
+	} else {
+		buf, err = ioutil.ReadAll(io.LimitReader(req.Request.Body, dns.MaxMsgSize))
+	}
+	r := new(dns.Msg)
+	if err == nil {
+		err = r.Unpack(buf)
+	}
+	if err != nil || len(r.Question) != 1 {
+		_ = resp.WriteErrorString(http.StatusBadRequest, "invalid DNS query")
+		return
+	}
+
+	w := &httpWriter{remote: httpAddr(req.Request.RemoteAddr)}
+	dns.DefaultServeMux.ServeDNS(w, r)
+	if w.msg == nil {
+		_ = resp.WriteErrorString(http.StatusInternalServerError, "no DNS response")
+		return
+	}
+	if buf, err = w.msg.Pack(); err != nil {
+		logger.Error("failed to pack the response", "error", err)
+		_ = resp.WriteErrorString(http.StatusInternalServerError, err.Error())
+		return
+	}
+	resp.AddHeader("Content-Type", dnsMessage)
+	if ttl, ok := minTTL(w.msg); ok {
+		resp.AddHeader("Cache-Control", "max-age="+strconv.FormatUint(uint64(ttl), 10))
+	}
+	_, _ = resp.Write(buf)
+}
+
+// minTTL returns the smallest TTL of the records of m, if any, which is how
+// long HTTP caches may keep it.
+func minTTL(m *dns.Msg) (uint32, bool) {
+	ttl, ok := uint32(0), false
+	for _, rrs := range [][]dns.RR{m.Answer, m.Ns} {
+		for _, rr := range rrs {
+			if hdr := rr.Header(); !ok || hdr.Ttl < ttl {
+				ttl, ok = hdr.Ttl, true
+			}
+		}
+	}
+	return ttl, ok
+}
+
+// httpAddr returns the TCP address of the given HTTP client address, or an
+// empty one if it's invalid.
+func httpAddr(addr string) *net.TCPAddr {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return &net.TCPAddr{}
+	}
+	p, _ := strconv.Atoi(port)
+	return &net.TCPAddr{IP: net.ParseIP(host), Port: p}
+}
+
+// httpWriter is a dns.ResponseWriter holding the single response to a
+// DNS-over-HTTPS query.
+type httpWriter struct {
+	remote net.Addr
+	msg    *dns.Msg
+}
+
+// errSingleMessage is returned when writing more than one response to a
+// DNS-over-HTTPS query.
+var errSingleMessage = errors.New("DNS-over-HTTPS responses are single messages")
+
+func (w *httpWriter) LocalAddr() net.Addr  { return &net.TCPAddr{} }
+func (w *httpWriter) RemoteAddr() net.Addr { return w.remote }
+func (w *httpWriter) Close() error         { return nil }
+func (w *httpWriter) TsigStatus() error    { return nil }
+func (w *httpWriter) TsigTimersOnly(bool)  {}
+func (w *httpWriter) Hijack()              {}
+
+func (w *httpWriter) WriteMsg(m *dns.Msg) error {
+	if w.msg != nil {
+		return errSingleMessage
+	}
+	w.msg = m
+	return nil
+}
+
+func (w *httpWriter) Write(buf []byte) (int, error) {
+	m := new(dns.Msg)
+	if err := m.Unpack(buf); err != nil {
+		return 0, err
+	}
+	return len(buf), w.WriteMsg(m)
+}
@@ -0,0 +1,107 @@
+package resolver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/mesosphere/mesos-dns/dnstest"
+	"github.com/miekg/dns"
+)
+
+func TestServeStream(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go serveStream(server, dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := Message(Header(true, dns.RcodeSuccess), Answers(genA(100)...))
+		m.SetReply(r)
+		reply(w, m)
+	}))
+
+	for id := uint16(1); id <= 2; id++ {
+		q := Message(Question("example.com.", dns.TypeA))
+		q.Id = id
+		buf, err := q.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = client.Write(append([]byte{byte(len(buf) >> 8), byte(len(buf))}, buf...)); err != nil {
+			t.Fatal(err)
+		}
+		var l [2]byte
+		if _, err = io.ReadFull(client, l[:]); err != nil {
+			t.Fatal(err)
+		}
+		buf = make([]byte, int(l[0])<<8|int(l[1]))
+		if _, err = io.ReadFull(client, buf); err != nil {
+			t.Fatal(err)
+		}
+		r := new(dns.Msg)
+		if err = r.Unpack(buf); err != nil {
+			t.Fatal(err)
+		}
+		// stream transports aren't limited to 512 bytes
+		if r.Id != id || r.Truncated || len(r.Answer) != 100 {
+			t.Errorf("got response %d truncated %t with %d answers, want %d with all 100", r.Id, r.Truncated, len(r.Answer), id)
+		}
+	}
+}
+
+func TestRestDoH(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.handlers.Do(res.registerHandlers)
+
+	q := Message(Question("chronos.marathon.mesos.", dns.TypeA))
+	q.Id = 0
+	query, err := q.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := A(RRHeader("chronos.marathon.mesos.", dns.TypeA, 60), net.ParseIP("1.2.3.11")).String()
+
+	post, _ := http.NewRequest("POST", "/dns-query", bytes.NewReader(query))
+	get, _ := http.NewRequest("GET", "/dns-query?dns="+base64.RawURLEncoding.EncodeToString(query), nil)
+	bad, _ := http.NewRequest("GET", "/dns-query?dns=invalid", nil)
+	for i, tt := range []struct {
+		req    *http.Request
+		status int
+		want   string
+	}{
+		{post, http.StatusOK, want},
+		{get, http.StatusOK, want},
+		{bad, http.StatusBadRequest, ""},
+	} {
+		tt.req.RemoteAddr = "10.0.0.1:1234"
+		rw := httptest.NewRecorder()
+		res.RestDoH(restful.NewRequest(tt.req), restful.NewResponse(rw))
+		if rw.Code != tt.status {
+			t.Errorf("test #%d: got status %d, want %d", i, rw.Code, tt.status)
+			continue
+		}
+		if tt.want == "" {
+			continue
+		}
+		if ct := rw.Header().Get("Content-Type"); ct != dnsMessage {
+			t.Errorf("test #%d: got content type %q, want %q", i, ct, dnsMessage)
+		}
+		if cc := rw.Header().Get("Cache-Control"); cc != "max-age=60" {
+			t.Errorf("test #%d: got cache control %q, want max-age=60", i, cc)
+		}
+		got := new(dns.Msg)
+		if err := got.Unpack(rw.Body.Bytes()); err != nil {
+			t.Errorf("test #%d: %v", i, err)
+			continue
+		}
+		if got.Id != 0 || !got.Authoritative || len(got.Answer) != 1 || got.Answer[0].String() != tt.want {
+			t.Errorf("test #%d: got response %v, want the authoritative answer %s", i, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package resolver
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/emicklei/go-restful"
+)
+
+// readiness is the JSON body of /v1/ready responses.
+type readiness struct {
+	Ready bool `json:"ready"`
+	// Reasons explains why Mesos-DNS isn't ready
+	Reasons []string `json:"reasons,omitempty"`
+	// LastReload is the time of the last successful reload, if any
+	LastReload *time.Time `json:"last_reload,omitempty"`
+	// AgeSeconds is the age of the served records
+	AgeSeconds float64 `json:"age_seconds"`
+	// Leader is the leading master, if known
+	Leader string `json:"leader,omitempty"`
+}
+
+// leader returns the leader of the given masters, or "" if unknown.
+func leader(masters []string) string {
+	if len(masters) == 0 {
+		return ""
+	}
+	return masters[0]
+}
+
+// readiness returns the readiness of the Resolver at the given time. It's
+// ready once records have been loaded, as long as they're not older than
+// ReadyMaxStaleSeconds, and, when masters are detected in Zookeeper, as long
+// as no leader has been known for at most ReadyMaxLeaderlessSeconds.
+func (res *Resolver) readiness(now time.Time) readiness {
+	res.rsLock.RLock()
+	reloaded, masters, leaderLost := res.reloaded, res.masters, res.leaderLost
+	res.rsLock.RUnlock()
+
+	r := readiness{Leader: leader(masters)}
+	if reloaded.IsZero() {
+		r.Reasons = append(r.Reasons, "no records loaded yet")
+	} else {
+		r.LastReload = &reloaded
+		r.AgeSeconds = now.Sub(reloaded).Seconds()
+		if max := time.Duration(res.cfg().ReadyMaxStaleSeconds) * time.Second; now.Sub(reloaded) > max {
+			r.Reasons = append(r.Reasons, fmt.Sprintf("records are %s old, more than %s", now.Sub(reloaded), max))
+		}
+	}
+
+	if res.cfg().Zk != "" && r.Leader == "" {
+		max := time.Duration(res.cfg().ReadyMaxLeaderlessSeconds) * time.Second
+		if lost := now.Sub(leaderLost); lost > max {
+			r.Reasons = append(r.Reasons, fmt.Sprintf("no leading master detected for %s, more than %s", lost, max))
+		}
+	}
+
+	r.Ready = len(r.Reasons) == 0
+	return r
+}
+
+// RestHealth handles HTTP liveness requests: it's OK as long as Mesos-DNS
+// serves HTTP requests.
+func (res *Resolver) RestHealth(req *restful.Request, resp *restful.Response) {
+	if err := resp.WriteAsJson(map[string]bool{"healthy": true}); err != nil {
+		logger.Error("failed to write the response", "error", err)
+	}
+}
+
+// RestReady handles HTTP readiness requests, failing with 503 Service
+// Unavailable when Mesos-DNS isn't ready to serve up to date records.
+func (res *Resolver) RestReady(req *restful.Request, resp *restful.Response) {
+	r := res.readiness(time.Now())
+	if !r.Ready {
+		resp.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := resp.WriteAsJson(r); err != nil {
+		logger.Error("failed to write the response", "error", err)
+	}
+}
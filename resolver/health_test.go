@@ -0,0 +1,86 @@
+package resolver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadiness(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.cfg().Zk = "zk://1.2.3.4:2181/mesos"
+	res.cfg().ReadyMaxStaleSeconds = 180
+	res.cfg().ReadyMaxLeaderlessSeconds = 60
+
+	start := time.Now()
+	res.leaderLost = start
+
+	for i, tt := range []struct {
+		reloaded time.Time
+		masters  []string
+		now      time.Time
+		ready    bool
+		reasons  int
+	}{
+		{time.Time{}, []string{"1.2.3.4:5050"}, start, false, 1},                    // no reload yet
+		{start, []string{"1.2.3.4:5050"}, start.Add(time.Minute), true, 0},          // fresh
+		{start, []string{"1.2.3.4:5050"}, start.Add(time.Hour), false, 1},           // stale
+		{start, []string{""}, start.Add(30 * time.Second), true, 0},                 // leaderless for a while
+		{start, []string{""}, start.Add(2 * time.Minute), false, 1},                 // leaderless for too long
+		{time.Time{}, []string{"", "1.2.3.5:5050"}, start.Add(time.Hour), false, 2}, // both
+	} {
+		res.reloaded = tt.reloaded
+		res.masters = tt.masters
+		r := res.readiness(tt.now)
+		if r.Ready != tt.ready || len(r.Reasons) != tt.reasons {
+			t.Errorf("test #%d: got ready: %t, reasons: %q; want ready: %t with %d reasons", i, r.Ready, r.Reasons, tt.ready, tt.reasons)
+		}
+		if r.Leader != tt.masters[0] {
+			t.Errorf("test #%d: got leader %q, want %q", i, r.Leader, tt.masters[0])
+		}
+	}
+
+	// losing the leader resets the leaderless period
+	res.masters = []string{"1.2.3.4:5050"}
+	res.SetMasters([]string{""})
+	if res.leaderLost.Before(start) || res.readiness(time.Now()).Leader != "" {
+		t.Error("expected the leader loss to be recorded")
+	}
+}
+
+func TestReadiness_Heartbeats(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.cfg().EventStreamOn = true
+	res.cfg().ReadyMaxStaleSeconds = 1
+
+	// a master subscribing with an empty state, then only sending heartbeats
+	done := make(chan struct{})
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		for e := `{"type":"SUBSCRIBED","subscribed":{"get_state":{},"heartbeat_interval_seconds":0.1}}`; ; e = `{"type":"HEARTBEAT"}` {
+			fmt.Fprintf(w, "%d\n%s", len(e), e)
+			w.(http.Flusher).Flush()
+			select {
+			case <-done:
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}))
+	defer master.Close()
+	defer close(done)
+
+	res.stream(res.cfg().recordGenerator(), []string{master.Listener.Addr().String()})
+	time.Sleep(1500 * time.Millisecond)
+	if r := res.readiness(time.Now()); !r.Ready {
+		t.Errorf("got reasons %q, want heartbeats to keep the records fresh", r.Reasons)
+	}
+}
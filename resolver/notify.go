@@ -0,0 +1,84 @@
+package resolver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/miekg/dns"
+)
+
+// notifyRetries is the number of times a NOTIFY is retransmitted to a
+// secondary which didn't acknowledge it.
+const notifyRetries = 5
+
+// notifyBackoff is the delay before the first NOTIFY retransmission. It
+// doubles with every subsequent one.
+var notifyBackoff = 2 * time.Second
+
+// notify asynchronously sends a DNS NOTIFY (RFC 1996) for the Mesos domain
+// with the given serial to each of the configured secondaries.
+func (res *Resolver) notify(serial uint32) {
+	for _, addr := range res.cfg().secondaries {
+		go func(addr string) {
+			if err := res.notifySecondary(addr, serial); err != nil {
+				logger.Error("NOTIFY failed", "secondary", addr, "serial", serial, "error", err)
+			}
+		}(addr)
+	}
+}
+
+// notifySecondary sends a NOTIFY with the given serial to the secondary at
+// addr, retransmitting it with exponential backoff until it's acknowledged
+// or notifyRetries is exhausted.
+func (res *Resolver) notifySecondary(addr string, serial uint32) error {
+	m := new(dns.Msg)
+	m.SetNotify(res.cfg().Domain + ".")
+	soa := res.formatSOA(res.cfg().Domain + ".")
+	soa.Serial = serial
+	m.Answer = []dns.RR{soa}
+
+	backoff := notifyBackoff
+	for i := 0; ; i++ {
+		logging.CurLog.NotifySent.Inc()
+		r, _, err := res.cfg().notifier.Exchange(m, addr)
+		if err == nil {
+			err = notifyError(m, r)
+		}
+		if err == nil {
+			logging.CurLog.NotifySuccess.Inc()
+			logger.Debug("NOTIFY acknowledged", "secondary", addr, "serial", serial)
+			return nil
+		}
+		if i == notifyRetries {
+			logging.CurLog.NotifyFailed.Inc()
+			return fmt.Errorf("giving up NOTIFY of serial %d to %s: %v", serial, addr, err)
+		}
+		logger.Debug("NOTIFY failed, retrying", "secondary", addr, "serial", serial, "error", err, "backoff", backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// notifyError returns an error if r isn't a successful response to the
+// NOTIFY m.
+func notifyError(m, r *dns.Msg) error {
+	switch {
+	case r == nil:
+		return fmt.Errorf("no response")
+	case r.Id != m.Id || !r.Response || r.Opcode != dns.OpcodeNotify:
+		return fmt.Errorf("unexpected response %v", r.MsgHdr)
+	case r.Rcode != dns.RcodeSuccess:
+		return fmt.Errorf("response code %s", dns.RcodeToString[r.Rcode])
+	}
+	return nil
+}
+
+// nextSerial returns the SOA serial following cur: the current Unix time, or
+// cur+1 if that's not greater than cur.
+func nextSerial(cur uint32, now time.Time) uint32 {
+	if ts := uint32(now.Unix()); ts > cur {
+		return ts
+	}
+	return cur + 1
+}
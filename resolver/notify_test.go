@@ -0,0 +1,133 @@
+package resolver
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/exchanger"
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/mesosphere/mesos-dns/records/labels"
+	"github.com/miekg/dns"
+)
+
+func init() { notifyBackoff = 0 }
+
+// ack returns an acknowledgement of the NOTIFY m with the given rcode.
+func ack(m *dns.Msg, rcode int) *dns.Msg {
+	r := new(dns.Msg)
+	r.SetRcode(m, rcode)
+	r.Opcode = m.Opcode
+	return r
+}
+
+func TestNotifySecondary(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tt := range []struct {
+		failures int
+		err      bool
+	}{
+		{0, false},
+		{notifyRetries, false},
+		{notifyRetries + 1, true},
+	} {
+		calls := 0
+		res.cfg().notifier = exchanger.Func(func(m *dns.Msg, addr string) (*dns.Msg, time.Duration, error) {
+			if calls++; calls <= tt.failures {
+				if calls%2 == 0 {
+					return ack(m, dns.RcodeServerFailure), 0, nil
+				}
+				return nil, 0, errors.New("timeout")
+			}
+			if m.Opcode != dns.OpcodeNotify || m.Question[0].Name != "mesos." || addr != "1.2.3.4:53" {
+				t.Errorf("test #%d: unexpected NOTIFY %v to %s", i, m, addr)
+			}
+			if soa, ok := m.Answer[0].(*dns.SOA); !ok || soa.Serial != 42 {
+				t.Errorf("test #%d: unexpected NOTIFY answer %v", i, m.Answer)
+			}
+			return ack(m, dns.RcodeSuccess), 0, nil
+		})
+
+		failed := counter(logging.CurLog.NotifyFailed)
+		err := res.notifySecondary("1.2.3.4:53", 42)
+		if got := err != nil; got != tt.err {
+			t.Errorf("test #%d: got error %v, want error: %t", i, err, tt.err)
+		}
+		if want := tt.failures + 1; !tt.err && calls != want {
+			t.Errorf("test #%d: got %d attempts, want %d", i, calls, want)
+		}
+		if got := counter(logging.CurLog.NotifyFailed) - failed; tt.err && got != 1 {
+			t.Errorf("test #%d: NotifyFailed increased by %d, want 1", i, got)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.cfg().secondaries = []string{"1.2.3.4:53"}
+	notified := make(chan uint32, 1)
+	res.cfg().notifier = exchanger.Func(func(m *dns.Msg, addr string) (*dns.Msg, time.Duration, error) {
+		notified <- m.Answer[0].(*dns.SOA).Serial
+		return ack(m, dns.RcodeSuccess), 0, nil
+	})
+
+	generator := func(drop int) *records.RecordGenerator {
+		sj := fakeState(t)
+		tasks := sj.Frameworks[2].Tasks
+		sj.Frameworks[2].Tasks = tasks[:len(tasks)-drop]
+		rs := records.NewRecordGenerator(0)
+		if err := rs.InsertState(sj, "mesos", "mesos-dns.mesos.", "127.0.0.1", res.cfg().Masters, res.cfg().IPSources, []string{"owner"}, labels.RFC952); err != nil {
+			t.Fatal(err)
+		}
+		return rs
+	}
+
+	serial := res.cfg().SOASerial
+	res.update(generator(0))
+	if res.cfg().SOASerial != serial || len(res.versions) != 1 {
+		t.Errorf("unchanged records bumped the serial to %d", res.cfg().SOASerial)
+	}
+
+	res.update(generator(1))
+	if res.cfg().SOASerial <= serial || len(res.versions) != 2 {
+		t.Errorf("changed records didn't bump the serial from %d", serial)
+	}
+	select {
+	case got := <-notified:
+		if got != res.cfg().SOASerial {
+			t.Errorf("notified serial %d, want %d", got, res.cfg().SOASerial)
+		}
+	case <-time.After(time.Second):
+		t.Error("secondary wasn't notified")
+	}
+}
+
+func TestNextSerial(t *testing.T) {
+	now := time.Unix(1000, 0)
+	for i, tt := range []struct {
+		cur, want uint32
+	}{
+		{0, 1000},
+		{999, 1000},
+		{1000, 1001},
+		{2000, 2001},
+	} {
+		if got := nextSerial(tt.cur, now); got != tt.want {
+			t.Errorf("test #%d: got %d, want %d", i, got, tt.want)
+		}
+	}
+}
+
+func counter(c logging.Counter) (n int) {
+	fmt.Sscan(c.(fmt.Stringer).String(), &n)
+	return n
+}
@@ -0,0 +1,280 @@
+package resolver
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/emicklei/go-restful"
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+// queryLogBuffer is the number of lines waiting to be written to the query
+// log, past which lines are dropped rather than slowing queries down.
+const queryLogBuffer = 1024
+
+// queryLog writes a JSON line per sampled query answered by the Resolver,
+// from a goroutine of its own. It's safe for concurrent use.
+type queryLog struct {
+	// rate, as math.Float64bits, n, the number of queries sampled from, and
+	// on, 1 if on, are accessed atomically
+	rate uint64
+	n    uint64
+	on   int32
+
+	lines   chan []byte
+	flushes chan chan struct{}
+
+	// mu guards the fields below, and the writes to w
+	mu sync.Mutex
+	// file is the path of the log, stdout if empty; w writes to it
+	file             string
+	maxSize, backups int
+	w                io.Writer
+}
+
+// newQueryLog returns a queryLog, off until configured, and starts writing
+// its lines.
+func newQueryLog() *queryLog {
+	ql := &queryLog{
+		lines:   make(chan []byte, queryLogBuffer),
+		flushes: make(chan chan struct{}),
+	}
+	go ql.run()
+	return ql
+}
+
+// errInvalidSampleRate is returned when setting a sample rate outside of
+// [0, 1].
+var errInvalidSampleRate = errors.New("sample_rate must be between 0 and 1")
+
+// queryEntry is a line of the query log.
+type queryEntry struct {
+	Time      time.Time `json:"time"`
+	Client    string    `json:"client"`
+	Protocol  string    `json:"protocol"`
+	Name      string    `json:"qname"`
+	Type      string    `json:"qtype"`
+	Rcode     string    `json:"rcode"`
+	Answers   int       `json:"answers"`
+	Truncated bool      `json:"truncated"`
+	Forwarded bool      `json:"forwarded"`
+	Upstream  string    `json:"upstream,omitempty"`
+	LatencyMS float64   `json:"latency_ms"`
+}
+
+// queryLogState is the state of the query log exposed over HTTP.
+type queryLogState struct {
+	Enabled    bool    `json:"enabled"`
+	SampleRate float64 `json:"sample_rate"`
+}
+
+// configure applies the query log parameters of the given configuration,
+// reopening the log if its file or rotation changed. The lines of the queries
+// sampled before are written to the previous log.
+func (ql *queryLog) configure(c records.Config) error {
+	ql.flush()
+	ql.mu.Lock()
+	defer ql.mu.Unlock()
+	maxSize := c.QueryLogMaxSizeMB << 20
+	if ql.w == nil || c.QueryLogFile != ql.file || maxSize != ql.maxSize || c.QueryLogMaxBackups != ql.backups {
+		var w io.Writer = os.Stdout
+		if c.QueryLogFile != "" {
+			rf, err := logging.OpenRotatingFile(c.QueryLogFile, int64(maxSize), c.QueryLogMaxBackups)
+			if err != nil {
+				return err
+			}
+			w = rf
+		}
+		if rf, ok := ql.w.(*logging.RotatingFile); ok {
+			if err := rf.Close(); err != nil {
+				logger.Error("failed to close the query log", "file", ql.file, "error", err)
+			}
+		}
+		ql.w, ql.file, ql.maxSize, ql.backups = w, c.QueryLogFile, maxSize, c.QueryLogMaxBackups
+	}
+	return ql.set(&c.QueryLogOn, &c.QueryLogSampleRate)
+}
+
+// sampled returns true if the next query is to be logged: the queries are
+// sampled evenly, exactly one in 1/rate being logged. A nil queryLog logs
+// none.
+func (ql *queryLog) sampled() bool {
+	if ql == nil || atomic.LoadInt32(&ql.on) == 0 {
+		return false
+	}
+	rate := math.Float64frombits(atomic.LoadUint64(&ql.rate))
+	n := atomic.AddUint64(&ql.n, 1)
+	return uint64(float64(n)*rate) != uint64(float64(n-1)*rate)
+}
+
+// state returns the state of the query log.
+func (ql *queryLog) state() queryLogState {
+	return queryLogState{
+		Enabled:    atomic.LoadInt32(&ql.on) == 1,
+		SampleRate: math.Float64frombits(atomic.LoadUint64(&ql.rate)),
+	}
+}
+
+// set turns the query log on or off and sets its sample rate, unless nil.
+func (ql *queryLog) set(on *bool, rate *float64) error {
+	if rate != nil && (*rate < 0 || *rate > 1) {
+		return errInvalidSampleRate
+	}
+	if rate != nil {
+		atomic.StoreUint64(&ql.rate, math.Float64bits(*rate))
+	}
+	if on != nil {
+		var v int32
+		if *on {
+			v = 1
+		}
+		atomic.StoreInt32(&ql.on, v)
+	}
+	return nil
+}
+
+// write queues the given entry to be written as a line of the log, unless
+// too many lines are already waiting.
+func (ql *queryLog) write(e *queryEntry) {
+	buf, err := json.Marshal(e)
+	if err != nil {
+		logger.Error("failed to encode the query log entry", "error", err)
+		return
+	}
+	select {
+	case ql.lines <- append(buf, '\n'):
+	default:
+		logging.CurLog.QueryLogDropped.Inc()
+	}
+}
+
+// run writes the lines queued by write, and those queued before a flush once
+// requested, forever.
+func (ql *queryLog) run() {
+	for {
+		select {
+		case line := <-ql.lines:
+			ql.writeLine(line)
+		case done := <-ql.flushes:
+			for n := len(ql.lines); n > 0; n-- {
+				ql.writeLine(<-ql.lines)
+			}
+			close(done)
+		}
+	}
+}
+
+// flush returns once the lines queued so far are written.
+func (ql *queryLog) flush() {
+	done := make(chan struct{})
+	ql.flushes <- done
+	<-done
+}
+
+func (ql *queryLog) writeLine(line []byte) {
+	ql.mu.Lock()
+	defer ql.mu.Unlock()
+	if ql.w == nil {
+		return
+	}
+	if _, err := ql.w.Write(line); err != nil {
+		logger.Error("failed to write the query log", "error", err)
+	}
+}
+
+// logged returns a request handler logging the sampled queries to h, when the
+// query log is on.
+func (res *Resolver) logged(h dns.HandlerFunc) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		if !res.queryLog.sampled() {
+			h(w, r)
+			return
+		}
+		qw := &queryWriter{ResponseWriter: w}
+		start := time.Now()
+		h(qw, r)
+
+		e := &queryEntry{
+			Time:      start.UTC(),
+			Client:    w.RemoteAddr().String(),
+			Protocol:  transport(w),
+			Answers:   qw.answers,
+			Truncated: qw.truncated,
+			Forwarded: qw.forwarded,
+			Upstream:  qw.upstream,
+			LatencyMS: time.Since(start).Seconds() * 1000,
+		}
+		if len(r.Question) > 0 {
+			e.Name = r.Question[0].Name
+			e.Type = dns.TypeToString[r.Question[0].Qtype]
+		}
+		if qw.msgs > 0 {
+			e.Rcode = dns.RcodeToString[qw.rcode]
+		}
+		res.queryLog.write(e)
+	}
+}
+
+// queryWriter is a dns.ResponseWriter recording what's written for the query
+// log: the rcode of the last response, and all the answers of the responses,
+// which are several in zone transfers.
+type queryWriter struct {
+	dns.ResponseWriter
+	msgs, answers        int
+	rcode                int
+	truncated, forwarded bool
+	upstream             string
+}
+
+// WriteMsg implements the dns.ResponseWriter interface.
+func (w *queryWriter) WriteMsg(m *dns.Msg) error {
+	w.msgs++
+	w.answers += len(m.Answer)
+	w.rcode = m.Rcode
+	w.truncated = w.truncated || m.Truncated
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// forwardedTo records that the query was forwarded and to which upstream, if
+// w is a queryWriter.
+func forwardedTo(w dns.ResponseWriter, upstream string) {
+	if qw, ok := w.(*queryWriter); ok {
+		qw.forwarded, qw.upstream = true, upstream
+	}
+}
+
+// RestQueryLog handles HTTP requests of the state of the query log, and of
+// changes to it, in the same format.
+func (res *Resolver) RestQueryLog(req *restful.Request, resp *restful.Response) {
+	if req.Request.Method == "PUT" {
+		var change struct {
+			Enabled    *bool    `json:"enabled"`
+			SampleRate *float64 `json:"sample_rate"`
+		}
+		err := json.NewDecoder(req.Request.Body).Decode(&change)
+		if err == nil {
+			err = res.queryLog.set(change.Enabled, change.SampleRate)
+		}
+		if err != nil {
+			resp.WriteHeader(http.StatusBadRequest)
+			if err = resp.WriteAsJson(map[string]string{"error": err.Error()}); err != nil {
+				logger.Error("failed to write the response", "error", err)
+			}
+			return
+		}
+		state := res.queryLog.state()
+		logger.Info("query log set", "enabled", state.Enabled, "sample_rate", state.SampleRate)
+	}
+	if err := resp.WriteAsJson(res.queryLog.state()); err != nil {
+		logger.Error("failed to write the response", "error", err)
+	}
+}
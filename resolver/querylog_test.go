@@ -0,0 +1,131 @@
+package resolver
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful"
+	. "github.com/mesosphere/mesos-dns/dnstest"
+	"github.com/miekg/dns"
+)
+
+func TestQueryLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesos-dns-querylog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := res.cfg().Config
+	config.QueryLogOn = true
+	config.QueryLogFile = filepath.Join(dir, "queries.log")
+	if err = res.queryLog.configure(config); err != nil {
+		t.Fatal(err)
+	}
+	res.cfg().fwd = func(m *dns.Msg, proto string) (*dns.Msg, string, error) {
+		return new(dns.Msg).SetRcode(m, dns.RcodeNameError), "8.8.8.8:53", nil
+	}
+
+	for _, q := range []struct {
+		h      dns.HandlerFunc
+		name   string
+		remote string
+	}{
+		{res.HandleMesos, "chronos.marathon.mesos.", "10.0.0.1"},
+		{res.HandleNonMesos, "missing.example.com.", "10.0.0.2"},
+	} {
+		rw := &udpRecorder{ResponseRecorder: ResponseRecorder{Remote: net.IPAddr{IP: net.ParseIP(q.remote)}}}
+		res.logged(q.h)(rw, Message(Question(q.name, dns.TypeA)))
+	}
+
+	// turning it off over HTTP
+	for i, tt := range []struct {
+		body   string
+		status int
+	}{
+		{`{"sample_rate": 2}`, http.StatusBadRequest},
+		{`{"enabled": false}`, http.StatusOK},
+	} {
+		req, _ := http.NewRequest("PUT", "/v1/querylog", strings.NewReader(tt.body))
+		rw := httptest.NewRecorder()
+		res.RestQueryLog(restful.NewRequest(req), restful.NewResponse(rw))
+		if rw.Code != tt.status {
+			t.Errorf("test #%d: got status %d, want %d", i, rw.Code, tt.status)
+		}
+	}
+	if state := res.queryLog.state(); state.Enabled || state.SampleRate != 1 {
+		t.Errorf("got query log state %+v, want disabled with a sample rate of 1", state)
+	}
+	res.logged(res.HandleMesos)(&ResponseRecorder{}, Message(Question("chronos.marathon.mesos.", dns.TypeA)))
+	res.queryLog.flush()
+
+	f, err := os.Open(config.QueryLogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []queryEntry
+	for s := bufio.NewScanner(f); s.Scan(); {
+		var e queryEntry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			t.Fatalf("invalid line %q: %v", s.Text(), err)
+		}
+		got = append(got, e)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d lines, want 2", len(got))
+	}
+	for i, want := range []queryEntry{
+		{Client: "10.0.0.1:0", Protocol: "udp", Name: "chronos.marathon.mesos.", Type: "A", Rcode: "NOERROR", Answers: 1},
+		{Client: "10.0.0.2:0", Protocol: "udp", Name: "missing.example.com.", Type: "A", Rcode: "NXDOMAIN",
+			Forwarded: true, Upstream: "8.8.8.8:53"},
+	} {
+		e := got[i]
+		if e.Time.IsZero() || e.LatencyMS < 0 {
+			t.Errorf("line #%d: got time %v and latency %vms", i, e.Time, e.LatencyMS)
+		}
+		e.Time, e.LatencyMS = want.Time, want.LatencyMS
+		if e != want {
+			t.Errorf("line #%d: got %+v, want %+v", i, e, want)
+		}
+	}
+}
+
+func TestQueryLog_Sampled(t *testing.T) {
+	ql := newQueryLog()
+	on := true
+	for _, tt := range []struct {
+		rate float64
+		want int
+	}{
+		{0, 0},
+		{0.25, 25},
+		{0.3, 30},
+		{1, 100},
+	} {
+		if err := ql.set(&on, &tt.rate); err != nil {
+			t.Fatal(err)
+		}
+		got := 0
+		for i := 0; i < 100; i++ {
+			if ql.sampled() {
+				got++
+			}
+		}
+		if got != tt.want {
+			t.Errorf("rate %v: got %d queries sampled out of 100, want %d", tt.rate, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package resolver
+
+import (
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/miekg/dns"
+)
+
+// maxBuckets is the number of token buckets a limiter holds before sweeping
+// the idle ones.
+const maxBuckets = 1 << 16
+
+// limiter is a set of token buckets, one per key, each refilled with rate
+// tokens per second up to burst tokens. It's safe for concurrent use.
+type limiter struct {
+	rate, burst float64
+	now         func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// denied is the number of tokens denied since the bucket was last full
+	denied int
+}
+
+// newLimiter returns a limiter of the given rate and burst, which defaults to
+// the rate, or nil if the rate is 0.
+func newLimiter(rate, burst int) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < rate {
+		burst = rate
+	}
+	return &limiter{
+		rate:    float64(rate),
+		burst:   float64(burst),
+		now:     time.Now,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// take takes a token from the bucket of the given key, returning whether
+// there was one, and otherwise the number of tokens denied in a row.
+func (l *limiter) take(key string) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.sweep(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		b.denied++
+		return false, b.denied
+	}
+	b.tokens--
+	b.denied = 0
+	return true, 0
+}
+
+// sweep removes the buckets which are full by now, and so are equivalent to
+// new ones. l.mu must be held.
+func (l *limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// clientPrefix returns the network of the given client address which rate
+// limits apply to.
+func (l *liveConfig) clientPrefix(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(l.RateLimitIPv4Prefix, 32)).String()
+	}
+	if ip == nil {
+		return ""
+	}
+	return ip.Mask(net.CIDRMask(l.RateLimitIPv6Prefix, 128)).String()
+}
+
+// rateLimited returns a request handler limiting the rate of the queries of
+// each client network to h, and the rate of the responses of h sent to it
+// over UDP, as configured, unless the client is exempt. Queries over the
+// limit are dropped over UDP and refused otherwise.
+func (res *Resolver) rateLimited(h dns.HandlerFunc) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		cfg := res.cfg()
+		ip := addrIP(w.RemoteAddr())
+		if (cfg.queries == nil && cfg.responses == nil) || cfg.rateLimitExempt.contains(ip) {
+			h(w, r)
+			return
+		}
+		prefix := cfg.clientPrefix(ip)
+		if cfg.queries != nil {
+			if ok, _ := cfg.queries.take(prefix); !ok {
+				logging.CurLog.RateLimited.Inc()
+				if !isUDP(w) {
+					reply(w, new(dns.Msg).SetRcode(r, dns.RcodeRefused))
+				}
+				return
+			}
+		}
+		if cfg.responses != nil && isUDP(w) {
+			w = &rrlWriter{
+				ResponseWriter: w,
+				rrl:            cfg.responses,
+				slip:           cfg.RRLSlip,
+				prefix:         prefix,
+				domain:         dns.Fqdn(cfg.Domain),
+			}
+		}
+		h(w, r)
+	}
+}
+
+// rrlWriter is a dns.ResponseWriter limiting the rate of identical responses
+// sent to a client network, in the manner of BIND's Response Rate Limiting:
+// every slip-th response over the limit is sent truncated, so that genuine
+// clients retry over TCP, and the others are dropped.
+type rrlWriter struct {
+	dns.ResponseWriter
+	rrl    *limiter
+	slip   int
+	prefix string
+	// domain is the Mesos domain, which Mesos-DNS is authoritative for
+	domain string
+}
+
+// WriteMsg implements the dns.ResponseWriter interface.
+func (w *rrlWriter) WriteMsg(m *dns.Msg) error {
+	ok, denied := w.rrl.take(w.prefix + " " + responseKey(m, w.domain))
+	switch {
+	case ok:
+		return w.ResponseWriter.WriteMsg(m)
+	case w.slip > 0 && denied%w.slip == 0:
+		logging.CurLog.RRLSlipped.Inc()
+		tc := &dns.Msg{MsgHdr: m.MsgHdr, Question: m.Question}
+		tc.Truncated = true
+		return w.ResponseWriter.WriteMsg(tc)
+	default:
+		logging.CurLog.RRLDropped.Inc()
+		return nil
+	}
+}
+
+// responseKey identifies the responses which are rate limited together: the
+// answers of the same name and type, the name errors and the empty answers of
+// the same zone, and all the errors. Names in domain are of the zone domain,
+// and others of the zone of the SOA record of the response, if any.
+func responseKey(m *dns.Msg, domain string) string {
+	var q dns.Question
+	if len(m.Question) > 0 {
+		q = m.Question[0]
+	}
+	name := strings.ToLower(q.Name)
+
+	var kind string
+	switch {
+	case m.Rcode == dns.RcodeSuccess && len(m.Answer) > 0:
+		return name + " " + strconv.Itoa(int(q.Qtype))
+	case m.Rcode == dns.RcodeSuccess:
+		kind = "nodata"
+	case m.Rcode == dns.RcodeNameError:
+		kind = "nxdomain"
+	default:
+		return "error"
+	}
+
+	// a random subdomain of a zone is still the same zone
+	if domain != "" && dns.IsSubDomain(domain, name) {
+		return kind + " " + domain
+	}
+	for _, rr := range m.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return kind + " " + strings.ToLower(soa.Hdr.Name)
+		}
+	}
+	return kind
+}
@@ -0,0 +1,196 @@
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	. "github.com/mesosphere/mesos-dns/dnstest"
+	"github.com/miekg/dns"
+)
+
+// udpRecorder is a ResponseRecorder of queries received over UDP, recording
+// every message written.
+type udpRecorder struct {
+	ResponseRecorder
+	msgs []*dns.Msg
+}
+
+func (r *udpRecorder) RemoteAddr() net.Addr { return &net.UDPAddr{IP: r.Remote.IP} }
+
+func (r *udpRecorder) WriteMsg(m *dns.Msg) error {
+	r.msgs = append(r.msgs, m)
+	return nil
+}
+
+func TestLimiter(t *testing.T) {
+	if newLimiter(0, 10) != nil {
+		t.Error("got a limiter of rate 0")
+	}
+
+	now := time.Unix(0, 0)
+	l := newLimiter(2, 3)
+	l.now = func() time.Time { return now }
+	for i, tt := range []struct {
+		elapsed time.Duration
+		key     string
+		ok      bool
+		denied  int
+	}{
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", false, 1},
+		{0, "b", true, 0},
+		{100 * time.Millisecond, "a", false, 2},
+		{400 * time.Millisecond, "a", true, 0},
+		{0, "a", false, 1},
+		{10 * time.Second, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", false, 1},
+	} {
+		now = now.Add(tt.elapsed)
+		if ok, denied := l.take(tt.key); ok != tt.ok || denied != tt.denied {
+			t.Errorf("test #%d: got %t, %d, want %t, %d", i, ok, denied, tt.ok, tt.denied)
+		}
+	}
+
+	now = now.Add(2 * time.Second)
+	l.sweep(now)
+	if len(l.buckets) != 0 {
+		t.Errorf("got %d buckets after sweeping full ones, want 0", len(l.buckets))
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := res.cfg()
+	cfg.queries = newLimiter(1, 2)
+	cfg.rateLimitExempt = parseCIDRs([]string{"10.1.0.0/16"})
+	h := res.rateLimited(res.HandleMesos)
+
+	q := Message(Question("chronos.marathon.mesos.", dns.TypeA))
+	for i, tt := range []struct {
+		remote   string
+		udp      bool
+		answered bool
+		rcode    int
+	}{
+		{"10.0.0.1", true, true, dns.RcodeSuccess},
+		{"10.0.0.2", true, true, dns.RcodeSuccess},
+		{"10.0.0.3", true, false, 0}, // same /24 network
+		{"10.0.0.4", false, true, dns.RcodeRefused},
+		{"10.0.1.1", true, true, dns.RcodeSuccess},
+		{"10.1.2.3", true, true, dns.RcodeSuccess}, // exempt
+		{"10.1.2.3", true, true, dns.RcodeSuccess},
+		{"10.1.2.3", true, true, dns.RcodeSuccess},
+	} {
+		rec := ResponseRecorder{Remote: net.IPAddr{IP: net.ParseIP(tt.remote)}}
+		var got *dns.Msg
+		if tt.udp {
+			rw := &udpRecorder{ResponseRecorder: rec}
+			h(rw, q)
+			if len(rw.msgs) > 0 {
+				got = rw.msgs[0]
+			}
+		} else {
+			h(&rec, q)
+			got = rec.Msg
+		}
+		if (got != nil) != tt.answered {
+			t.Errorf("test #%d: got response %v, want answered %t", i, got, tt.answered)
+		} else if got != nil && got.Rcode != tt.rcode {
+			t.Errorf("test #%d: got rcode %s, want %s", i, dns.RcodeToString[got.Rcode], dns.RcodeToString[tt.rcode])
+		}
+	}
+}
+
+func TestRateLimited_RRL(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := res.cfg()
+	cfg.responses = newLimiter(1, 1)
+	cfg.RRLSlip = 2
+	h := res.rateLimited(res.HandleMesos)
+
+	rw := &udpRecorder{ResponseRecorder: ResponseRecorder{Remote: net.IPAddr{IP: net.ParseIP("10.0.0.1")}}}
+	for i := 0; i < 5; i++ {
+		h(rw, Message(Question("chronos.marathon.mesos.", dns.TypeA)))
+	}
+	// a different response isn't limited by the others
+	h(rw, Message(Question("chronos.marathon.mesos.", dns.TypeSRV)))
+
+	// the first response is sent, then every other one truncated
+	if len(rw.msgs) != 4 {
+		t.Fatalf("got %d responses, want 4", len(rw.msgs))
+	}
+	for i, tc := range []bool{false, true, true, false} {
+		if m := rw.msgs[i]; m.Truncated != tc || (tc && len(m.Answer) != 0) {
+			t.Errorf("response #%d: got %v, want truncated %t", i, m, tc)
+		}
+	}
+
+	// TCP responses aren't limited
+	for i := 0; i < 3; i++ {
+		rec := ResponseRecorder{Remote: net.IPAddr{IP: net.ParseIP("10.0.0.1")}}
+		h(&rec, Message(Question("chronos.marathon.mesos.", dns.TypeA)))
+		if rec.Msg == nil || rec.Msg.Truncated {
+			t.Errorf("TCP response #%d: got %v, want a full answer", i, rec.Msg)
+		}
+	}
+}
+
+func TestRateLimited_RRLNegative(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := res.cfg()
+	cfg.responses = newLimiter(1, 1)
+	cfg.RRLSlip = 0
+	h := res.rateLimited(res.HandleMesos)
+
+	// random nonexistent names of the Mesos domain share a single bucket
+	rw := &udpRecorder{ResponseRecorder: ResponseRecorder{Remote: net.IPAddr{IP: net.ParseIP("10.0.0.1")}}}
+	for i := 0; i < 5; i++ {
+		h(rw, Message(Question(fmt.Sprintf("random%d.marathon.mesos.", i), dns.TypeA)))
+	}
+	if len(rw.msgs) != 1 || rw.msgs[0].Rcode != dns.RcodeNameError {
+		t.Errorf("got responses %v, want a single NXDOMAIN", rw.msgs)
+	}
+}
+
+func TestResponseKey(t *testing.T) {
+	soa := func(zone string) dns.RR {
+		return &dns.SOA{Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET}}
+	}
+	a := &dns.A{Hdr: dns.RR_Header{Name: "a.mesos.", Rrtype: dns.TypeA, Class: dns.ClassINET}}
+	for i, tt := range []struct {
+		name  string
+		rcode int
+		ans   []dns.RR
+		ns    []dns.RR
+		want  string
+	}{
+		{"A.mesos.", dns.RcodeSuccess, []dns.RR{a}, nil, "a.mesos. 1"},
+		{"x.y.mesos.", dns.RcodeNameError, nil, []dns.RR{soa("x.y.mesos.")}, "nxdomain mesos."},
+		{"a.mesos.", dns.RcodeSuccess, nil, []dns.RR{soa("a.mesos.")}, "nodata mesos."},
+		{"x.example.com.", dns.RcodeNameError, nil, []dns.RR{soa("example.com.")}, "nxdomain example.com."},
+		{"x.example.com.", dns.RcodeSuccess, nil, []dns.RR{soa("Example.com.")}, "nodata example.com."},
+		{"x.example.com.", dns.RcodeNameError, nil, nil, "nxdomain"},
+		{"x.example.com.", dns.RcodeServerFailure, nil, nil, "error"},
+	} {
+		m := Message(Question(tt.name, dns.TypeA))
+		m.Rcode, m.Answer, m.Ns = tt.rcode, tt.ans, tt.ns
+		if got := responseKey(m, "mesos."); got != tt.want {
+			t.Errorf("test #%d: got key %q, want %q", i, got, tt.want)
+		}
+	}
+}
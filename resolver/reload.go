@@ -0,0 +1,81 @@
+package resolver
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/emicklei/go-restful"
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records"
+)
+
+// ReloadConfig re-reads and validates the configuration file and, unless it
+// changes fields which can't be changed without a restart, atomically swaps
+// it in along with the forwarder, zone transfer networks, secondaries and
+// DNSSEC keys derived from it. The SOA serial is kept. The new configuration
+// is then sent to ConfigReloads for the caller of Reload to pick up changes
+// of the refresh interval and master detection.
+func (res *Resolver) ReloadConfig() error {
+	res.reloadLock.Lock()
+	defer res.reloadLock.Unlock()
+
+	cur := res.cfg()
+	config, err := records.LoadConfig(cur.File)
+	if err != nil {
+		return err
+	}
+	if err = records.ValidateReload(cur.Config, config); err != nil {
+		return err
+	}
+	config.SOASerial = atomic.LoadUint32(&cur.SOASerial)
+
+	l, err := newLiveConfig(config)
+	if err != nil {
+		return err
+	}
+	if err = res.queryLog.configure(config); err != nil {
+		return fmt.Errorf("failed to open the query log: %v", err)
+	}
+	if config.LogLevel != cur.LogLevel || config.LogFormat != cur.LogFormat {
+		if err = logging.Configure(config.LogLevel, config.LogFormat); err != nil {
+			return err
+		}
+	}
+	res.live.Store(l)
+	// the records are generated anew from the new configuration by the next
+	// Reload, even if streamed
+	atomic.StoreInt32(&res.restream, 1)
+	logger.Info("config reloaded", "file", config.File)
+
+	// only the latest configuration matters to a slow receiver
+	select {
+	case <-res.reloads:
+	default:
+	}
+	res.reloads <- config
+	return nil
+}
+
+// ConfigReloads returns the channel to which the configurations swapped in
+// by ReloadConfig are sent.
+func (res *Resolver) ConfigReloads() <-chan records.Config {
+	return res.reloads
+}
+
+// RestReloadConfig handles HTTP requests to reload the configuration,
+// responding with the new configuration or, if it was rejected, the reason.
+func (res *Resolver) RestReloadConfig(req *restful.Request, resp *restful.Response) {
+	if err := res.ReloadConfig(); err != nil {
+		logger.Error("config reload failed", "error", err)
+		resp.WriteHeader(http.StatusBadRequest)
+		err = resp.WriteAsJson(map[string]string{"error": err.Error()})
+		if err != nil {
+			logger.Error("failed to write the response", "error", err)
+		}
+		return
+	}
+	if err := resp.WriteAsJson(res.cfg().Config); err != nil {
+		logger.Error("failed to write the response", "error", err)
+	}
+}
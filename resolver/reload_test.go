@@ -0,0 +1,162 @@
+package resolver
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/emicklei/go-restful"
+)
+
+func TestReloadConfig(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "mesos-dns-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	res.cfg().File = filepath.Join(dir, "config.json")
+	serial := res.cfg().SOASerial
+
+	reload := func(config string) (int, error) {
+		if err := ioutil.WriteFile(res.cfg().File, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+		rw := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v1/config/reload", nil)
+		res.RestReloadConfig(restful.NewRequest(req), restful.NewResponse(rw))
+		return rw.Code, res.ReloadConfig()
+	}
+
+	code, err := reload(`{"Masters": ["144.76.157.37:5050"], "TTL": 5, "ZoneTransferCIDRs": ["10.0.0.0/8"]}`)
+	if code != http.StatusOK || err != nil {
+		t.Fatalf("got %d and error %v, want a successful reload", code, err)
+	}
+	if cfg := res.cfg(); cfg.TTL != 5 || !cfg.xfrNets.contains(net.ParseIP("10.1.2.3")) || cfg.SOASerial != serial {
+		t.Errorf("reloaded config not swapped in: %+v", cfg.Config)
+	}
+	if rr, _ := res.formatA("foo.mesos.", "1.2.3.4"); rr.Hdr.Ttl != 5 {
+		t.Errorf("got TTL %d, want the reloaded TTL 5", rr.Hdr.Ttl)
+	}
+	select {
+	case config := <-res.ConfigReloads():
+		if config.TTL != 5 {
+			t.Errorf("got reloaded config %+v", config)
+		}
+	default:
+		t.Error("expected the reloaded config to be sent")
+	}
+
+	for i, config := range []string{
+		`{"Masters": ["144.76.157.37:5050"], "Port": 5353}`,
+		`{"Masters": ["144.76.157.37:5050"], "IPSources": ["bogus"]}`,
+		`{"Masters": `,
+	} {
+		if code, err := reload(config); code != http.StatusBadRequest || err == nil {
+			t.Errorf("test #%d: got %d and error %v, want the reload rejected", i, code, err)
+		}
+		if res.cfg().TTL != 5 {
+			t.Errorf("test #%d: rejected config swapped in", i)
+		}
+	}
+}
+
+func TestReload_Restream(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake, err := ioutil.ReadFile("../factories/fake.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state []byte
+
+	// a master serving its state, and an event stream without any event
+	var fetches, subscriptions int32
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/master/state":
+			atomic.AddInt32(&fetches, 1)
+			_, _ = w.Write(state)
+		case "/api/v1":
+			atomic.AddInt32(&subscriptions, 1)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			<-w.(http.CloseNotifier).CloseNotify()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer master.Close()
+	defer res.stopStream()
+	addr := master.Listener.Addr().String()
+	state = bytes.Replace(fake, []byte("master@1.2.3.4:5050"), []byte("master@"+addr), 1)
+
+	dir, err := ioutil.TempDir("", "mesos-dns-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	res.cfg().File = filepath.Join(dir, "config.json")
+	res.cfg().EventStreamOn = true
+
+	// waitFor waits for the stream to subscribe for the n-th time
+	waitFor := func(n int32) {
+		for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt32(&subscriptions) < n; {
+			if time.Now().After(deadline) {
+				t.Fatalf("got %d subscriptions, want %d", atomic.LoadInt32(&subscriptions), n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	res.SetMasters([]string{addr})
+	res.Reload()
+	waitFor(1)
+	res.Reload() // kept up to date by the stream
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("got %d state fetches while streaming, want 1", n)
+	}
+
+	// reloading the config stops the stream, and applies the new IP sources
+	config := `{"Masters": ["` + addr + `"], "EventStreamOn": true, "IPSources": ["host"]}`
+	if err = ioutil.WriteFile(res.cfg().File, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = res.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	res.Reload()
+	waitFor(2)
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("got %d state fetches after a config reload, want 2", n)
+	}
+	if got := res.records().As["liquor-store.marathon.mesos."]; len(got) != 2 || !hasKey(got, "1.2.3.11") {
+		t.Errorf("got A records %v, want the host IPs of the liquor-store tasks", got)
+	}
+
+	// so does a change of masters
+	res.SetMasters([]string{"", addr})
+	res.Reload()
+	waitFor(3)
+	if n := atomic.LoadInt32(&fetches); n != 3 {
+		t.Errorf("got %d state fetches after a change of masters, want 3", n)
+	}
+}
+
+func hasKey(m map[string]struct{}, key string) bool {
+	_, ok := m[key]
+	return ok
+}
@@ -2,6 +2,9 @@
 package resolver
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -18,34 +21,121 @@ import (
 	"github.com/mesosphere/mesos-dns/exchanger"
 	"github.com/mesosphere/mesos-dns/logging"
 	"github.com/mesosphere/mesos-dns/records"
+	"github.com/mesosphere/mesos-dns/records/state"
 	"github.com/mesosphere/mesos-dns/util"
 	"github.com/miekg/dns"
 )
 
+var logger = logging.Log.With("component", "resolver")
+
 // Resolver holds configuration state and the resource records
 type Resolver struct {
 	masters []string
 	version string
-	config  records.Config
-	rs      *records.RecordGenerator
-	rsLock  sync.RWMutex
-	rng     *rand.Rand
-	fwd     exchanger.Forwarder
+	// live holds the current *liveConfig, swapped as a whole by ReloadConfig
+	live   atomic.Value
+	rs     *records.RecordGenerator
+	rsLock sync.RWMutex
+	rng    *rand.Rand
+	// versions holds the latest record sets, the current one last
+	versions []zoneVersion
+	// reloaded is the time the records were last known to be up to date: of
+	// the last successful Reload or event stream heartbeat
+	reloaded time.Time
+	// leaderLost is the time since which no leading master is known
+	leaderLost time.Time
+	// reloadLock serializes Reload and ReloadConfig
+	reloadLock sync.Mutex
+	// reloads receives the configurations swapped in by ReloadConfig
+	reloads chan records.Config
+	// streaming is 1 while the records are kept up to date by the Mesos
+	// event stream rather than by Reload
+	streaming int32
+	// restream is 1 once the configuration or the masters changed, for Reload
+	// to stop the event stream and to generate the records anew
+	restream int32
+	// streamLock guards streamStop, closed to stop the event stream, if any,
+	// and streamDone, closed by the stream once stopped
+	streamLock             sync.Mutex
+	streamStop, streamDone chan struct{}
+	// handlers registers the DNS request handlers once, for the DNS servers
+	// and DNS-over-HTTPS
+	handlers sync.Once
+	// queryLog is kept across config reloads, which reconfigure it
+	queryLog *queryLog
+}
+
+// liveConfig is the configuration of a Resolver along with the state derived
+// from it, which are replaced together when the configuration is reloaded.
+type liveConfig struct {
+	records.Config
+	fwd exchanger.Forwarder
+	// zones are the forwarders of ForwardZones, by lowercased qualified zone
+	zones map[string]exchanger.Forwarder
+	// cache is nil unless ForwardCacheSize is set
+	cache   *exchanger.Cache
+	xfrNets cidrs
+	// secondaries are the host:port addresses sent NOTIFY messages
+	secondaries []string
+	notifier    exchanger.Exchanger
+	// signer is nil unless DNSSEC signing is enabled
+	signer *signer
+	// transport fetches the state of the Mesos masters
+	transport http.RoundTripper
+	// static is nil unless StaticRecordsFile is set
+	static *records.StaticFile
+	// cert is nil unless TLSCertFile is set
+	cert *tls.Certificate
+	// httpCert is nil unless HTTPCertFile is set
+	httpCert *tls.Certificate
+	// queries and responses limit the rate of the queries and of the UDP
+	// responses of each client network, unless nil
+	queries, responses *limiter
+	rateLimitExempt    cidrs
+	views              []view
+	adminNets          cidrs
 }
 
-// New returns a Resolver with the given version and configuration.
-func New(version string, config records.Config) *Resolver {
+// New returns a Resolver with the given version and configuration, or an error
+// if the state derived from the configuration fails to load.
+func New(version string, config records.Config) (*Resolver, error) {
 	var recordGenerator *records.RecordGenerator
 	recordGenerator = records.NewRecordGenerator(time.Duration(config.StateTimeoutSeconds) * time.Second)
 	r := &Resolver{
 		version: version,
-		config:  config,
 		rs:      recordGenerator,
 		// rand.Sources aren't safe for concurrent use, except the global one.
 		// See: https://github.com/golang/go/issues/3611
-		rng:     rand.New(&lockedSource{src: rand.NewSource(time.Now().UnixNano())}),
-		masters: append([]string{""}, config.Masters...),
+		rng:      rand.New(&lockedSource{src: rand.NewSource(time.Now().UnixNano())}),
+		masters:  append([]string{""}, config.Masters...),
+		reloads:  make(chan records.Config, 1),
+		queryLog: newQueryLog(),
 	}
+	r.versions = []zoneVersion{{config.SOASerial, recordGenerator}}
+	r.leaderLost = time.Now()
+
+	l, err := newLiveConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	r.live.Store(l)
+	if err = r.queryLog.configure(config); err != nil {
+		return nil, fmt.Errorf("failed to open the query log: %v", err)
+	}
+
+	return r, nil
+}
+
+// newLiveConfig returns the given configuration along with the state derived
+// from it.
+func newLiveConfig(config records.Config) (*liveConfig, error) {
+	l := &liveConfig{Config: config}
+	l.xfrNets = parseCIDRs(config.ZoneTransferCIDRs)
+	l.queries = newLimiter(config.RateLimitQPS, config.RateLimitBurst)
+	l.responses = newLimiter(config.RRLResponsesPerSecond, 0)
+	l.rateLimitExempt = parseCIDRs(config.RateLimitExemptCIDRs)
+	l.views = parseViews(config.Views)
+	l.adminNets = parseCIDRs(config.AdminCIDRs)
 
 	timeout := 5 * time.Second
 	if config.Timeout != 0 {
@@ -56,32 +146,194 @@ func New(version string, config records.Config) *Resolver {
 	if !config.ExternalOn {
 		rs = rs[:0]
 	}
-	r.fwd = exchanger.NewForwarder(rs, exchangers(timeout, "udp", "tcp"))
+	if config.ForwardCacheSize > 0 {
+		l.cache = exchanger.NewCache(config.ForwardCacheSize)
+	}
+	roots, err := records.ForwardRootCAs(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the CA certificates of the resolvers: %v", err)
+	}
+	l.fwd = exchanger.NewForwarder(rs, exchangers(timeout, l.cache, roots, networks...),
+		forwarderOptions(config, config.ForwardStrategy)...)
+
+	l.zones = make(map[string]exchanger.Forwarder, len(config.ForwardZones))
+	if config.ExternalOn {
+		for zone, z := range config.ForwardZones {
+			l.zones[strings.ToLower(dns.Fqdn(zone))] = zoneForwarder(config, z, timeout, l.cache, roots)
+		}
+	}
+
+	for _, s := range config.NotifySecondaries {
+		if addr, err := records.SecondaryAddr(s); err == nil {
+			l.secondaries = append(l.secondaries, addr)
+		}
+	}
+	l.notifier = &dns.Client{
+		Net:          "udp",
+		DialTimeout:  timeout,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+	}
 
-	return r
+	if len(config.DNSSECKeys) > 0 {
+		s, err := newSigner(config.Domain, uint32(config.TTL), config.DNSSECKeys, config.DNSSECNSEC3)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load DNSSEC keys: %v", err)
+		}
+		// the records of every view, both before and after a reload
+		s.maxCaches = 2 * (len(l.views) + 1)
+		l.signer = s
+	}
+
+	t, err := records.NewTransport(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure the Mesos state fetching: %v", err)
+	}
+	l.transport = t
+
+	if config.StaticRecordsFile != "" {
+		if l.static, err = records.NewStaticFile(config.StaticRecordsFile, config.Domain); err != nil {
+			return nil, fmt.Errorf("failed to load static records: %v", err)
+		}
+	}
+
+	if config.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the TLS certificate: %v", err)
+		}
+		l.cert = &cert
+	}
+	if config.HTTPCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.HTTPCertFile, config.HTTPKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the HTTP certificate: %v", err)
+		}
+		l.httpCert = &cert
+	}
+
+	return l, nil
+}
+
+// recordGenerator returns a RecordGenerator fetching the state of the Mesos
+// masters as configured.
+func (l *liveConfig) recordGenerator() *records.RecordGenerator {
+	return records.NewRecordGenerator(
+		time.Duration(l.StateTimeoutSeconds)*time.Second,
+		records.WithTransport(l.transport),
+		records.WithHTTPS(l.MesosHTTPSOn),
+		records.WithStaticRecords(l.static.Records()),
+	)
 }
 
-func exchangers(timeout time.Duration, protos ...string) map[string]exchanger.Exchanger {
+// cfg returns the current configuration. It may be replaced at any time by
+// ReloadConfig, so callers needing consistent values should call it once.
+// A zero Resolver has an empty configuration.
+func (res *Resolver) cfg() *liveConfig {
+	if l, ok := res.live.Load().(*liveConfig); ok {
+		return l
+	}
+	return &liveConfig{}
+}
+
+// networks are the protocols and the URL schemes of the upstream resolvers.
+var networks = []string{"udp", "tcp", "tls", "https"}
+
+// exchangers returns the instrumented Exchangers of the given networks with
+// the given timeout, caching their responses in the given Cache unless it's
+// nil. Encrypted ones verify server certificates with the given roots, or
+// the system ones if nil.
+func exchangers(timeout time.Duration, cache *exchanger.Cache, roots *x509.CertPool, protos ...string) map[string]exchanger.Exchanger {
 	exs := make(map[string]exchanger.Exchanger, len(protos))
 	for _, proto := range protos {
-		exs[proto] = exchanger.Decorate(
-			&dns.Client{
-				Net:          proto,
-				DialTimeout:  timeout,
-				ReadTimeout:  timeout,
-				WriteTimeout: timeout,
-			},
-			exchanger.ErrorLogging(logging.Error),
+		ds := []exchanger.Decorator{
+			exchanger.ErrorLogging(logger),
 			exchanger.Instrumentation(
 				logging.CurLog.NonMesosForwarded,
 				logging.CurLog.NonMesosSuccess,
 				logging.CurLog.NonMesosFailed,
+				logging.ForwardLatency,
 			),
-		)
+		}
+		if cache != nil {
+			// outermost, so that cache hits aren't counted as forwarded
+			ds = append(ds, exchanger.Caching(cache,
+				logging.CurLog.ForwardCacheHits,
+				logging.CurLog.ForwardCacheMisses,
+			))
+		}
+		var ex exchanger.Exchanger
+		switch proto {
+		case "tls":
+			ex = exchanger.TLS(timeout, roots)
+		case "https":
+			ex = exchanger.HTTPS(timeout, roots)
+		default:
+			ex = &dns.Client{
+				Net:          proto,
+				DialTimeout:  timeout,
+				ReadTimeout:  timeout,
+				WriteTimeout: timeout,
+			}
+		}
+		exs[proto] = exchanger.Decorate(ex, ds...)
 	}
 	return exs
 }
 
+// forwarderOptions returns the options of the Forwarders of the given
+// configuration, selecting upstream resolvers with the given strategy.
+func forwarderOptions(config records.Config, strategy string) []exchanger.ForwarderOption {
+	opts := []exchanger.ForwarderOption{
+		exchanger.WithBreaker(
+			config.ForwardBreakerFailures,
+			time.Duration(config.ForwardBreakerCooldownSeconds)*time.Second,
+			logging.CurLog.ForwardBreakerTrips,
+		),
+	}
+	if s, err := exchanger.ParseStrategy(strategy); err == nil {
+		opts = append(opts, exchanger.WithStrategy(s))
+	}
+	return opts
+}
+
+// zoneForwarder returns the Forwarder of the given forwarded zone, whose
+// timeout and strategy default to the given configuration ones, caching its
+// responses in the given Cache unless it's nil and verifying the certificates
+// of encrypted resolvers with the given roots.
+func zoneForwarder(config records.Config, z records.ForwardZone, timeout time.Duration, cache *exchanger.Cache, roots *x509.CertPool) exchanger.Forwarder {
+	if z.Timeout != 0 {
+		timeout = time.Duration(z.Timeout) * time.Second
+	}
+	exs := exchangers(timeout, cache, roots, networks...)
+	if z.Protocol != "" {
+		exs["udp"], exs["tcp"] = exs[z.Protocol], exs[z.Protocol]
+	}
+	addrs := make([]string, 0, len(z.Resolvers))
+	for _, r := range z.Resolvers {
+		if addr, err := records.ResolverAddr(r); err == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+	strategy := config.ForwardStrategy
+	if z.Strategy != "" {
+		strategy = z.Strategy
+	}
+	return exchanger.NewForwarder(addrs, exs, forwarderOptions(config, strategy)...)
+}
+
+// forwarder returns the Forwarder of the longest forwarded zone containing
+// the given name, or the one of the Resolvers if there's none.
+func (l *liveConfig) forwarder(name string) exchanger.Forwarder {
+	name = strings.ToLower(dns.Fqdn(name))
+	for off, end := 0, false; !end; off, end = dns.NextLabel(name, off) {
+		if fwd, ok := l.zones[name[off:]]; ok {
+			return fwd
+		}
+	}
+	return l.fwd
+}
+
 // return the current (read-only) record set. attempts to write to the returned
 // object will likely result in a data race.
 func (res *Resolver) records() *records.RecordGenerator {
@@ -93,28 +345,52 @@ func (res *Resolver) records() *records.RecordGenerator {
 // LaunchDNS starts a (TCP and UDP) DNS server for the Resolver,
 // returning a error channel to which errors are asynchronously sent.
 func (res *Resolver) LaunchDNS() <-chan error {
-	// Handers for Mesos requests
-	dns.HandleFunc(res.config.Domain+".", panicRecover(res.HandleMesos))
-	// Handler for nonMesos requests
-	dns.HandleFunc(".", panicRecover(res.HandleNonMesos))
+	res.handlers.Do(res.registerHandlers)
 
-	errCh := make(chan error, 2)
+	errCh := make(chan error, 3)
 	_, e1 := res.Serve("tcp")
 	go func() { errCh <- <-e1 }()
 	_, e2 := res.Serve("udp")
 	go func() { errCh <- <-e2 }()
+	if res.cfg().DoTOn {
+		_, e3 := res.Serve("tls")
+		go func() { errCh <- <-e3 }()
+	}
 	return errCh
 }
 
-// Serve starts a DNS server for net protocol (tcp/udp), returns immediately.
+// registerHandlers registers the DNS request handlers of the Resolver.
+func (res *Resolver) registerHandlers() {
+	// Handers for Mesos requests
+	dns.HandleFunc(res.cfg().Domain+".", res.rateLimited(res.logged(panicRecover(res.HandleMesos))))
+	// Handlers for reverse lookups of Mesos addresses
+	dns.HandleFunc("in-addr.arpa.", res.rateLimited(res.logged(panicRecover(res.HandlePTR))))
+	dns.HandleFunc("ip6.arpa.", res.rateLimited(res.logged(panicRecover(res.HandlePTR))))
+	// Handlers for the zones forwarded to their own resolvers, except for
+	// reverse zones which must go through HandlePTR first
+	for zone := range res.cfg().zones {
+		if !dns.IsSubDomain("in-addr.arpa.", zone) && !dns.IsSubDomain("ip6.arpa.", zone) {
+			dns.HandleFunc(zone, res.rateLimited(res.logged(panicRecover(res.HandleZone(zone)))))
+		}
+	}
+	// Handler for nonMesos requests
+	dns.HandleFunc(".", res.rateLimited(res.logged(panicRecover(res.HandleNonMesos))))
+}
+
+// Serve starts a DNS server for net protocol (tcp/udp/tls), returns immediately.
 // the returned signal chan is closed upon the server successfully entering the listening phase.
 // if the server aborts then an error is sent on the error chan.
+// tls serves DNS-over-TLS on DoTPort rather than Port.
 func (res *Resolver) Serve(proto string) (<-chan struct{}, <-chan error) {
 	defer util.HandleCrash()
 
+	if proto == "tls" {
+		return res.serveTLS(net.JoinHostPort(res.cfg().Listener, strconv.Itoa(res.cfg().DoTPort)))
+	}
+
 	ch := make(chan struct{})
 	server := &dns.Server{
-		Addr:              net.JoinHostPort(res.config.Listener, strconv.Itoa(res.config.Port)),
+		Addr:              net.JoinHostPort(res.cfg().Listener, strconv.Itoa(res.cfg().Port)),
 		Net:               proto,
 		TsigSecret:        nil,
 		NotifyStartedFunc: func() { close(ch) },
@@ -127,41 +403,150 @@ func (res *Resolver) Serve(proto string) (<-chan struct{}, <-chan error) {
 		if err != nil {
 			errCh <- fmt.Errorf("Failed to setup %q server: %v", proto, err)
 		} else {
-			logging.Error.Printf("Not listening/serving any more requests.")
+			logger.Error("not serving DNS requests any more", "proto", proto)
 		}
 	}()
 	return ch, errCh
 }
 
-// SetMasters sets the given masters.
-// This method is not goroutine-safe.
+// SetMasters sets the given masters, the leading one first or empty if
+// unknown. This method is not goroutine-safe with regard to Reload.
 func (res *Resolver) SetMasters(masters []string) {
+	res.rsLock.Lock()
+	defer res.rsLock.Unlock()
+	if leader(masters) == "" && leader(res.masters) != "" {
+		res.leaderLost = time.Now()
+	}
+	if strings.Join(masters, ",") != strings.Join(res.masters, ",") {
+		atomic.StoreInt32(&res.restream, 1)
+	}
 	res.masters = masters
 }
 
-// Reload triggers a new state load from the configured mesos masters.
-// This method is not goroutine-safe.
+// Reload triggers a new state load from the configured mesos masters. If
+// EventStreamOn is set, it then subscribes to the event stream of the leading
+// master, and does nothing until the stream drops, unless the static records
+// file, the configuration or the masters changed, in which case the stream is
+// stopped first.
+// This method is not goroutine-safe with regard to SetMasters.
 func (res *Resolver) Reload() {
-	t := records.NewRecordGenerator(time.Duration(res.config.StateTimeoutSeconds) * time.Second)
-	err := t.ParseState(res.config, res.masters...)
+	changed, err := res.cfg().static.Refresh()
+	if err != nil {
+		logger.Warn("failed to load the static records, keeping the old ones", "error", err)
+	}
+	restream := atomic.SwapInt32(&res.restream, 0) == 1
+	if atomic.LoadInt32(&res.streaming) == 1 {
+		if restream {
+			res.stopStream()
+		} else if !changed {
+			return
+		}
+	}
+
+	res.reloadLock.Lock()
+	defer res.reloadLock.Unlock()
+
+	cfg := res.cfg()
+	config := cfg.Config
+	t := cfg.recordGenerator()
+	err = t.ParseState(config, res.masters...)
 
 	if err == nil {
-		timestamp := uint32(time.Now().Unix())
-		// may need to refactor for fairness
-		res.rsLock.Lock()
-		defer res.rsLock.Unlock()
-		atomic.StoreUint32(&res.config.SOASerial, timestamp)
-		res.rs = t
+		res.update(t)
+		if config.EventStreamOn && atomic.LoadInt32(&res.streaming) == 0 {
+			res.stream(t, res.masters)
+		}
 	} else {
-		logging.Error.Printf("Warning: Error generating records: %v; keeping old DNS state", err)
+		logger.Warn("failed to generate the records, keeping the old ones", "error", err)
 	}
 
 	logging.PrintCurLog()
 }
 
-// formatSRV returns the SRV resource record for target
-func (res *Resolver) formatSRV(name string, target string) (*dns.SRV, error) {
-	ttl := uint32(res.config.TTL)
+// stream asynchronously keeps the records up to date from the event stream
+// of the leading master among masters, falling back to Reload once it drops.
+func (res *Resolver) stream(rg *records.RecordGenerator, masters []string) {
+	stop, done := make(chan struct{}), make(chan struct{})
+	res.streamLock.Lock()
+	res.streamStop, res.streamDone = stop, done
+	res.streamLock.Unlock()
+
+	atomic.StoreInt32(&res.streaming, 1)
+	go func() {
+		defer close(done)
+		defer atomic.StoreInt32(&res.streaming, 0)
+		err := rg.Stream(func(sj state.State) error {
+			res.reloadLock.Lock()
+			defer res.reloadLock.Unlock()
+
+			cfg := res.cfg()
+			config := cfg.Config
+			if !config.EventStreamOn {
+				return errors.New("disabled by a config reload")
+			}
+			t := cfg.recordGenerator()
+			if err := t.ConvertState(sj, config, masters...); err != nil {
+				logger.Warn("failed to generate the records, keeping the old ones", "error", err)
+			} else {
+				res.update(t)
+			}
+			return nil
+		}, res.touch, stop, masters...)
+		if err == records.ErrStreamStopped {
+			logger.Info("Mesos event stream stopped to reload the records")
+			return
+		}
+		logging.CurLog.StreamDropped.Inc()
+		logger.Error("Mesos event stream dropped, falling back to polling", "error", err)
+	}()
+}
+
+// stopStream stops the event stream, if any, and waits for it to stop.
+func (res *Resolver) stopStream() {
+	res.streamLock.Lock()
+	stop, done := res.streamStop, res.streamDone
+	res.streamStop, res.streamDone = nil, nil
+	res.streamLock.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// update replaces the current record set with t. The SOA serial is only
+// bumped, and the secondaries notified, if t changes the records served.
+func (res *Resolver) update(t *records.RecordGenerator) {
+	// may need to refactor for fairness
+	res.rsLock.Lock()
+	changed := t.Changed(res.rs)
+	serial := atomic.LoadUint32(&res.cfg().SOASerial)
+	if changed {
+		serial = nextSerial(serial, time.Now())
+		atomic.StoreUint32(&res.cfg().SOASerial, serial)
+		res.versions = appendVersion(res.versions, zoneVersion{serial, t})
+	}
+	res.rs = t
+	res.reloaded = time.Now()
+	res.rsLock.Unlock()
+
+	if changed {
+		logger.Debug("records changed", "serial", serial)
+		res.notify(serial)
+	}
+}
+
+// touch marks the current records as up to date, as on a heartbeat of the
+// event stream, without replacing them.
+func (res *Resolver) touch() {
+	res.rsLock.Lock()
+	res.reloaded = time.Now()
+	res.rsLock.Unlock()
+}
+
+// formatSRV returns the SRV resource record for target with the given
+// preference
+func (res *Resolver) formatSRV(name string, target string, pref records.SRVPreference) (*dns.SRV, error) {
+	ttl := uint32(res.cfg().TTL)
 
 	h, port, err := net.SplitHostPort(target)
 	if err != nil {
@@ -176,8 +561,8 @@ func (res *Resolver) formatSRV(name string, target string) (*dns.SRV, error) {
 			Class:  dns.ClassINET,
 			Ttl:    ttl,
 		},
-		Priority: 0,
-		Weight:   0,
+		Priority: pref.Priority,
+		Weight:   pref.Weight,
 		Port:     uint16(p),
 		Target:   h,
 	}, nil
@@ -186,7 +571,7 @@ func (res *Resolver) formatSRV(name string, target string) (*dns.SRV, error) {
 // returns the A resource record for target
 // assumes target is a well formed IPv4 address
 func (res *Resolver) formatA(dom string, target string) (*dns.A, error) {
-	ttl := uint32(res.config.TTL)
+	ttl := uint32(res.cfg().TTL)
 
 	a := net.ParseIP(target)
 	if a == nil {
@@ -203,9 +588,74 @@ func (res *Resolver) formatA(dom string, target string) (*dns.A, error) {
 	}, nil
 }
 
+// returns the AAAA resource record for target
+// assumes target is a well formed IPv6 address
+func (res *Resolver) formatAAAA(dom string, target string) (*dns.AAAA, error) {
+	ttl := uint32(res.cfg().TTL)
+
+	a := net.ParseIP(target)
+	if a == nil || a.To4() != nil {
+		return nil, errors.New("invalid target")
+	}
+
+	return &dns.AAAA{
+		Hdr: dns.RR_Header{
+			Name:   dom,
+			Rrtype: dns.TypeAAAA,
+			Class:  dns.ClassINET,
+			Ttl:    ttl},
+		AAAA: a.To16(),
+	}, nil
+}
+
+// formatTXT returns the TXT resource record holding the single string txt
+func (res *Resolver) formatTXT(dom string, txt string) *dns.TXT {
+	ttl := uint32(res.cfg().TTL)
+
+	return &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   dom,
+			Rrtype: dns.TypeTXT,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		Txt: []string{txt},
+	}
+}
+
+// formatPTR returns the PTR resource record pointing dom at target
+func (res *Resolver) formatPTR(dom string, target string) *dns.PTR {
+	ttl := uint32(res.cfg().TTL)
+
+	return &dns.PTR{
+		Hdr: dns.RR_Header{
+			Name:   dom,
+			Rrtype: dns.TypePTR,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		Ptr: target,
+	}
+}
+
+// formatCNAME returns the CNAME resource record of dom pointing to target
+func (res *Resolver) formatCNAME(dom string, target string) *dns.CNAME {
+	ttl := uint32(res.cfg().TTL)
+
+	return &dns.CNAME{
+		Hdr: dns.RR_Header{
+			Name:   dom,
+			Rrtype: dns.TypeCNAME,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		Target: target,
+	}
+}
+
 // formatSOA returns the SOA resource record for the mesos domain
 func (res *Resolver) formatSOA(dom string) *dns.SOA {
-	ttl := uint32(res.config.TTL)
+	ttl := uint32(res.cfg().TTL)
 
 	return &dns.SOA{
 		Hdr: dns.RR_Header{
@@ -214,19 +664,19 @@ func (res *Resolver) formatSOA(dom string) *dns.SOA {
 			Class:  dns.ClassINET,
 			Ttl:    ttl,
 		},
-		Ns:      res.config.SOAMname,
-		Mbox:    res.config.SOARname,
-		Serial:  atomic.LoadUint32(&res.config.SOASerial),
-		Refresh: res.config.SOARefresh,
-		Retry:   res.config.SOARetry,
-		Expire:  res.config.SOAExpire,
+		Ns:      res.cfg().SOAMname,
+		Mbox:    res.cfg().SOARname,
+		Serial:  atomic.LoadUint32(&res.cfg().SOASerial),
+		Refresh: res.cfg().SOARefresh,
+		Retry:   res.cfg().SOARetry,
+		Expire:  res.cfg().SOAExpire,
 		Minttl:  ttl,
 	}
 }
 
 // formatNS returns the NS  record for the mesos domain
 func (res *Resolver) formatNS(dom string) *dns.NS {
-	ttl := uint32(res.config.TTL)
+	ttl := uint32(res.cfg().TTL)
 
 	return &dns.NS{
 		Hdr: dns.RR_Header{
@@ -235,7 +685,7 @@ func (res *Resolver) formatNS(dom string) *dns.NS {
 			Class:  dns.ClassINET,
 			Ttl:    ttl,
 		},
-		Ns: res.config.SOAMname,
+		Ns: res.cfg().SOAMname,
 	}
 }
 
@@ -251,10 +701,37 @@ func shuffleAnswers(rng *rand.Rand, answers []dns.RR) []dns.RR {
 }
 
 // HandleNonMesos handles non-mesos queries by forwarding to configured
-// external DNS servers.
+// external DNS servers: those of the longest forwarded zone containing the
+// queried name, if any, and the Resolvers otherwise.
 func (res *Resolver) HandleNonMesos(w dns.ResponseWriter, r *dns.Msg) {
+	res.forward(w, r, res.cfg().forwarder(r.Question[0].Name))
+}
+
+// HandleZone returns a request handler forwarding the queries of the given
+// forwarded zone to its external DNS servers. Once the zone is no longer
+// forwarded, e.g. after a configuration reload, it falls back to
+// HandleNonMesos.
+func (res *Resolver) HandleZone(zone string) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		if fwd, ok := res.cfg().zones[zone]; ok {
+			res.forward(w, r, fwd)
+		} else {
+			res.HandleNonMesos(w, r)
+		}
+	}
+}
+
+// forward replies to r with the response of the given Forwarder, or refuses
+// it if the view of the client doesn't allow forwarding.
+func (res *Resolver) forward(w dns.ResponseWriter, r *dns.Msg, fwd exchanger.Forwarder) {
 	logging.CurLog.NonMesosRequests.Inc()
-	m, err := res.fwd(r, w.RemoteAddr().Network())
+	if !res.cfg().forwarding(addrIP(w.RemoteAddr())) {
+		logging.CurLog.NonMesosFailed.Inc()
+		reply(w, new(dns.Msg).SetRcode(r, dns.RcodeRefused))
+		return
+	}
+	m, upstream, err := fwd(r, w.RemoteAddr().Network())
+	forwardedTo(w, upstream)
 	if err != nil {
 		m = new(dns.Msg).SetRcode(r, rcode(err))
 	} else if len(m.Answer) == 0 {
@@ -274,80 +751,148 @@ func rcode(err error) int {
 
 // HandleMesos is a resolver request handler that responds to a resource
 // question with resource answer(s)
-// it can handle {A, SRV, ANY}
+// it can handle {A, AAAA, CNAME, SRV, TXT, SOA, NS, ANY}
 func (res *Resolver) HandleMesos(w dns.ResponseWriter, r *dns.Msg) {
 	logging.CurLog.MesosRequests.Inc()
 
+	switch r.Question[0].Qtype {
+	case dns.TypeAXFR, dns.TypeIXFR:
+		res.handleXFR(w, r)
+		return
+	}
+
+	ip := addrIP(w.RemoteAddr())
 	m := &dns.Msg{MsgHdr: dns.MsgHdr{
 		Authoritative:      true,
-		RecursionAvailable: res.config.RecurseOn,
+		RecursionAvailable: res.cfg().RecurseOn && res.cfg().forwarding(ip),
 	}}
 	m.SetReply(r)
 
 	var errs multiError
-	rs := res.records()
+	rs := res.clientRecords(ip)
+	s := res.signing(r)
 	name := strings.ToLower(cleanWild(r.Question[0].Name))
-	switch r.Question[0].Qtype {
+	q := res.chaseCNAMEs(rs, name, m, r)
+	chain := len(m.Answer) // the CNAME chain stays in order, ahead of its answers
+	if q != r {
+		name = q.Question[0].Name
+	}
+	switch q.Question[0].Qtype {
 	case dns.TypeSRV:
-		errs.Add(res.handleSRV(rs, name, m, r))
+		errs.Add(res.handleSRV(rs, name, m, q))
 	case dns.TypeA:
 		errs.Add(res.handleA(rs, name, m))
+	case dns.TypeAAAA:
+		errs.Add(res.handleAAAA(rs, name, m))
+	case dns.TypeTXT:
+		errs.Add(res.handleTXT(rs, name, m))
+	case dns.TypeCNAME:
+		errs.Add(res.handleCNAME(rs, name, m))
 	case dns.TypeSOA:
-		errs.Add(res.handleSOA(m, r))
+		errs.Add(res.handleSOA(m, q))
 	case dns.TypeNS:
-		errs.Add(res.handleNS(m, r))
+		errs.Add(res.handleNS(m, q))
+	case dns.TypeDNSKEY:
+		errs.Add(res.handleDNSKEY(name, m))
 	case dns.TypeANY:
 		errs.Add(
-			res.handleSRV(rs, name, m, r),
+			res.handleSRV(rs, name, m, q),
 			res.handleA(rs, name, m),
-			res.handleSOA(m, r),
-			res.handleNS(m, r),
+			res.handleAAAA(rs, name, m),
+			res.handleTXT(rs, name, m),
+			res.handleCNAME(rs, name, m),
+			res.handleSOA(m, q),
+			res.handleNS(m, q),
+			res.handleDNSKEY(name, m),
 		)
 	}
 
 	if len(m.Answer) == 0 {
-		errs.Add(res.handleEmpty(rs, name, m, r))
+		errs.Add(res.handleEmpty(rs, s, name, m, r))
 	} else {
-		shuffleAnswers(res.rng, m.Answer)
+		shuffleAnswers(res.rng, m.Answer[chain:])
 		logging.CurLog.MesosSuccess.Inc()
 	}
 
 	if !errs.Nil() {
-		logging.Error.Println(errs.Error())
+		logger.Error("failed to answer", "question", r.Question[0].String(), "error", errs.Error())
 		logging.CurLog.MesosFailed.Inc()
 	}
 
+	if s != nil {
+		s.sign(rs, m, r)
+	}
+
+	reply(w, m)
+}
+
+// HandlePTR is a resolver request handler that answers reverse lookups of
+// addresses known to Mesos with the names of the tasks, slaves and masters
+// using them. Any other reverse lookup is forwarded by HandleNonMesos.
+func (res *Resolver) HandlePTR(w dns.ResponseWriter, r *dns.Msg) {
+	ip := addrIP(w.RemoteAddr())
+	rs := res.clientRecords(ip)
+	name := strings.ToLower(r.Question[0].Name)
+	qType := r.Question[0].Qtype
+	if (qType != dns.TypePTR && qType != dns.TypeANY) || len(rs.PTRs[name]) == 0 {
+		res.HandleNonMesos(w, r)
+		return
+	}
+
+	logging.CurLog.MesosRequests.Inc()
+
+	m := &dns.Msg{MsgHdr: dns.MsgHdr{
+		Authoritative:      true,
+		RecursionAvailable: res.cfg().RecurseOn && res.cfg().forwarding(ip),
+	}}
+	m.SetReply(r)
+
+	for target := range rs.PTRs[name] {
+		m.Answer = append(m.Answer, res.formatPTR(r.Question[0].Name, target))
+	}
+	shuffleAnswers(res.rng, m.Answer)
+	logging.CurLog.MesosSuccess.Inc()
+
 	reply(w, m)
 }
 
 func (res *Resolver) handleSRV(rs *records.RecordGenerator, name string, m, r *dns.Msg) error {
 	var errs multiError
-	added := map[string]struct{}{} // track the A RR's we've already added, avoid dups
+	added := map[string]struct{}{} // track the A/AAAA RR's we've already added, avoid dups
 	for srv := range rs.SRVs[name] {
-		srvRR, err := res.formatSRV(r.Question[0].Name, srv)
+		srvRR, err := res.formatSRV(r.Question[0].Name, srv, rs.SRVPreferences.Get(name, srv))
 		if err != nil {
 			errs.Add(err)
 			continue
 		}
 
 		m.Answer = append(m.Answer, srvRR)
-		host := strings.Split(srv, ":")[0]
-		if _, found := added[host]; found {
+		if _, found := added[srvRR.Target]; found {
 			// avoid dups
 			continue
 		}
-		if len(rs.As[host]) == 0 {
+		added[srvRR.Target] = struct{}{}
+		chain, host := res.cnameChain(rs, srvRR.Target)
+		if len(rs.As[host])+len(rs.AAAAs[host]) == 0 {
 			continue
 		}
+		m.Extra = append(m.Extra, chain...)
 
 		if a, ok := rs.As.First(host); ok {
 			aRR, err := res.formatA(host, a)
 			if err != nil {
 				errs.Add(err)
-				continue
+			} else {
+				m.Extra = append(m.Extra, aRR)
+			}
+		}
+		if aaaa, ok := rs.AAAAs.First(host); ok {
+			aaaaRR, err := res.formatAAAA(host, aaaa)
+			if err != nil {
+				errs.Add(err)
+			} else {
+				m.Extra = append(m.Extra, aaaaRR)
 			}
-			m.Extra = append(m.Extra, aRR)
-			added[host] = struct{}{}
 		}
 	}
 	return errs
@@ -366,6 +911,70 @@ func (res *Resolver) handleA(rs *records.RecordGenerator, name string, m *dns.Ms
 	return errs
 }
 
+func (res *Resolver) handleAAAA(rs *records.RecordGenerator, name string, m *dns.Msg) error {
+	var errs multiError
+	for aaaa := range rs.AAAAs[name] {
+		rr, err := res.formatAAAA(name, aaaa)
+		if err != nil {
+			errs.Add(err)
+			continue
+		}
+		m.Answer = append(m.Answer, rr)
+	}
+	return errs
+}
+
+func (res *Resolver) handleTXT(rs *records.RecordGenerator, name string, m *dns.Msg) error {
+	for txt := range rs.TXTs[name] {
+		m.Answer = append(m.Answer, res.formatTXT(name, txt))
+	}
+	return nil
+}
+
+func (res *Resolver) handleCNAME(rs *records.RecordGenerator, name string, m *dns.Msg) error {
+	for target := range rs.CNAMEs[name] {
+		m.Answer = append(m.Answer, res.formatCNAME(name, target))
+	}
+	return nil
+}
+
+// maxCNAMEChain is the maximum number of CNAME records chased in a response.
+const maxCNAMEChain = 8
+
+// chaseCNAMEs answers the query r for the given name with the chain of CNAME
+// records starting at it, unless it's for CNAME or ANY records. It returns r
+// as a query for the last target of the chain, to be answered next if it's
+// in the Mesos domain, or r itself if there's no chain.
+func (res *Resolver) chaseCNAMEs(rs *records.RecordGenerator, name string, m, r *dns.Msg) *dns.Msg {
+	if qType := r.Question[0].Qtype; qType == dns.TypeCNAME || qType == dns.TypeANY {
+		return r
+	}
+	chain, target := res.cnameChain(rs, name)
+	if len(chain) == 0 {
+		return r
+	}
+	m.Answer = append(m.Answer, chain...)
+	q := r.Copy()
+	q.Question[0].Name = target
+	return q
+}
+
+// cnameChain returns the chain of CNAME records starting at the given name,
+// up to maxCNAMEChain records, and its last target, which is the name itself
+// if there's no chain.
+func (res *Resolver) cnameChain(rs *records.RecordGenerator, name string) ([]dns.RR, string) {
+	var chain []dns.RR
+	for i := 0; i < maxCNAMEChain; i++ {
+		target, ok := rs.CNAMEs.First(name)
+		if !ok {
+			break
+		}
+		chain = append(chain, res.formatCNAME(name, target))
+		name = target
+	}
+	return chain, name
+}
+
 func (res *Resolver) handleSOA(m, r *dns.Msg) error {
 	m.Ns = append(m.Ns, res.formatSOA(r.Question[0].Name))
 	return nil
@@ -376,39 +985,46 @@ func (res *Resolver) handleNS(m, r *dns.Msg) error {
 	return nil
 }
 
-func (res *Resolver) handleEmpty(rs *records.RecordGenerator, name string, m, r *dns.Msg) error {
+// handleEmpty handles queries without answers, proving their denial with s
+// unless it's nil.
+func (res *Resolver) handleEmpty(rs *records.RecordGenerator, s *signer, name string, m, r *dns.Msg) error {
 	qType := r.Question[0].Qtype
 	switch qType {
-	case dns.TypeSOA, dns.TypeNS, dns.TypeSRV:
+	case dns.TypeSOA, dns.TypeNS:
 		logging.CurLog.MesosSuccess.Inc()
 		return nil
+	case dns.TypeSRV:
+		// signed responses need a proof of the empty answer
+		if s == nil {
+			logging.CurLog.MesosSuccess.Inc()
+			return nil
+		}
 	}
 
 	m.Rcode = dns.RcodeNameError
 
-	// Because we don't implement AAAA records, AAAA queries will always
-	// go via this path
-	// Unfortunately, we don't implement AAAA queries in Mesos-DNS,
-	// and although the 'Not Implemented' error code seems more suitable,
-	// RFCs do not recommend it: https://tools.ietf.org/html/rfc4074
-	// Therefore we always return success, which is synonymous with NODATA
-	// to get a positive cache on no records AAAA
-	// Further information:
-	// PR: https://github.com/mesosphere/mesos-dns/pull/366
-	// Issue: https://github.com/mesosphere/mesos-dns/issues/363
-
-	// The second component is just a matter of returning NODATA if we have
-	// SRV or A records for the given name, but no neccessarily the given query
-
-	if (qType == dns.TypeAAAA) || (len(rs.SRVs[name])+len(rs.As[name]) > 0) {
+	// Return NODATA if we have SRV, A, AAAA or TXT records for the given name,
+	// but not necessarily for the given query type. This is what an IPv4
+	// only task queried for AAAA (or vice versa) gets, as recommended by
+	// https://tools.ietf.org/html/rfc4074
+
+	if len(rs.SRVs[name])+len(rs.As[name])+len(rs.AAAAs[name])+len(rs.TXTs[name]) > 0 {
 		m.Rcode = dns.RcodeSuccess
 	}
 
 	logging.CurLog.MesosNXDomain.Inc()
-	logging.VeryVerbose.Println("total A rrs:\t" + strconv.Itoa(len(rs.As)))
-	logging.VeryVerbose.Println("failed looking for " + r.Question[0].String())
+	logger.Debug("name not found", "question", r.Question[0].String(), "a_records", len(rs.As))
 
-	m.Ns = append(m.Ns, res.formatSOA(r.Question[0].Name))
+	if s == nil {
+		m.Ns = append(m.Ns, res.formatSOA(r.Question[0].Name))
+		return nil
+	}
+
+	// validators expect the SOA of the zone apex along with the denial proof
+	var denial []dns.RR
+	m.Rcode, denial = s.deny(rs, name)
+	m.Ns = append(m.Ns, res.formatSOA(s.zone))
+	m.Ns = append(m.Ns, denial...)
 
 	return nil
 }
@@ -418,16 +1034,47 @@ func (res *Resolver) handleEmpty(rs *records.RecordGenerator, name string, m, r
 func reply(w dns.ResponseWriter, m *dns.Msg) {
 	m.Compress = true // https://github.com/mesosphere/mesos-dns/issues/{170,173,174}
 
+	if len(m.Question) > 0 {
+		logging.Queries.With(dns.TypeToString[m.Question[0].Qtype]).Inc()
+	}
+	logging.Rcodes.With(dns.RcodeToString[m.Rcode]).Inc()
+
 	if err := w.WriteMsg(truncate(m, isUDP(w))); err != nil {
-		logging.Error.Println(err)
+		logger.Error("failed to write the response", "error", err)
 	}
 }
 
-// isUDP returns true if the transmission channel in use is UDP.
+// isUDP returns true if the transmission channel in use is UDP. Stream
+// transports, i.e. TCP, DNS-over-TLS and DNS-over-HTTPS, carry messages of
+// up to dns.MaxMsgSize bytes.
 func isUDP(w dns.ResponseWriter) bool {
 	return strings.HasPrefix(w.RemoteAddr().Network(), "udp")
 }
 
+// isHTTPS returns true if the transmission channel in use is DNS-over-HTTPS.
+func isHTTPS(w dns.ResponseWriter) bool {
+	return transport(w) == "https"
+}
+
+// transport returns the transport of the queries answered through w: "udp",
+// "tcp", "tls" for DNS-over-TLS or "https" for DNS-over-HTTPS.
+func transport(w dns.ResponseWriter) string {
+	switch w := w.(type) {
+	case *httpWriter:
+		return "https"
+	case *streamWriter:
+		return "tls"
+	case *queryWriter:
+		return transport(w.ResponseWriter)
+	case *rrlWriter:
+		return transport(w.ResponseWriter)
+	}
+	if isUDP(w) {
+		return "udp"
+	}
+	return "tcp"
+}
+
 // truncate removes answers until the given dns.Msg fits the permitted
 // length of the given transmission channel and sets the TC bit.
 // See https://tools.ietf.org/html/rfc1035#section-4.2.1
@@ -469,13 +1116,27 @@ func (res *Resolver) configureHTTP() {
 	// webserver + available routes
 	ws := new(restful.WebService)
 	ws.Route(ws.GET("/v1/version").To(res.RestVersion))
-	ws.Route(ws.GET("/v1/config").To(res.RestConfig))
+	ws.Route(ws.GET("/v1/health").To(res.RestHealth))
+	ws.Route(ws.GET("/v1/ready").To(res.RestReady))
+	ws.Route(ws.GET("/v1/config").Filter(res.adminOnly).To(res.RestConfig))
+	ws.Route(ws.POST("/v1/config/reload").Filter(res.adminOnly).To(res.RestReloadConfig))
+	ws.Route(ws.POST("/v1/cache/flush").Filter(res.adminOnly).To(res.RestFlushCache))
+	ws.Route(ws.GET("/v1/querylog").To(res.RestQueryLog))
+	ws.Route(ws.PUT("/v1/querylog").Filter(res.adminOnly).To(res.RestQueryLog))
+	ws.Route(ws.GET("/v1/loglevel").To(res.RestLogLevel))
+	ws.Route(ws.PUT("/v1/loglevel").Filter(res.adminOnly).To(res.RestLogLevel))
 	ws.Route(ws.GET("/v1/hosts/{host}").To(res.RestHost))
 	ws.Route(ws.GET("/v1/hosts/{host}/ports").To(res.RestPorts))
 	ws.Route(ws.GET("/v1/services/{service}").To(res.RestService))
-	if res.config.EnumerationOn {
+	ws.Route(ws.GET("/metrics").Filter(res.adminOnly).To(res.RestMetrics))
+	if res.cfg().EnumerationOn {
 		ws.Route(ws.GET("/v1/enumerate").To(res.RestEnumerate))
 	}
+	if res.cfg().DoHOn {
+		res.handlers.Do(res.registerHandlers)
+		ws.Route(ws.GET("/dns-query").Produces(dnsMessage).To(res.RestDoH))
+		ws.Route(ws.POST("/dns-query").Consumes(dnsMessage).Produces(dnsMessage).To(res.RestDoH))
+	}
 	restful.Add(ws)
 }
 
@@ -485,35 +1146,103 @@ func (res *Resolver) LaunchHTTP() <-chan error {
 	defer util.HandleCrash()
 
 	res.configureHTTP()
-	portString := ":" + strconv.Itoa(res.config.HTTPPort)
+	portString := ":" + strconv.Itoa(res.cfg().HTTPPort)
 
 	errCh := make(chan error, 1)
 	go func() {
 		var err error
 		defer func() { errCh <- err }()
 
-		if err = http.ListenAndServe(portString, nil); err != nil {
+		if res.cfg().httpCert != nil {
+			err = res.listenAndServeTLS(portString)
+		} else {
+			err = http.ListenAndServe(portString, nil)
+		}
+		if err != nil {
 			err = fmt.Errorf("Failed to setup http server: %v", err)
 		} else {
-			logging.Error.Println("Not serving http requests any more.")
+			logger.Error("not serving HTTP requests any more")
 		}
 	}()
 	return errCh
 }
 
+// RestMetrics handles HTTP requests of metrics in the Prometheus text format.
+func (res *Resolver) RestMetrics(req *restful.Request, resp *restful.Response) {
+	res.rsLock.RLock()
+	rs, reloaded := res.rs, res.reloaded
+	res.rsLock.RUnlock()
+
+	counts := map[string]float64{}
+	for kind, n := range rs.RecordCounts() {
+		counts[kind] = float64(n)
+	}
+
+	resp.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	mw := logging.NewMetricsWriter(resp)
+	logging.WriteMetrics(mw)
+	mw.GaugeVec("records", "Records currently served, by kind.", "kind", counts)
+	mw.Gauge("forward_cache_bytes", "Size of the cached responses to forwarded queries.", float64(res.cfg().cache.Size()))
+	if !reloaded.IsZero() {
+		mw.Gauge("last_reload_age_seconds", "Time since the last successful reload of the records.", time.Since(reloaded).Seconds())
+	}
+	if err := mw.Err(); err != nil {
+		logger.Error("failed to write the metrics", "error", err)
+	}
+}
+
 // RestConfig handles HTTP requests of Resolver configuration.
 func (res *Resolver) RestConfig(req *restful.Request, resp *restful.Response) {
-	if err := resp.WriteAsJson(res.config); err != nil {
-		logging.Error.Println(err)
+	if err := resp.WriteAsJson(res.cfg().Config); err != nil {
+		logger.Error("failed to write the response", "error", err)
+	}
+}
+
+// RestFlushCache handles HTTP requests to flush the cache of responses to
+// forwarded queries, responding with the number of responses flushed.
+func (res *Resolver) RestFlushCache(req *restful.Request, resp *restful.Response) {
+	n := res.cfg().cache.Flush()
+	logger.Info("forward cache flushed", "responses", n)
+	if err := resp.WriteAsJson(map[string]int{"flushed": n}); err != nil {
+		logger.Error("failed to write the response", "error", err)
+	}
+}
+
+// RestLogLevel handles HTTP requests of the minimum level of the logged
+// entries, and of changes to it, in the same format.
+func (res *Resolver) RestLogLevel(req *restful.Request, resp *restful.Response) {
+	if req.Request.Method == "PUT" {
+		var change struct {
+			Level string `json:"level"`
+		}
+		err := json.NewDecoder(req.Request.Body).Decode(&change)
+		if err == nil {
+			var level logging.Level
+			if level, err = logging.ParseLevel(change.Level); err == nil {
+				logging.SetLevel(level)
+			}
+		}
+		if err != nil {
+			resp.WriteHeader(http.StatusBadRequest)
+			if err = resp.WriteAsJson(map[string]string{"error": err.Error()}); err != nil {
+				logger.Error("failed to write the response", "error", err)
+			}
+			return
+		}
+		logger.Info("log level set", "level", change.Level)
+	}
+	level := logging.CurrentLevel().String()
+	if err := resp.WriteAsJson(map[string]string{"level": level}); err != nil {
+		logger.Error("failed to write the response", "error", err)
 	}
 }
 
 // RestEnumerate handles HTTP requests of the enumeration data
 func (res *Resolver) RestEnumerate(req *restful.Request, resp *restful.Response) {
 
-	enumData := res.records().EnumData
+	enumData := res.clientRecords(httpAddr(req.Request.RemoteAddr).IP).EnumData
 	if err := resp.WriteAsJson(enumData); err != nil {
-		logging.Error.Println(err)
+		logger.Error("failed to write the response", "error", err)
 	}
 }
 
@@ -525,11 +1254,11 @@ func (res *Resolver) RestVersion(req *restful.Request, resp *restful.Response) {
 		"URL":     "https://github.com/mesosphere/mesos-dns",
 	})
 	if err != nil {
-		logging.Error.Println(err)
+		logger.Error("failed to write the response", "error", err)
 	}
 }
 
-// RestHost handles HTTP requests of DNS A records of the given host.
+// RestHost handles HTTP requests of DNS A and AAAA records of the given host.
 func (res *Resolver) RestHost(req *restful.Request, resp *restful.Response) {
 	host := req.PathParameter("host")
 	// clean up host name
@@ -537,28 +1266,31 @@ func (res *Resolver) RestHost(req *restful.Request, resp *restful.Response) {
 	if dom[len(dom)-1] != '.' {
 		dom += "."
 	}
-	rs := res.records()
+	rs := res.clientRecords(httpAddr(req.Request.RemoteAddr).IP)
 
 	type record struct {
 		Host string `json:"host"`
 		IP   string `json:"ip"`
 	}
 
-	aRRs := rs.As[dom]
-	records := make([]record, 0, len(aRRs))
+	aRRs, aaaaRRs := rs.As[dom], rs.AAAAs[dom]
+	records := make([]record, 0, len(aRRs)+len(aaaaRRs))
 	for ip := range aRRs {
 		records = append(records, record{dom, ip})
 	}
+	for ip := range aaaaRRs {
+		records = append(records, record{dom, ip})
+	}
 
 	if len(records) == 0 {
 		records = append(records, record{})
 	}
 
 	if err := resp.WriteAsJson(records); err != nil {
-		logging.Error.Println(err)
+		logger.Error("failed to write the response", "error", err)
 	}
 
-	stats(dom, res.config.Domain+".", len(aRRs) > 0)
+	stats(dom, res.cfg().Domain+".", len(aRRs)+len(aaaaRRs) > 0)
 }
 
 func stats(domain, zone string, success bool) {
@@ -575,12 +1307,22 @@ func stats(domain, zone string, success bool) {
 	}
 }
 
-// RestPorts is an HTTP handler which is currently not implemented.
+// RestPorts handles HTTP requests of the ports allocated to the tasks the
+// given host name resolves to.
 func (res *Resolver) RestPorts(req *restful.Request, resp *restful.Response) {
-	err := resp.WriteErrorString(http.StatusNotImplemented, "To be implemented...")
-	if err != nil {
-		logging.Error.Println(err)
+	host := req.PathParameter("host")
+	// clean up host name
+	dom := strings.ToLower(cleanWild(host))
+	if dom[len(dom)-1] != '.' {
+		dom += "."
 	}
+
+	ports := res.clientRecords(httpAddr(req.Request.RemoteAddr).IP).Ports(dom)
+	if err := resp.WriteAsJson(ports); err != nil {
+		logger.Error("failed to write the response", "error", err)
+	}
+
+	stats(dom, res.cfg().Domain+".", len(ports) > 0)
 }
 
 // RestService handles HTTP requests of DNS SRV records for the given name.
@@ -591,13 +1333,15 @@ func (res *Resolver) RestService(req *restful.Request, resp *restful.Response) {
 	if dom[len(dom)-1] != '.' {
 		dom += "."
 	}
-	rs := res.records()
+	rs := res.clientRecords(httpAddr(req.Request.RemoteAddr).IP)
 
 	type record struct {
-		Service string `json:"service"`
-		Host    string `json:"host"`
-		IP      string `json:"ip"`
-		Port    string `json:"port"`
+		Service  string `json:"service"`
+		Host     string `json:"host"`
+		IP       string `json:"ip"`
+		Port     string `json:"port"`
+		Priority uint16 `json:"priority"`
+		Weight   uint16 `json:"weight"`
 	}
 
 	srvRRs := rs.SRVs[dom]
@@ -607,8 +1351,11 @@ func (res *Resolver) RestService(req *restful.Request, resp *restful.Response) {
 		var ip string
 		if r, ok := rs.As.First(host); ok {
 			ip = r
+		} else if r, ok := rs.AAAAs.First(host); ok {
+			ip = r
 		}
-		records = append(records, record{service, host, ip, port})
+		pref := rs.SRVPreferences.Get(dom, s)
+		records = append(records, record{service, host, ip, port, pref.Priority, pref.Weight})
 	}
 
 	if len(records) == 0 {
@@ -616,10 +1363,10 @@ func (res *Resolver) RestService(req *restful.Request, resp *restful.Response) {
 	}
 
 	if err := resp.WriteAsJson(records); err != nil {
-		logging.Error.Println(err)
+		logger.Error("failed to write the response", "error", err)
 	}
 
-	stats(dom, res.config.Domain+".", len(srvRRs) > 0)
+	stats(dom, res.cfg().Domain+".", len(srvRRs) > 0)
 }
 
 // panicRecover catches any panics from the resolvers and sets an error
@@ -631,7 +1378,7 @@ func panicRecover(f func(w dns.ResponseWriter, r *dns.Msg)) func(w dns.ResponseW
 				m := new(dns.Msg)
 				m.SetRcode(r, 2)
 				_ = w.WriteMsg(m)
-				logging.Error.Println(rec)
+				logger.Error("recovered from panic", "panic", fmt.Sprint(rec))
 			}
 		}()
 		f(w, r)
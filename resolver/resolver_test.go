@@ -10,12 +10,19 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/emicklei/go-restful"
 	"github.com/kylelemons/godebug/pretty"
 	. "github.com/mesosphere/mesos-dns/dnstest"
+	"github.com/mesosphere/mesos-dns/errorutil"
+	"github.com/mesosphere/mesos-dns/exchanger"
 	"github.com/mesosphere/mesos-dns/logging"
 	"github.com/mesosphere/mesos-dns/records"
 	"github.com/mesosphere/mesos-dns/records/labels"
@@ -94,18 +101,25 @@ func runHandlers() error {
 	if err != nil {
 		return err
 	}
-	res.fwd = func(m *dns.Msg, net string) (*dns.Msg, error) {
+	res.cfg().fwd = func(m *dns.Msg, net string) (*dns.Msg, string, error) {
+		if m.Question[0].Qtype == dns.TypePTR {
+			msg := &dns.Msg{Answer: []dns.RR{
+				res.formatPTR(m.Question[0].Name, "google-public-dns-a.google.com."),
+			}}
+			msg.SetReply(m)
+			return msg, "8.8.8.8:53", nil
+		}
 		rr1, err := res.formatA("google.com.", "1.1.1.1")
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		rr2, err := res.formatA("google.com.", "2.2.2.2")
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		msg := &dns.Msg{Answer: []dns.RR{rr1, rr2}}
 		msg.SetReply(m)
-		return msg, nil
+		return msg, "8.8.8.8:53", nil
 	}
 
 	for i, tt := range []struct {
@@ -211,12 +225,47 @@ func runHandlers() error {
 		{
 			res.HandleMesos,
 			Message(
-				Question("missing.mesos.", dns.TypeAAAA),
+				Question("toy-store.marathon.mesos.", dns.TypeAAAA),
+				Header(true, dns.RcodeSuccess),
+				Answers(
+					AAAA(RRHeader("toy-store.marathon.mesos.", dns.TypeAAAA, 60),
+						net.ParseIP("fd01:b::1:8")))),
+		},
+		{
+			res.HandleMesos,
+			Message(
+				Question("toy-store-n96qe-0.marathon.mesos.", dns.TypeTXT),
 				Header(true, dns.RcodeSuccess),
+				Answers(
+					TXT(RRHeader("toy-store-n96qe-0.marathon.mesos.", dns.TypeTXT, 60), "owner=toys"))),
+		},
+		{
+			res.HandleMesos,
+			Message(
+				Question("missing.mesos.", dns.TypeAAAA),
+				Header(true, dns.RcodeNameError),
 				NSs(
 					SOA(RRHeader("missing.mesos.", dns.TypeSOA, 60),
 						"ns1.mesos", "root.ns1.mesos", 60))),
 		},
+		{
+			res.HandlePTR,
+			Message(
+				Question("3.0.3.10.in-addr.arpa.", dns.TypePTR),
+				Header(true, dns.RcodeSuccess),
+				Answers(
+					PTR(RRHeader("3.0.3.10.in-addr.arpa.", dns.TypePTR, 60),
+						"nginx-6ud99-0.marathon.mesos."))),
+		},
+		{ // off-cluster addresses are forwarded
+			res.HandlePTR,
+			Message(
+				Question("8.8.8.8.in-addr.arpa.", dns.TypePTR),
+				Header(false, dns.RcodeSuccess),
+				Answers(
+					PTR(RRHeader("8.8.8.8.in-addr.arpa.", dns.TypePTR, 60),
+						"google-public-dns-a.google.com."))),
+		},
 		{
 			res.HandleNonMesos,
 			Message(
@@ -236,6 +285,280 @@ func runHandlers() error {
 	return nil
 }
 
+func TestHandleMesos_static(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mesos-dns-static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := records.NewConfig()
+	config.RecurseOn = false
+	config.IPSources = []string{"docker", "mesos", "host"}
+	config.StaticRecordsFile = filepath.Join(dir, "static.zone")
+	err = ioutil.WriteFile(config.StaticRecordsFile, []byte(`
+registry       IN CNAME chronos.marathon.mesos.
+db             IN CNAME db.rds.amazonaws.com.
+_registry._tcp IN SRV   0 0 5000 registry
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := New("", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := res.cfg().recordGenerator()
+	err = rs.InsertState(fakeState(t), "mesos", "mesos-dns.mesos.", "127.0.0.1", nil, config.IPSources, nil, labels.RFC952)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.rs = rs
+
+	for i, want := range []*dns.Msg{
+		Message( // CNAME chased in the Mesos domain
+			Question("registry.mesos.", dns.TypeA),
+			Header(true, dns.RcodeSuccess),
+			Answers(
+				CNAME(RRHeader("registry.mesos.", dns.TypeCNAME, 60),
+					"chronos.marathon.mesos."),
+				A(RRHeader("chronos.marathon.mesos.", dns.TypeA, 60),
+					net.ParseIP("1.2.3.11")))),
+		Message( // CNAME left to the client out of the Mesos domain
+			Question("db.mesos.", dns.TypeA),
+			Header(true, dns.RcodeSuccess),
+			Answers(
+				CNAME(RRHeader("db.mesos.", dns.TypeCNAME, 60),
+					"db.rds.amazonaws.com."))),
+		Message( // CNAME targets of SRV records chased in the additional section
+			Question("_registry._tcp.mesos.", dns.TypeSRV),
+			Header(true, dns.RcodeSuccess),
+			Answers(
+				SRV(RRHeader("_registry._tcp.mesos.", dns.TypeSRV, 60),
+					"registry.mesos.", 5000, 0, 0)),
+			Extras(
+				CNAME(RRHeader("registry.mesos.", dns.TypeCNAME, 60),
+					"chronos.marathon.mesos."),
+				A(RRHeader("chronos.marathon.mesos.", dns.TypeA, 60),
+					net.ParseIP("1.2.3.11")))),
+		Message(
+			Question("registry.mesos.", dns.TypeCNAME),
+			Header(true, dns.RcodeSuccess),
+			Answers(
+				CNAME(RRHeader("registry.mesos.", dns.TypeCNAME, 60),
+					"chronos.marathon.mesos."))),
+	} {
+		var rw ResponseRecorder
+		res.HandleMesos(&rw, want)
+		if got := rw.Msg; !(Msg{got}).equivalent(Msg{want}) {
+			t.Errorf("Test #%d\n%v\n%s\n", i, pretty.Sprint(want.Question), pretty.Compare(got, want))
+		}
+	}
+}
+
+func TestForwardZones(t *testing.T) {
+	config := records.NewConfig()
+	config.ForwardZones = map[string]records.ForwardZone{
+		"example.com":      {Resolvers: []string{"10.0.0.53"}},
+		"Corp.Example.com": {Resolvers: []string{"10.0.1.53"}},
+		"10.in-addr.arpa.": {Resolvers: []string{"10.0.1.53"}},
+	}
+	res, err := New("", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each forwarder answers with a TXT record naming its zone
+	cfg := res.cfg()
+	stub := func(zone string) exchanger.Forwarder {
+		return func(m *dns.Msg, proto string) (*dns.Msg, string, error) {
+			r := new(dns.Msg).SetReply(m)
+			r.Answer = append(r.Answer, TXT(RRHeader(m.Question[0].Name, dns.TypeTXT, 60), zone))
+			return r, zone, nil
+		}
+	}
+	cfg.fwd = stub(".")
+	for zone := range cfg.zones {
+		cfg.zones[zone] = stub(zone)
+	}
+
+	for i, tt := range []struct {
+		dns.HandlerFunc
+		name, zone string
+	}{
+		{res.HandleNonMesos, "google.com.", "."},
+		{res.HandleNonMesos, "example.com.", "example.com."},
+		{res.HandleNonMesos, "www.example.com.", "example.com."},
+		{res.HandleNonMesos, "dc1.CORP.example.com.", "corp.example.com."},
+		{res.HandleNonMesos, "notcorp.example.com.", "example.com."},
+		{res.HandlePTR, "1.0.0.10.in-addr.arpa.", "10.in-addr.arpa."},
+		{res.HandlePTR, "3.0.3.10.in-addr.arpa.", "10.in-addr.arpa."},
+		{res.HandleZone("corp.example.com."), "dc1.corp.example.com.", "corp.example.com."},
+		{res.HandleZone("consul."), "web.service.consul.", "."}, // no longer forwarded
+	} {
+		var rw ResponseRecorder
+		tt.HandlerFunc(&rw, Message(Question(tt.name, dns.TypeTXT)))
+		if len(rw.Msg.Answer) != 1 {
+			t.Errorf("test #%d: got answers %v, want one", i, rw.Msg.Answer)
+		} else if got := rw.Msg.Answer[0].(*dns.TXT).Txt[0]; got != tt.zone {
+			t.Errorf("test #%d: %s forwarded to the resolvers of %q, want %q", i, tt.name, got, tt.zone)
+		}
+	}
+
+	config.ExternalOn = false
+	if l, err := newLiveConfig(config); err != nil || len(l.zones) != 0 {
+		t.Errorf("got zones %v and error %v, want no zones forwarded without ExternalOn", l.zones, err)
+	}
+}
+
+func TestRestFlushCache(t *testing.T) {
+	config := records.NewConfig()
+	config.ForwardCacheSize = 1 << 20
+	res, err := New("", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cache a response to google.com.
+	msg := Message(
+		Question("google.com.", dns.TypeA),
+		Answers(A(RRHeader("google.com.", dns.TypeA, 60), net.ParseIP("1.1.1.1"))))
+	upstream := exchanger.Func(func(*dns.Msg, string) (*dns.Msg, time.Duration, error) {
+		return msg, 0, nil
+	})
+	var hits, misses logging.LogCounter
+	ex := exchanger.Caching(res.cfg().cache, &hits, &misses)(upstream)
+	if _, _, err := ex.Exchange(msg, "8.8.8.8:53"); err != nil || res.cfg().cache.Size() == 0 {
+		t.Fatalf("got error %v, want the response cached", err)
+	}
+
+	rw := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/v1/cache/flush", nil)
+	res.RestFlushCache(restful.NewRequest(req), restful.NewResponse(rw))
+	var got map[string]int
+	if err := json.NewDecoder(rw.Body).Decode(&got); err != nil || got["flushed"] != 1 {
+		t.Errorf("got %v and error %v, want 1 response flushed", got, err)
+	}
+	if size := res.cfg().cache.Size(); size != 0 {
+		t.Errorf("got %d bytes cached, want none", size)
+	}
+}
+
+func TestSRVPreferences(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const name = "_car-store._tcp.marathon.mesos."
+	rs := res.records()
+	prefs := map[string]map[string]records.SRVPreference{}
+	for target := range rs.SRVs[name] {
+		prefs[target] = map[string]records.SRVPreference{"task": {Priority: 10, Weight: 5}}
+	}
+	if len(prefs) == 0 {
+		t.Fatalf("no SRV records for %s", name)
+	}
+	rs.SRVPreferences[name] = prefs
+
+	rw := &ResponseRecorder{}
+	res.HandleMesos(rw, Message(Question(name, dns.TypeSRV)))
+	if len(rw.Msg.Answer) != len(prefs) {
+		t.Fatalf("got answers %v, want %d", rw.Msg.Answer, len(prefs))
+	}
+	for _, rr := range rw.Msg.Answer {
+		if srv := rr.(*dns.SRV); srv.Priority != 10 || srv.Weight != 5 {
+			t.Errorf("got %v, want a priority of 10 and a weight of 5", srv)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/v1/services/"+name, nil)
+	rreq := restful.NewRequest(req)
+	rreq.PathParameters()["service"] = name
+	hrw := httptest.NewRecorder()
+	res.RestService(rreq, restful.NewResponse(hrw))
+	var got []struct{ Priority, Weight uint16 }
+	if err := json.NewDecoder(hrw.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range got {
+		if s.Priority != 10 || s.Weight != 5 {
+			t.Errorf("got service %+v, want a priority of 10 and a weight of 5", s)
+		}
+	}
+	if len(got) != len(prefs) {
+		t.Errorf("got %d services, want %d", len(got), len(prefs))
+	}
+}
+
+func TestRestService_AAAA(t *testing.T) {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const name = "_car-store._tcp.marathon.mesos."
+	rs := res.records()
+	// the targets of the service only have IPv6 addresses
+	for target := range rs.SRVs[name] {
+		host, _, _ := net.SplitHostPort(target)
+		delete(rs.As, host)
+		rs.AAAAs[host] = map[string]struct{}{"fd00::1": {}}
+	}
+
+	req, _ := http.NewRequest("GET", "/v1/services/"+name, nil)
+	rreq := restful.NewRequest(req)
+	rreq.PathParameters()["service"] = name
+	hrw := httptest.NewRecorder()
+	res.RestService(rreq, restful.NewResponse(hrw))
+	var got []struct{ Host, IP string }
+	if err := json.NewDecoder(hrw.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) == 0 {
+		t.Fatal("got no services")
+	}
+	for _, s := range got {
+		if s.Host == "" || s.IP != "fd00::1" {
+			t.Errorf("got service %+v, want the IPv6 address of its host", s)
+		}
+	}
+}
+
+func TestRestLogLevel(t *testing.T) {
+	defer logging.SetLevel(logging.CurrentLevel())
+	res, err := New("", records.NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tt := range []struct {
+		method, body string
+		status       int
+		want         string
+	}{
+		{"PUT", `{"level": "debug"}`, http.StatusOK, "debug"},
+		{"PUT", `{"level": "verbose"}`, http.StatusBadRequest, "debug"},
+		{"PUT", `{"level": "ERROR"}`, http.StatusOK, "error"},
+		{"GET", "", http.StatusOK, "error"},
+	} {
+		req, _ := http.NewRequest(tt.method, "/v1/loglevel", strings.NewReader(tt.body))
+		rw := httptest.NewRecorder()
+		res.RestLogLevel(restful.NewRequest(req), restful.NewResponse(rw))
+		if rw.Code != tt.status {
+			t.Errorf("test #%d: got status %d, want %d", i, rw.Code, tt.status)
+		}
+		if got := logging.CurrentLevel().String(); got != tt.want {
+			t.Errorf("test #%d: got level %q, want %q", i, got, tt.want)
+		}
+		var got map[string]string
+		if err := json.NewDecoder(rw.Body).Decode(&got); err != nil {
+			t.Errorf("test #%d: got error %v", i, err)
+		} else if tt.status == http.StatusOK && got["level"] != tt.want {
+			t.Errorf("test #%d: got %v, want level %q", i, got, tt.want)
+		}
+	}
+}
+
 type Msg struct{ *dns.Msg }
 type RRs []dns.RR
 
@@ -313,21 +636,35 @@ func TestHTTP(t *testing.T) {
 				"Version": "0.1.1",
 			},
 		},
-		{"/v1/config", http.StatusOK, &records.Config{}, &res.config},
+		{"/v1/config", http.StatusOK, &records.Config{}, &res.cfg().Config},
+		{"/v1/health", http.StatusOK, map[string]interface{}{},
+			map[string]interface{}{"healthy": true},
+		},
+		{"/v1/ready", http.StatusServiceUnavailable, map[string]interface{}{},
+			map[string]interface{}{
+				"ready":       false,
+				"reasons":     []interface{}{"no records loaded yet"},
+				"age_seconds": 0.0,
+			},
+		},
 		{"/v1/services/_leader._tcp.mesos.", http.StatusOK, []interface{}{},
 			[]interface{}{map[string]interface{}{
-				"service": "_leader._tcp.mesos.",
-				"host":    "leader.mesos.",
-				"ip":      "1.2.3.4",
-				"port":    "5050",
+				"service":  "_leader._tcp.mesos.",
+				"host":     "leader.mesos.",
+				"ip":       "1.2.3.4",
+				"port":     "5050",
+				"priority": 0.0,
+				"weight":   0.0,
 			}},
 		},
 		{"/v1/services/_myservice._tcp.mesos.", http.StatusOK, []interface{}{},
 			[]interface{}{map[string]interface{}{
-				"service": "",
-				"host":    "",
-				"ip":      "",
-				"port":    "",
+				"service":  "",
+				"host":     "",
+				"ip":       "",
+				"port":     "",
+				"priority": 0.0,
+				"weight":   0.0,
 			}},
 		},
 		{"/v1/hosts/leader.mesos", http.StatusOK, []interface{}{},
@@ -336,6 +673,24 @@ func TestHTTP(t *testing.T) {
 				"ip":   "1.2.3.4",
 			}},
 		},
+		{"/v1/hosts/toy-store.marathon.mesos/ports", http.StatusOK, []interface{}{},
+			[]interface{}{map[string]interface{}{
+				"port":     31800.0,
+				"protocol": "",
+				"name":     "",
+				"task_id":  "toy-store.7f5cb2b8-9a2e-11e5-a088-c20493233aa5",
+				"source":   "resources",
+			}},
+		},
+		{"/v1/hosts/missing.mesos/ports", http.StatusOK, []interface{}{}, []interface{}{}},
+		{"/v1/hosts/toy-store.marathon.mesos", http.StatusOK, []interface{}{},
+			[]interface{}{
+				map[string]interface{}{
+					"host": "toy-store.marathon.mesos.",
+					"ip":   "fd01:b::1:8",
+				},
+			},
+		},
 	} {
 		if resp, err := http.Get(srv.URL + tt.path); err != nil {
 			t.Error(err)
@@ -349,6 +704,26 @@ func TestHTTP(t *testing.T) {
 			_ = resp.Body.Close()
 		}
 	}
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer errorutil.Ignore(resp.Body.Close)
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# TYPE mesos_dns_mesos_requests_total counter\n",
+		"# TYPE mesos_dns_forward_latency_seconds histogram\n",
+		"mesos_dns_state_fetch_failures_total ",
+		`mesos_dns_records{kind="AAAA"} 2` + "\n",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("GET /metrics: missing %q in:\n%s", want, body)
+		}
+	}
 }
 
 func fakeDNS() (*Resolver, error) {
@@ -357,7 +732,10 @@ func fakeDNS() (*Resolver, error) {
 	config.RecurseOn = false
 	config.IPSources = []string{"docker", "mesos", "host"}
 
-	res := New("", config)
+	res, err := New("", config)
+	if err != nil {
+		return nil, err
+	}
 	res.rng.Seed(0) // for deterministic tests
 
 	b, err := ioutil.ReadFile("../factories/fake.json")
@@ -372,7 +750,7 @@ func fakeDNS() (*Resolver, error) {
 	}
 
 	spec := labels.RFC952
-	err = res.rs.InsertState(sj, "mesos", "mesos-dns.mesos.", "127.0.0.1", res.config.Masters, res.config.IPSources, spec)
+	err = res.rs.InsertState(sj, "mesos", "mesos-dns.mesos.", "127.0.0.1", res.cfg().Masters, res.cfg().IPSources, []string{"owner"}, spec)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,224 @@
+package resolver
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/mesosphere/mesos-dns/logging"
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/miekg/dns"
+)
+
+const (
+	// maxZoneVersions is the number of record sets retained by Reload to
+	// compute incremental zone transfers from.
+	maxZoneVersions = 10
+	// xfrChunkSize is the number of RRs sent in each zone transfer message.
+	xfrChunkSize = 100
+)
+
+// zoneVersion is a record set along with the SOA serial it was served with.
+type zoneVersion struct {
+	serial uint32
+	rs     *records.RecordGenerator
+}
+
+// appendVersion appends v to vs, dropping the oldest versions beyond
+// maxZoneVersions.
+func appendVersion(vs []zoneVersion, v zoneVersion) []zoneVersion {
+	vs = append(vs, v)
+	if n := len(vs) - maxZoneVersions; n > 0 {
+		vs = append(vs[:0:0], vs[n:]...)
+	}
+	return vs
+}
+
+// zoneVersions returns the retained record sets, oldest first. The last one
+// is the current record set.
+func (res *Resolver) zoneVersions() []zoneVersion {
+	res.rsLock.RLock()
+	defer res.rsLock.RUnlock()
+	return res.versions
+}
+
+// handleXFR serves AXFR and IXFR requests for the Mesos domain. Transfers are
+// only allowed over TCP or DNS-over-TLS, and only to clients within the
+// configured ZoneTransferCIDRs.
+func (res *Resolver) handleXFR(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	switch {
+	case !res.cfg().xfrNets.contains(addrIP(w.RemoteAddr())):
+		logger.Debug("zone transfer refused", "client", w.RemoteAddr().String())
+		m.Rcode = dns.RcodeRefused
+	case !strings.EqualFold(r.Question[0].Name, res.cfg().Domain+"."):
+		m.Rcode = dns.RcodeNotAuth
+	case isUDP(w):
+		// ask the client to retry over TCP
+		m.Truncated = true
+	case isHTTPS(w):
+		// transfers take several messages, DNS-over-HTTPS responses just one
+		m.Rcode = dns.RcodeRefused
+	default:
+		if err := res.transfer(w, r); err != nil {
+			logger.Error("zone transfer failed", "client", w.RemoteAddr().String(), "error", err)
+			logging.CurLog.MesosFailed.Inc()
+		} else {
+			logging.CurLog.MesosSuccess.Inc()
+		}
+		return
+	}
+
+	logging.CurLog.MesosFailed.Inc()
+	reply(w, m)
+}
+
+// transfer writes the zone transfer answering r to w, split into messages of
+// at most xfrChunkSize RRs.
+func (res *Resolver) transfer(w dns.ResponseWriter, r *dns.Msg) error {
+	versions := res.zoneVersions()
+
+	var rrs []dns.RR
+	if r.Question[0].Qtype == dns.TypeIXFR {
+		rrs = res.ixfr(versions, r)
+	}
+	if rrs == nil {
+		rrs = res.axfr(versions[len(versions)-1])
+	}
+
+	for len(rrs) > 0 {
+		n := xfrChunkSize
+		if n > len(rrs) {
+			n = len(rrs)
+		}
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Authoritative = true
+		m.Compress = true
+		m.Answer = rrs[:n]
+		if err := w.WriteMsg(m); err != nil {
+			return err
+		}
+		rrs = rrs[n:]
+	}
+	return nil
+}
+
+// axfr returns the full zone transfer of the given version: every RR of the
+// zone enclosed by its SOA record.
+func (res *Resolver) axfr(v zoneVersion) []dns.RR {
+	soa := res.versionSOA(v)
+	rrs := []dns.RR{soa, res.formatNS(soa.Hdr.Name)}
+	zone := res.zoneRRs(v.rs)
+	for _, key := range sortedKeys(zone) {
+		rrs = append(rrs, zone[key])
+	}
+	return append(rrs, soa)
+}
+
+// ixfr returns the incremental zone transfer (RFC 1995) from the serial in
+// the authority section of r up to the last of the given versions. It returns
+// nil if the serial is unknown, in which case a full transfer must be sent.
+func (res *Resolver) ixfr(versions []zoneVersion, r *dns.Msg) []dns.RR {
+	if len(r.Ns) == 0 {
+		return nil
+	}
+	soa, ok := r.Ns[0].(*dns.SOA)
+	if !ok {
+		return nil
+	}
+
+	from := -1
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].serial == soa.Serial {
+			from = i
+			break
+		}
+	}
+	if from < 0 {
+		return nil
+	}
+
+	cur := res.versionSOA(versions[len(versions)-1])
+	rrs := []dns.RR{cur}
+	if from == len(versions)-1 {
+		return rrs // client is up to date
+	}
+
+	old := res.zoneRRs(versions[from].rs)
+	for _, v := range versions[from+1:] {
+		next := res.zoneRRs(v.rs)
+		rrs = append(rrs, res.versionSOA(versions[from]))
+		for _, key := range sortedKeys(old) {
+			if _, ok := next[key]; !ok {
+				rrs = append(rrs, old[key])
+			}
+		}
+		rrs = append(rrs, res.versionSOA(v))
+		for _, key := range sortedKeys(next) {
+			if _, ok := old[key]; !ok {
+				rrs = append(rrs, next[key])
+			}
+		}
+		old, from = next, from+1
+	}
+	return append(rrs, cur)
+}
+
+// versionSOA returns the SOA record of the Mesos domain for the given version.
+func (res *Resolver) versionSOA(v zoneVersion) *dns.SOA {
+	soa := res.formatSOA(res.cfg().Domain + ".")
+	soa.Serial = v.serial
+	return soa
+}
+
+// zoneRRs returns the RRs of the Mesos domain held by the given record set,
+// except for its SOA and NS records, keyed by their text representation.
+func (res *Resolver) zoneRRs(rs *records.RecordGenerator) map[string]dns.RR {
+	zone := res.cfg().Domain + "."
+	rrs := map[string]dns.RR{}
+	add := func(rr dns.RR, err error) {
+		if err != nil {
+			logger.Debug("skipping invalid record", "error", err)
+		} else if dns.IsSubDomain(zone, rr.Header().Name) {
+			rrs[rr.String()] = rr
+		}
+	}
+	for name, hosts := range rs.As {
+		for host := range hosts {
+			add(res.formatA(name, host))
+		}
+	}
+	for name, hosts := range rs.AAAAs {
+		for host := range hosts {
+			add(res.formatAAAA(name, host))
+		}
+	}
+	for name, targets := range rs.SRVs {
+		for target := range targets {
+			add(res.formatSRV(name, target, rs.SRVPreferences.Get(name, target)))
+		}
+	}
+	for name, txts := range rs.TXTs {
+		for txt := range txts {
+			add(res.formatTXT(name, txt), nil)
+		}
+	}
+	for name, targets := range rs.CNAMEs {
+		for target := range targets {
+			add(res.formatCNAME(name, target), nil)
+		}
+	}
+	return rrs
+}
+
+// sortedKeys returns the sorted keys of the given RR map.
+func sortedKeys(rrs map[string]dns.RR) []string {
+	keys := make([]string, 0, len(rrs))
+	for key := range rrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,154 @@
+package resolver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "github.com/mesosphere/mesos-dns/dnstest"
+	"github.com/mesosphere/mesos-dns/records"
+	"github.com/mesosphere/mesos-dns/records/labels"
+	"github.com/mesosphere/mesos-dns/records/state"
+	"github.com/miekg/dns"
+)
+
+// xfrRecorder records every message written during a zone transfer.
+type xfrRecorder struct {
+	ResponseRecorder
+	msgs []*dns.Msg
+}
+
+func (r *xfrRecorder) WriteMsg(m *dns.Msg) error {
+	r.msgs = append(r.msgs, m.Copy())
+	return nil
+}
+
+func (r *xfrRecorder) answers() (rrs []dns.RR) {
+	for _, m := range r.msgs {
+		rrs = append(rrs, m.Answer...)
+	}
+	return rrs
+}
+
+func xfrDNS(t *testing.T) *Resolver {
+	res, err := fakeDNS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.cfg().xfrNets = parseCIDRs([]string{"10.0.0.0/8"})
+	res.versions[0].serial = 1
+	return res
+}
+
+func TestHandleXFR_Refused(t *testing.T) {
+	res := xfrDNS(t)
+	for i, tt := range []struct {
+		remote string
+		name   string
+		rcode  int
+	}{
+		{"192.168.0.1", "mesos.", dns.RcodeRefused},
+		{"10.1.2.3", "marathon.mesos.", dns.RcodeNotAuth},
+	} {
+		rw := xfrRecorder{ResponseRecorder: ResponseRecorder{Remote: net.IPAddr{IP: net.ParseIP(tt.remote)}}}
+		res.HandleMesos(&rw, Message(Question(tt.name, dns.TypeAXFR)))
+		if len(rw.msgs) != 1 || rw.msgs[0].Rcode != tt.rcode {
+			t.Errorf("test #%d: got %v, want a single message with rcode %d", i, rw.msgs, tt.rcode)
+		}
+	}
+}
+
+func TestHandleXFR_AXFR(t *testing.T) {
+	res := xfrDNS(t)
+	rw := xfrRecorder{ResponseRecorder: ResponseRecorder{Remote: net.IPAddr{IP: net.ParseIP("10.1.2.3")}}}
+	res.HandleMesos(&rw, Message(Question("mesos.", dns.TypeAXFR)))
+
+	rrs := rw.answers()
+	if len(rw.msgs) < 2 {
+		t.Fatalf("expected the transfer to span several messages, got %d", len(rw.msgs))
+	}
+	for _, i := range []int{0, len(rrs) - 1} {
+		if soa, ok := rrs[i].(*dns.SOA); !ok || soa.Serial != 1 {
+			t.Fatalf("expected SOA with serial 1 at %d, got %v", i, rrs[i])
+		}
+	}
+	want := A(RRHeader("chronos.marathon.mesos.", dns.TypeA, 60), net.ParseIP("1.2.3.11")).String()
+	for _, rr := range rrs {
+		if rr.String() == want {
+			return
+		}
+	}
+	t.Errorf("missing %q in transfer", want)
+}
+
+func TestHandleXFR_IXFR(t *testing.T) {
+	res := xfrDNS(t)
+
+	// second version without the toy-store task
+	sj := fakeState(t)
+	tasks := sj.Frameworks[2].Tasks
+	sj.Frameworks[2].Tasks = tasks[:len(tasks)-1]
+	rs := records.NewRecordGenerator(0)
+	if err := rs.InsertState(sj, "mesos", "mesos-dns.mesos.", "127.0.0.1", res.cfg().Masters, res.cfg().IPSources, []string{"owner"}, labels.RFC952); err != nil {
+		t.Fatal(err)
+	}
+	res.versions = appendVersion(res.versions, zoneVersion{2, rs})
+
+	ixfr := func(serial uint32) []dns.RR {
+		rw := xfrRecorder{ResponseRecorder: ResponseRecorder{Remote: net.IPAddr{IP: net.ParseIP("10.1.2.3")}}}
+		q := Message(Question("mesos.", dns.TypeIXFR))
+		q.Ns = []dns.RR{&dns.SOA{Hdr: RRHeader("mesos.", dns.TypeSOA, 60), Serial: serial}}
+		res.HandleMesos(&rw, q)
+		return rw.answers()
+	}
+
+	{ // up to date
+		rrs := ixfr(2)
+		if len(rrs) != 1 || rrs[0].(*dns.SOA).Serial != 2 {
+			t.Errorf("expected a single SOA, got %v", rrs)
+		}
+	}
+	{ // incremental
+		rrs := ixfr(1)
+		serials := []uint32{}
+		deleted := 0
+		for _, rr := range rrs {
+			if soa, ok := rr.(*dns.SOA); ok {
+				serials = append(serials, soa.Serial)
+			} else if len(serials) == 2 {
+				deleted++
+				if name := rr.Header().Name; !strings.Contains(name, "toy-store") {
+					t.Errorf("unexpected deletion of %q", name)
+				}
+			} else {
+				t.Errorf("unexpected record %v", rr)
+			}
+		}
+		if want := []uint32{2, 1, 2, 2}; !reflect.DeepEqual(serials, want) {
+			t.Errorf("got SOA serials %v, want %v", serials, want)
+		}
+		if deleted == 0 {
+			t.Error("expected toy-store records to be deleted")
+		}
+	}
+	{ // unknown serial falls back to AXFR
+		rrs := ixfr(42)
+		if _, ok := rrs[1].(*dns.NS); !ok {
+			t.Errorf("expected a full transfer, got %v", rrs[:2])
+		}
+	}
+}
+
+func fakeState(t *testing.T) state.State {
+	var sj state.State
+	b, err := ioutil.ReadFile("../factories/fake.json")
+	if err != nil {
+		t.Fatal(err)
+	} else if err = json.Unmarshal(b, &sj); err != nil {
+		t.Fatal(err)
+	}
+	return sj
+}